@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Audit log ────────────────────────────────────────────────────────────────
+
+// auditConfig configures the audit log. An empty Path disables it.
+type auditConfig struct {
+	Path     string
+	MaxSize  int64  // rotate before the file grows beyond this many bytes; 0 never rotates
+	MaxFiles int    // rotated files kept; 0 keeps all
+	Key      []byte // HMAC key of the chain; nil hashes with plain SHA-256
+	FailOpen bool   // return the result of a call whose record could not be written
+}
+
+// auditConfigFromEnv reads the audit log settings from the environment.
+//
+//	MCP_AUDIT_FILE       – append one hash-chained JSON record per tool call to this file
+//	MCP_AUDIT_MAX_SIZE   – rotate the file at this size in MB (default 100, 0 disables rotation)
+//	MCP_AUDIT_MAX_FILES  – number of rotated files kept (default 10, 0 keeps all)
+//	MCP_AUDIT_KEY        – key of the chain's HMAC-SHA256; keep it away from the
+//	                       log, so that whoever can write the log cannot rebuild
+//	                       the chain (default: unkeyed SHA-256)
+//	MCP_AUDIT_FAIL_OPEN  – "true" returns the result of a call whose record
+//	                       could not be written (default: an error result)
+func auditConfigFromEnv() (auditConfig, error) {
+	cfg := auditConfig{Path: os.Getenv("MCP_AUDIT_FILE"), MaxSize: 100 << 20, MaxFiles: 10}
+	if s := os.Getenv("MCP_AUDIT_KEY"); s != "" {
+		cfg.Key = []byte(s)
+	}
+	if s := os.Getenv("MCP_AUDIT_FAIL_OPEN"); s != "" {
+		failOpen, err := strconv.ParseBool(s)
+		if err != nil {
+			return cfg, fmt.Errorf("MCP_AUDIT_FAIL_OPEN: %w", err)
+		}
+		cfg.FailOpen = failOpen
+	}
+	if s := os.Getenv("MCP_AUDIT_MAX_SIZE"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MCP_AUDIT_MAX_SIZE: expected a size in MB, got %q", s)
+		}
+		cfg.MaxSize = n << 20
+	}
+	if s := os.Getenv("MCP_AUDIT_MAX_FILES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MCP_AUDIT_MAX_FILES: expected a number of files, got %q", s)
+		}
+		cfg.MaxFiles = n
+	}
+	return cfg, nil
+}
+
+// Outcomes of audited tool calls.
+const (
+	auditSuccess   = "success"
+	auditError     = "error"      // error result; see ErrorClass
+	auditBAPIError = "bapi_error" // the call returned BAPI error or abort messages
+)
+
+// auditRecord is one line of the audit log. Hash is the SHA-256, or the
+// HMAC-SHA256 if a key is configured, of the line's JSON without the hash
+// member, which includes PrevHash, the hash of the previous record, so that
+// removing, inserting or editing a record breaks the chain. Without a key,
+// whoever can write the log can also rebuild the chain after editing it.
+type auditRecord struct {
+	Seq         int64           `json:"seq"`
+	Time        time.Time       `json:"time"`
+	RequestID   string          `json:"request_id,omitempty"` // also on the call's log entries
+	Session     string          `json:"session,omitempty"`    // MCP session ID
+	Client      string          `json:"client,omitempty"`     // MCP client name and version
+	Principal   string          `json:"principal,omitempty"`  // authenticated HTTP caller
+	Tool        string          `json:"tool"`
+	System      string          `json:"system,omitempty"`
+	SAPUser     string          `json:"sap_user,omitempty"`
+	Functions   []string        `json:"functions,omitempty"` // function modules called, in order
+	Params      json.RawMessage `json:"params,omitempty"`    // tool arguments, redacted
+	Outcome     string          `json:"outcome"`
+	ErrorClass  string          `json:"error_class,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	DurationMS  float64         `json:"duration_ms"`
+	ResultBytes int             `json:"result_bytes"`
+	PrevHash    string          `json:"prev_hash"`
+	Hash        string          `json:"hash,omitempty"`
+}
+
+// auditLog appends auditRecords to a file, rotating it by size. A nil
+// *auditLog records nothing.
+type auditLog struct {
+	cfg    auditConfig
+	redact *redactor // applied to the arguments and error messages recorded
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	seq  int64
+	prev string // hash of the last record
+}
+
+// openAuditLog opens cfg.Path for appending and continues the chain of the
+// last record written, which may be in the newest rotated file. It returns
+// nil if auditing is disabled.
+func openAuditLog(cfg auditConfig, redact *redactor) (*auditLog, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	a := &auditLog{cfg: cfg, redact: redact}
+	files, err := auditFiles(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, err := lastAuditRecord(files[i], cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w; move the file away to start a new chain", files[i], err)
+		}
+		if last != nil {
+			a.seq, a.prev = last.Seq, last.Hash
+			break
+		}
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, st.Size()
+	return nil
+}
+
+// auditFiles returns the rotated files of path, oldest first, followed by
+// path itself if it exists.
+func auditFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range matches {
+		if _, err := time.Parse(auditRotateLayout, strings.TrimPrefix(m, path+".")); err == nil {
+			files = append(files, m)
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// auditRotateLayout is the suffix of rotated files; it sorts by time.
+const auditRotateLayout = "20060102T150405.000000000Z"
+
+// lastAuditRecord returns the last record of path, or nil if it has none.
+func lastAuditRecord(path string, key []byte) (*auditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) > 0 {
+			last = append(last[:0], sc.Bytes()...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	rec, err := parseAuditLine(last, key)
+	if err != nil {
+		return nil, fmt.Errorf("last record: %w", err)
+	}
+	return rec, nil
+}
+
+// parseAuditLine checks the hash of one line with key and decodes it. If
+// the hash does not match, the record is returned along with the error if
+// it can be decoded.
+func parseAuditLine(line []byte, key []byte) (*auditRecord, error) {
+	const suffixLen = len(`,"hash":"`) + sha256.Size*2 + len(`"}`)
+	if len(line) < suffixLen || !bytes.HasPrefix(line[len(line)-suffixLen:], []byte(`,"hash":"`)) || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, fmt.Errorf("no hash at the end of the record")
+	}
+	body := append(line[:len(line)-suffixLen:len(line)-suffixLen], '}')
+	want := string(line[len(line)-suffixLen+len(`,"hash":"`) : len(line)-2])
+	var rec auditRecord
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return nil, err
+	}
+	if got := auditHash(body, key); got != want {
+		return &rec, fmt.Errorf("hash mismatch: the record was modified or hashed with another key")
+	}
+	rec.Hash = want
+	return &rec, nil
+}
+
+// auditHash returns the HMAC-SHA256 of body with key, or its SHA-256 if key
+// is nil.
+func auditHash(body, key []byte) string {
+	if key == nil {
+		sum := sha256.Sum256(body)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// write chains rec to the previous record and appends it.
+func (a *auditLog) write(rec *auditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	rec.Seq, rec.PrevHash, rec.Hash = a.seq+1, a.prev, ""
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	hash := auditHash(body, a.cfg.Key)
+	line := append(body[:len(body)-1], `,"hash":"`+hash+`"}`+"\n"...)
+	if a.cfg.MaxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.cfg.MaxSize {
+		if err := a.rotate(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	a.seq, a.prev, rec.Hash = rec.Seq, hash, hash
+	return nil
+}
+
+// rotate renames the current file after the current time, opens a new one
+// and removes the oldest rotated files beyond MaxFiles.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	a.f = nil
+	if err := os.Rename(a.cfg.Path, a.cfg.Path+"."+time.Now().UTC().Format(auditRotateLayout)); err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	if a.cfg.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := auditFiles(a.cfg.Path)
+	if err != nil {
+		return err
+	}
+	rotated := files[:len(files)-1]
+	for len(rotated) > a.cfg.MaxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
+
+// auditCall collects what the connManager learns during one tool call: the
+// SAP system and user, and the function modules called. The Prometheus
+// metrics use it to label tool calls with their system.
+type auditCall struct {
+	mu        sync.Mutex
+	system    string
+	user      string
+	functions []string
+}
+
+type auditCallKey struct{}
+
+// withAuditCall returns the auditCall of ctx, adding one if there is none.
+func withAuditCall(ctx context.Context) (context.Context, *auditCall) {
+	if call, ok := ctx.Value(auditCallKey{}).(*auditCall); ok {
+		return ctx, call
+	}
+	call := &auditCall{}
+	return context.WithValue(ctx, auditCallKey{}, call), call
+}
+
+// auditRFC notes in the auditCall of ctx, if any, that the call used cm and,
+// unless funcName is empty, called funcName.
+func auditRFC(ctx context.Context, cm *connManager, funcName string) {
+	call, _ := ctx.Value(auditCallKey{}).(*auditCall)
+	if call == nil {
+		return
+	}
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	if call.system == "" {
+		call.system = cm.system
+	}
+	if call.user == "" {
+		if call.user = cm.identity().User; call.user == "" {
+			call.user = strings.ToUpper(cm.connParams["user"])
+		}
+	}
+	if funcName != "" {
+		call.functions = append(call.functions, funcName)
+	}
+}
+
+// wrap records every call of the tool name. If the record cannot be
+// written, the failure is logged and, unless the log fails open, the tool's
+// result is withheld: the call has been made, but no one may learn its
+// result without a trace.
+func (a *auditLog) wrap(name string, h mcp.ToolHandler) mcp.ToolHandler {
+	if a == nil {
+		return h
+	}
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, call := withAuditCall(ctx)
+		t0 := time.Now()
+		res, err := h(ctx, req)
+		rec := auditRecordFor(name, req, call, res, err, a.redact)
+		rec.Time, rec.DurationMS = t0.UTC(), float64(time.Since(t0).Microseconds())/1000
+		rec.RequestID = requestID(ctx)
+		if werr := a.write(rec); werr != nil {
+			logger.ErrorContext(ctx, "audit log write failed", "err", werr)
+			if !a.cfg.FailOpen {
+				return errResult(fmt.Errorf("the call was made, but its audit record could not be written, so its result is withheld: %w", werr)), nil
+			}
+		}
+		return res, err
+	}
+}
+
+// auditRecordFor describes a call of the tool name. The arguments are
+// redacted with the rules for the first function module called, or for the
+// tool if it called none.
+func auditRecordFor(name string, req *mcp.CallToolRequest, call *auditCall, res *mcp.CallToolResult, err error, redact *redactor) *auditRecord {
+	rec := &auditRecord{Tool: name, Outcome: auditSuccess}
+	if ss := req.Session; ss != nil {
+		rec.Session = ss.ID()
+		if p := ss.InitializeParams(); p != nil && p.ClientInfo != nil {
+			rec.Client = strings.TrimSpace(p.ClientInfo.Name + " " + p.ClientInfo.Version)
+		}
+	}
+	if req.Extra != nil && req.Extra.TokenInfo != nil {
+		rec.Principal = req.Extra.TokenInfo.UserID
+	}
+	call.mu.Lock()
+	rec.System, rec.SAPUser, rec.Functions = call.system, call.user, call.functions
+	call.mu.Unlock()
+	if req.Params != nil {
+		scope := name
+		if len(rec.Functions) > 0 {
+			scope = rec.Functions[0]
+		}
+		rec.Params = redact.arguments(scope, req.Params.Arguments)
+	}
+
+	switch {
+	case err != nil:
+		rec.Outcome, rec.Error = auditError, err.Error()
+	case res == nil:
+	case res.IsError:
+		if info, ok := res.StructuredContent.(rfcErrorInfo); ok {
+			rec.Outcome, rec.ErrorClass, rec.Error = auditError, info.Class, info.Message
+		} else {
+			rec.Outcome = auditBAPIError
+		}
+	}
+	rec.Error = redact.scrub(rec.Error)
+	rec.ResultBytes = resultBytes(res)
+	return rec
+}
+
+// resultBytes returns the size of the text content of res.
+func resultBytes(res *mcp.CallToolResult) int {
+	n := 0
+	if res != nil {
+		for _, c := range res.Content {
+			if tc, ok := c.(*mcp.TextContent); ok {
+				n += len(tc.Text)
+			}
+		}
+	}
+	return n
+}
+
+// ─── Audit verification ───────────────────────────────────────────────────────
+
+// auditReport is the result of verifyAuditLog.
+type auditReport struct {
+	Files    int
+	Records  int
+	First    int64 // sequence number of the first record
+	Last     int64
+	LastHash string
+	Problems []string
+}
+
+// verifyAuditLog checks the chain of path and its rotated files: every line
+// must carry the hash of its content, keyed with key, and the hash of its
+// predecessor, and sequence numbers must be contiguous. A chain whose first
+// record is not number 1 is reported in First, since rotation removes old
+// files.
+func verifyAuditLog(path string, key []byte) (*auditReport, error) {
+	files, err := auditFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no audit files", path)
+	}
+	rep := &auditReport{Files: len(files)}
+	var prev *auditRecord
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 64<<20)
+		for line := 1; sc.Scan(); line++ {
+			if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+				continue
+			}
+			rec, err := parseAuditLine(sc.Bytes(), key)
+			if err != nil {
+				rep.Problems = append(rep.Problems, fmt.Sprintf("%s:%d: %v", name, line, err))
+				// Go on from the sequence number the line claims, or else
+				// the next one, so that gaps behind it are still found.
+				switch {
+				case rec != nil:
+					prev = &auditRecord{Seq: rec.Seq}
+				case prev != nil:
+					prev = &auditRecord{Seq: prev.Seq + 1}
+				}
+				continue
+			}
+			if rep.Records == 0 {
+				rep.First = rec.Seq
+			}
+			switch {
+			case prev == nil:
+			case rec.Seq != prev.Seq+1:
+				rep.Problems = append(rep.Problems, fmt.Sprintf("%s:%d: record %d follows record %d", name, line, rec.Seq, prev.Seq))
+			case prev.Hash != "" && rec.PrevHash != prev.Hash:
+				rep.Problems = append(rep.Problems, fmt.Sprintf("%s:%d: record %d does not chain to record %d", name, line, rec.Seq, prev.Seq))
+			}
+			rep.Records++
+			rep.Last, rep.LastHash = rec.Seq, rec.Hash
+			prev = rec
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return rep, nil
+}
+
+// runAuditVerify implements the verify-audit subcommand and returns the
+// exit code. A keyed chain is checked with MCP_AUDIT_KEY.
+func runAuditVerify(args []string) int {
+	path := os.Getenv("MCP_AUDIT_FILE")
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "usage: gorfc-mcp-server verify-audit [FILE] (default: $MCP_AUDIT_FILE; key: $MCP_AUDIT_KEY)")
+		return 2
+	}
+	var key []byte
+	if s := os.Getenv("MCP_AUDIT_KEY"); s != "" {
+		key = []byte(s)
+	}
+	rep, err := verifyAuditLog(path, key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, p := range rep.Problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d records (%d to %d) in %d files, last hash %s\n", rep.Records, rep.First, rep.Last, rep.Files, rep.LastHash)
+	if rep.First > 1 {
+		fmt.Printf("the chain starts at record %d; earlier files were rotated away\n", rep.First)
+	}
+	if len(rep.Problems) > 0 {
+		fmt.Printf("FAILED: %d problems\n", len(rep.Problems))
+		return 1
+	}
+	fmt.Println("OK")
+	return 0
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── RFC backend ──────────────────────────────────────────────────────────────
+
+// rfcConn is the part of *gorfc.Connection used by connManager. Tests
+// substitute an in-process fake.
+type rfcConn interface {
+	Ping() error
+	GetConnectionAttributes() (gorfc.ConnectionAttributes, error)
+	GetFunctionDescription(name string) (gorfc.FunctionDescription, error)
+	Call(name string, params interface{}) (map[string]interface{}, error)
+	Close() error
+}
+
+// dialFunc opens a connection to the system described by params.
+type dialFunc func(params gorfc.ConnectionParameters) (rfcConn, error)
+
+// dialGorfc opens a real connection through the NW RFC SDK.
+func dialGorfc(params gorfc.ConnectionParameters) (rfcConn, error) {
+	conn, err := gorfc.ConnectionFromParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
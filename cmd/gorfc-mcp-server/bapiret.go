@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── BAPI return messages ─────────────────────────────────────────────────────
+
+// bapiReturnTypes are the DDIC structures in which BAPIs report messages,
+// usually through a parameter named RETURN, instead of raising exceptions.
+var bapiReturnTypes = map[string]bool{
+	"BAPIRET1":    true,
+	"BAPIRET2":    true,
+	"BAPIRETURN":  true,
+	"BAPIRETURN1": true,
+}
+
+// bapiMessage is one row of a BAPI return parameter.
+type bapiMessage struct {
+	Parameter string `json:"parameter"`
+	Type      string `json:"type"` // S success, I info, W warning, E error, A abort
+	ID        string `json:"id,omitempty"`
+	Number    string `json:"number,omitempty"`
+	Code      string `json:"code,omitempty"` // BAPIRETURN only
+	Message   string `json:"message"`
+}
+
+func (msg bapiMessage) String() string {
+	key := msg.Code
+	if msg.ID != "" || msg.Number != "" {
+		key = msg.ID + "/" + msg.Number
+	}
+	if key == "" {
+		return msg.Type + " " + msg.Message
+	}
+	return msg.Type + " " + key + ": " + msg.Message
+}
+
+// bapiError reports a call that returned error or abort messages.
+type bapiError struct {
+	Function string
+	Messages []bapiMessage // the E and A messages
+}
+
+func (e *bapiError) Error() string {
+	return fmt.Sprintf("%s returned %d error message(s), first: %s", e.Function, len(e.Messages), e.Messages[0])
+}
+
+// bapiMessages extracts the messages of the BAPI return parameters of desc
+// from result, in parameter order. Rows without type and text are skipped.
+func bapiMessages(desc gorfc.FunctionDescription, result map[string]interface{}) []bapiMessage {
+	var msgs []bapiMessage
+	for _, p := range desc.Parameters {
+		if p.Direction == "RFC_IMPORT" || !bapiReturnTypes[p.TypeDesc.Name] {
+			continue
+		}
+		var rows []interface{}
+		switch v := result[p.Name].(type) {
+		case map[string]interface{}:
+			rows = []interface{}{v}
+		case []interface{}:
+			rows = v
+		}
+		for _, r := range rows {
+			row, _ := r.(map[string]interface{})
+			msg := bapiMessage{
+				Parameter: p.Name,
+				Type:      bapiField(row, "TYPE"),
+				ID:        bapiField(row, "ID"),
+				Number:    bapiField(row, "NUMBER"),
+				Code:      bapiField(row, "CODE"),
+				Message:   bapiField(row, "MESSAGE"),
+			}
+			if msg.Type != "" || msg.Message != "" {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	return msgs
+}
+
+func bapiField(row map[string]interface{}, name string) string {
+	switch v := row[name].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// bapiFailure returns a *bapiError if msgs contain error or abort messages.
+func bapiFailure(funcName string, msgs []bapiMessage) error {
+	var failed []bapiMessage
+	for _, msg := range msgs {
+		if msg.Type == "E" || msg.Type == "A" {
+			failed = append(failed, msg)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &bapiError{Function: funcName, Messages: failed}
+}
+
+// bapiMessageTypes names the message types in summaries.
+var bapiMessageTypes = map[string]string{
+	"S": "success",
+	"I": "info",
+	"W": "warning",
+	"E": "error",
+	"A": "abort",
+}
+
+// bapiSummaryLimit is the number of messages listed in a summary.
+const bapiSummaryLimit = 10
+
+// bapiSummary condenses msgs into a few lines: counts per type, then the
+// messages, errors first.
+func bapiSummary(msgs []bapiMessage) string {
+	counts := map[string]int{}
+	for _, msg := range msgs {
+		counts[msg.Type]++
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if si, sj := bapiSeverity(types[i]), bapiSeverity(types[j]); si != sj {
+			return si > sj
+		}
+		return types[i] < types[j]
+	})
+	parts := make([]string, len(types))
+	for i, t := range types {
+		name, ok := bapiMessageTypes[t]
+		if !ok {
+			name = fmt.Sprintf("of type %q", t)
+		}
+		parts[i] = fmt.Sprintf("%d %s", counts[t], name)
+	}
+
+	sorted := append([]bapiMessage(nil), msgs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return bapiSeverity(sorted[i].Type) > bapiSeverity(sorted[j].Type) })
+	var b strings.Builder
+	fmt.Fprintf(&b, "BAPI messages: %s", strings.Join(parts, ", "))
+	for i, msg := range sorted {
+		if i == bapiSummaryLimit {
+			fmt.Fprintf(&b, "\n… %d more", len(sorted)-i)
+			break
+		}
+		b.WriteString("\n" + msg.String())
+	}
+	return b.String()
+}
+
+// bapiSeverity orders message types from abort down to success; unknown
+// types rank lowest.
+func bapiSeverity(msgType string) int {
+	if msgType == "" {
+		return -1
+	}
+	return strings.Index("SIWEA", msgType)
+}
+
+// bapiResult is the tool result of a successful call: the result as JSON,
+// followed by a summary of its BAPI messages if there are any. Error and
+// abort messages make it an error result.
+func bapiResult(result map[string]interface{}, msgs []bapiMessage) *mcp.CallToolResult {
+	res := jsonResult(result)
+	if len(msgs) == 0 {
+		return res
+	}
+	res.Content = append(res.Content, &mcp.TextContent{Text: bapiSummary(msgs)})
+	res.IsError = bapiFailure("", msgs) != nil
+	return res
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─── Metadata cache ───────────────────────────────────────────────────────────
+
+// Kinds of cached metadata.
+const (
+	cacheFunction = "function" // gorfc.FunctionDescription from GetFunctionDescription
+	cacheTable    = "table"    // DDIF_FIELDINFO_GET result
+)
+
+// cacheConfig bounds a metadataCache.
+type cacheConfig struct {
+	TTL     time.Duration // entries expire after this; 0 disables the cache
+	MaxSize int           // least recently used entries are evicted beyond this
+}
+
+// cacheConfigFromEnv reads cache settings from the environment.
+//
+//	MCP_CACHE_TTL   – lifetime of cached metadata (default 15m, 0 disables)
+//	MCP_CACHE_SIZE  – maximum number of cached entries (default 1000)
+func cacheConfigFromEnv() (cacheConfig, error) {
+	cfg := cacheConfig{TTL: 15 * time.Minute, MaxSize: 1000}
+	if s := os.Getenv("MCP_CACHE_TTL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return cfg, fmt.Errorf("MCP_CACHE_TTL: %w", err)
+		}
+		cfg.TTL = d
+	}
+	if s := os.Getenv("MCP_CACHE_SIZE"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return cfg, fmt.Errorf("MCP_CACHE_SIZE: %w", err)
+		}
+		cfg.MaxSize = n
+	}
+	if cfg.TTL < 0 || cfg.MaxSize < 1 {
+		return cfg, fmt.Errorf("cache TTL must not be negative and size must be at least 1")
+	}
+	return cfg, nil
+}
+
+type cacheKey struct {
+	kind   string
+	system string
+	name   string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   interface{}
+	expires time.Time
+}
+
+// metadataCache is an LRU cache of interface metadata keyed by kind, system
+// and name. Cached values are shared between callers and must not be
+// modified. A nil *metadataCache caches nothing.
+type metadataCache struct {
+	cfg cacheConfig
+	now func() time.Time // time.Now; replaced in tests
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[cacheKey]*list.Element
+
+	hits, misses, evictions int64
+}
+
+// newMetadataCache returns nil if cfg disables caching.
+func newMetadataCache(cfg cacheConfig) *metadataCache {
+	if cfg.TTL <= 0 {
+		return nil
+	}
+	return &metadataCache{cfg: cfg, now: time.Now, lru: list.New(), entries: make(map[cacheKey]*list.Element)}
+}
+
+func (c *metadataCache) get(kind, system, name string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := cacheKey{kind, strings.ToUpper(system), strings.ToUpper(name)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && c.now().After(el.Value.(*cacheEntry).expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+func (c *metadataCache) put(kind, system, name string, value interface{}) {
+	if c == nil {
+		return
+	}
+	key := cacheKey{kind, strings.ToUpper(system), strings.ToUpper(name)}
+	e := &cacheEntry{key: key, value: value, expires: c.now().Add(c.cfg.TTL)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.cfg.MaxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+// invalidate removes the entries of system matching kind and the name
+// pattern; empty kind or pattern match everything. It returns the number of
+// entries removed.
+func (c *metadataCache) invalidate(system, kind, pattern string) int {
+	if c == nil {
+		return 0
+	}
+	system, pattern = strings.ToUpper(system), strings.ToUpper(pattern)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, el := range c.entries {
+		if key.system != system || (kind != "" && key.kind != kind) {
+			continue
+		}
+		// Table entries are keyed "TABLE@LANG"; the pattern matches the table.
+		name, _, _ := strings.Cut(key.name, "@")
+		if pattern != "" && !wildcardMatch(pattern, name) {
+			continue
+		}
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		n++
+	}
+	return n
+}
+
+func (c *metadataCache) stats() map[string]interface{} {
+	if c == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":   true,
+		"entries":   c.lru.Len(),
+		"max_size":  c.cfg.MaxSize,
+		"ttl":       c.cfg.TTL.String(),
+		"hits":      c.hits,
+		"misses":    c.misses,
+		"evictions": c.evictions,
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Record and replay ────────────────────────────────────────────────────────
+
+// Operations stored in a cassette, one per rfcConn method (open is the dial).
+const (
+	opOpen       = "open"
+	opPing       = "ping"
+	opAttributes = "attributes"
+	opDescribe   = "describe"
+	opCall       = "call"
+)
+
+// cassetteEntry is one line of a cassette file. Params holds the normalized
+// call parameters (see normalizeParams) and Result of a call the type-tagged
+// result (see taggedValue); connection parameters are never written, so
+// cassettes contain no logon data.
+type cassetteEntry struct {
+	Time       time.Time       `json:"time"`
+	System     string          `json:"system"`
+	Op         string          `json:"op"`
+	Function   string          `json:"function,omitempty"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      *cassetteError  `json:"error,omitempty"`
+	DurationMS float64         `json:"duration_ms"`
+}
+
+// cassetteError keeps enough of an error to rebuild it on replay with the
+// same type and message, so that retries and error reporting behave alike.
+type cassetteError struct {
+	Type        string          `json:"type"` // "rfc" (*gorfc.RfcError), "gorfc" (*gorfc.GoRfcError) or "error"
+	Message     string          `json:"message"`
+	Description string          `json:"description,omitempty"`
+	Cause       string          `json:"cause,omitempty"` // GoRfcError.GoError
+	Info        json.RawMessage `json:"info,omitempty"`  // RfcError.ErrorInfo
+}
+
+func newCassetteError(err error) *cassetteError {
+	if err == nil {
+		return nil
+	}
+	ce := &cassetteError{Type: "error", Message: err.Error()}
+	var rfcErr *gorfc.RfcError
+	var goErr *gorfc.GoRfcError
+	switch {
+	case errors.As(err, &rfcErr):
+		ce.Type, ce.Description = "rfc", rfcErr.Description
+		ce.Info, _ = json.Marshal(rfcErr.ErrorInfo)
+	case errors.As(err, &goErr):
+		ce.Type, ce.Description = "gorfc", goErr.Description
+		if goErr.GoError != nil {
+			ce.Cause = goErr.GoError.Error()
+		}
+	}
+	return ce
+}
+
+func (ce *cassetteError) err() error {
+	if ce == nil {
+		return nil
+	}
+	switch ce.Type {
+	case "rfc":
+		e := &gorfc.RfcError{Description: ce.Description}
+		if len(ce.Info) > 0 {
+			json.Unmarshal(ce.Info, &e.ErrorInfo)
+		}
+		return e
+	case "gorfc":
+		e := &gorfc.GoRfcError{Description: ce.Description}
+		if ce.Cause != "" {
+			e.GoError = errors.New(ce.Cause)
+		}
+		return e
+	}
+	return errors.New(ce.Message)
+}
+
+// cassette records the RFC traffic of all systems to a JSONL file, or replays
+// a recorded file instead of connecting to SAP. A nil *cassette does neither.
+type cassette struct {
+	replay bool
+	path   string
+
+	mu  sync.Mutex
+	f   *os.File      // record mode
+	enc *json.Encoder // record mode
+
+	entries map[string][]cassetteEntry // replay mode, by cassetteKey
+	served  map[string]int
+}
+
+// cassetteFromEnv returns nil when neither variable is set.
+//
+//	MCP_RECORD  – append all RFC traffic to this JSONL file
+//	MCP_REPLAY  – serve RFC traffic from this JSONL file instead of SAP
+func cassetteFromEnv() (*cassette, error) {
+	rec, rep := os.Getenv("MCP_RECORD"), os.Getenv("MCP_REPLAY")
+	switch {
+	case rec != "" && rep != "":
+		return nil, fmt.Errorf("MCP_RECORD and MCP_REPLAY are mutually exclusive")
+	case rec != "":
+		c, err := recordCassette(rec)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_RECORD: %w", err)
+		}
+		return c, nil
+	case rep != "":
+		c, err := replayCassette(rep)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_REPLAY: %w", err)
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// recordCassette appends to path, so that several sessions can be recorded
+// into one file.
+func recordCassette(path string) (*cassette, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &cassette{path: path, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func replayCassette(path string) (*cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := &cassette{replay: true, path: path, entries: map[string][]cassetteEntry{}, served: map[string]int{}}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var e cassetteEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		var params interface{}
+		if len(e.Params) > 0 {
+			if err := json.Unmarshal(e.Params, &params); err != nil {
+				return nil, fmt.Errorf("%s:%d: params: %w", path, line, err)
+			}
+		}
+		key, err := cassetteKey(e.System, e.Op, e.Function, params)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		c.entries[key] = append(c.entries[key], e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cassette) close() error {
+	if c == nil || c.f == nil {
+		return nil
+	}
+	return c.f.Close()
+}
+
+// normalizeParams converts v to its JSON form with upper-case field names and
+// trailing blanks removed from strings, so that parameters that reach SAP as
+// the same values compare equal.
+func normalizeParams(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	var norm func(interface{}) interface{}
+	norm = func(v interface{}) interface{} {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(x))
+			for k, val := range x {
+				out[strings.ToUpper(k)] = norm(val)
+			}
+			return out
+		case []interface{}:
+			out := make([]interface{}, len(x))
+			for i, val := range x {
+				out[i] = norm(val)
+			}
+			return out
+		case string:
+			return strings.TrimRight(x, " ")
+		}
+		return v
+	}
+	return norm(generic), nil
+}
+
+// taggedValue prepares a call result for recording. Values that JSON would
+// not bring back with the type gorfc returns them in become objects with a
+// single member naming the type: {"$int32": 5} for integers of every size,
+// {"$time": "2024-03-01T00:00:00Z"} for dates and times and {"$bytes": "AAH/"}
+// for raw bytes. Strings, floats and booleans are kept as they are.
+func taggedValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, string, bool, float64:
+		return v
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			out[k] = taggedValue(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = taggedValue(val)
+		}
+		return out
+	case time.Time:
+		return map[string]interface{}{"$time": x.Format(time.RFC3339Nano)}
+	case []byte:
+		return map[string]interface{}{"$bytes": x}
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"$" + rv.Kind().String(): rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"$" + rv.Kind().String(): rv.Uint()}
+	case reflect.Float32:
+		return map[string]interface{}{"$float32": rv.Float()}
+	}
+	return v
+}
+
+// decodeTagged decodes a result recorded with taggedValue, restoring the
+// tagged types. Results recorded without tags decode as plain JSON.
+func decodeTagged(raw json.RawMessage) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var out map[string]interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	for k, v := range out {
+		val, err := untagValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		out[k] = val
+	}
+	return out, nil
+}
+
+func untagValue(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case json.Number:
+		return x.Float64()
+	case []interface{}:
+		for i, val := range x {
+			var err error
+			if x[i], err = untagValue(val); err != nil {
+				return nil, err
+			}
+		}
+		return x, nil
+	case map[string]interface{}:
+		if len(x) == 1 {
+			for k, val := range x {
+				if strings.HasPrefix(k, "$") {
+					return untag(k[1:], val)
+				}
+			}
+		}
+		for k, val := range x {
+			var err error
+			if x[k], err = untagValue(val); err != nil {
+				return nil, err
+			}
+		}
+		return x, nil
+	}
+	return v, nil
+}
+
+// untag rebuilds a value tagged by taggedValue.
+func untag(typ string, v interface{}) (interface{}, error) {
+	s := fmt.Sprint(v)
+	switch typ {
+	case "time":
+		return time.Parse(time.RFC3339Nano, s)
+	case "bytes":
+		return base64.StdEncoding.DecodeString(s)
+	case "float32":
+		f, err := strconv.ParseFloat(s, 32)
+		return float32(f), err
+	case "int", "int8", "int16", "int32", "int64":
+		n, err := strconv.ParseInt(s, 10, 64)
+		switch typ {
+		case "int":
+			return int(n), err
+		case "int8":
+			return int8(n), err
+		case "int16":
+			return int16(n), err
+		case "int32":
+			return int32(n), err
+		}
+		return n, err
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		n, err := strconv.ParseUint(s, 10, 64)
+		switch typ {
+		case "uint":
+			return uint(n), err
+		case "uint8":
+			return uint8(n), err
+		case "uint16":
+			return uint16(n), err
+		case "uint32":
+			return uint32(n), err
+		}
+		return n, err
+	}
+	return nil, fmt.Errorf("unknown type tag $%s", typ)
+}
+
+// cassetteKey identifies the recordings a request may be answered from.
+func cassetteKey(system, op, function string, params interface{}) (string, error) {
+	norm, err := normalizeParams(params)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(norm) // map keys are sorted
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(system) + "\x00" + op + "\x00" + strings.ToUpper(function) + "\x00" + string(b), nil
+}
+
+// wrap returns the dialFunc for system: next with its traffic recorded, or a
+// replay that never calls next.
+func (c *cassette) wrap(system string, next dialFunc) dialFunc {
+	if c == nil {
+		return next
+	}
+	if c.replay {
+		return func(gorfc.ConnectionParameters) (rfcConn, error) {
+			if e, ok := c.next(system, opOpen, "", nil); ok && e.Error != nil {
+				return nil, e.Error.err()
+			}
+			return &replayConn{c: c, system: system}, nil
+		}
+	}
+	return func(params gorfc.ConnectionParameters) (rfcConn, error) {
+		t0 := time.Now()
+		conn, err := next(params)
+		c.record(system, opOpen, "", nil, nil, err, t0)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingConn{rfcConn: conn, c: c, system: system}, nil
+	}
+}
+
+// record appends one entry. Write failures are logged and do not fail the
+// call being recorded.
+func (c *cassette) record(system, op, function string, params, result interface{}, err error, t0 time.Time) {
+	e := cassetteEntry{
+		Time:       t0.UTC(),
+		System:     system,
+		Op:         op,
+		Function:   function,
+		Error:      newCassetteError(err),
+		DurationMS: float64(time.Since(t0).Microseconds()) / 1000,
+	}
+	var merr error
+	if params != nil {
+		var norm interface{}
+		if norm, merr = normalizeParams(params); merr == nil {
+			e.Params, merr = json.Marshal(norm)
+		}
+	}
+	if merr == nil && err == nil && result != nil {
+		e.Result, merr = json.Marshal(result)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if merr == nil {
+		merr = c.enc.Encode(e)
+	}
+	if merr != nil {
+		logger.Error("recording RFC traffic failed", "op", op, "function", function, "err", merr)
+	}
+}
+
+// next returns the recording for a request. Identical requests are answered
+// in recorded order; once they are used up the last one is repeated.
+func (c *cassette) next(system, op, function string, params interface{}) (cassetteEntry, bool) {
+	key, err := cassetteKey(system, op, function, params)
+	if err != nil {
+		return cassetteEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := c.entries[key]
+	if len(list) == 0 {
+		return cassetteEntry{}, false
+	}
+	i := c.served[key]
+	if i < len(list)-1 {
+		c.served[key]++
+	}
+	return list[i], true
+}
+
+// replayed decodes the recording for a request into dst, or returns its
+// recorded error.
+func (c *cassette) replayed(system, op, function string, params interface{}, dst interface{}) error {
+	e, ok := c.next(system, op, function, params)
+	if !ok {
+		if function == "" {
+			return fmt.Errorf("replay %s: no %s recorded for system %s", c.path, op, system)
+		}
+		return fmt.Errorf("replay %s: no %s of %s with these parameters recorded for system %s", c.path, op, function, system)
+	}
+	if e.Error != nil {
+		return e.Error.err()
+	}
+	if dst == nil || len(e.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(e.Result, dst)
+}
+
+// recordingConn passes calls through to a real connection and records them.
+type recordingConn struct {
+	rfcConn
+	c      *cassette
+	system string
+}
+
+func (r *recordingConn) Ping() error {
+	t0 := time.Now()
+	err := r.rfcConn.Ping()
+	r.c.record(r.system, opPing, "", nil, nil, err, t0)
+	return err
+}
+
+func (r *recordingConn) GetConnectionAttributes() (gorfc.ConnectionAttributes, error) {
+	t0 := time.Now()
+	attrs, err := r.rfcConn.GetConnectionAttributes()
+	r.c.record(r.system, opAttributes, "", nil, attrs, err, t0)
+	return attrs, err
+}
+
+func (r *recordingConn) GetFunctionDescription(name string) (gorfc.FunctionDescription, error) {
+	t0 := time.Now()
+	desc, err := r.rfcConn.GetFunctionDescription(name)
+	r.c.record(r.system, opDescribe, name, nil, desc, err, t0)
+	return desc, err
+}
+
+func (r *recordingConn) Call(name string, params interface{}) (map[string]interface{}, error) {
+	t0 := time.Now()
+	out, err := r.rfcConn.Call(name, params)
+	var result interface{}
+	if out != nil {
+		result = taggedValue(out)
+	}
+	r.c.record(r.system, opCall, name, params, result, err, t0)
+	return out, err
+}
+
+// replayConn answers from the cassette. Ping and connection attributes fall
+// back to success and no attributes when none were recorded.
+type replayConn struct {
+	c      *cassette
+	system string
+}
+
+func (r *replayConn) Ping() error {
+	if e, ok := r.c.next(r.system, opPing, "", nil); ok {
+		return e.Error.err()
+	}
+	return nil
+}
+
+func (r *replayConn) GetConnectionAttributes() (gorfc.ConnectionAttributes, error) {
+	attrs := gorfc.ConnectionAttributes{}
+	e, ok := r.c.next(r.system, opAttributes, "", nil)
+	switch {
+	case !ok:
+		return attrs, nil
+	case e.Error != nil:
+		return nil, e.Error.err()
+	}
+	err := json.Unmarshal(e.Result, &attrs)
+	return attrs, err
+}
+
+func (r *replayConn) GetFunctionDescription(name string) (gorfc.FunctionDescription, error) {
+	var desc gorfc.FunctionDescription
+	err := r.c.replayed(r.system, opDescribe, name, nil, &desc)
+	return desc, err
+}
+
+func (r *replayConn) Call(name string, params interface{}) (map[string]interface{}, error) {
+	var raw json.RawMessage
+	if err := r.c.replayed(r.system, opCall, name, params, &raw); err != nil || len(raw) == 0 {
+		return nil, err
+	}
+	out, err := decodeTagged(raw)
+	if err != nil {
+		return nil, fmt.Errorf("replay %s: result of %s: %w", r.c.path, name, err)
+	}
+	return out, nil
+}
+
+func (r *replayConn) Close() error { return nil }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ─── Exact numbers ────────────────────────────────────────────────────────────
+
+// decodeArguments decodes tool arguments into v, keeping numbers as
+// json.Number so that packed decimals and 8-byte integers reach SAP with
+// every digit instead of passing through float64.
+func decodeArguments(raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// exactDecimal formats val, a number or a decimal string, for a BCD field
+// with the given number of decimals (decimals < 0: DECF, any number). gorfc
+// formats float64 values with %g, which loses digits and switches to
+// exponent notation, so decimals are always passed as strings. Values with
+// more decimal places than declared are rejected instead of being rounded
+// by SAP.
+func exactDecimal(val interface{}, decimals int) (string, error) {
+	var s string
+	switch v := val.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = abapSign(strings.TrimSpace(v))
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		s = strconv.Itoa(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	default:
+		return "", fmt.Errorf("cannot coerce %T to a decimal", val)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.Contains(s, "/") {
+		return "", fmt.Errorf("expected a decimal number, got %q", s)
+	}
+	if decimals < 0 {
+		return s, nil
+	}
+	out := r.FloatString(decimals)
+	if back, _ := new(big.Rat).SetString(out); back.Cmp(r) != 0 {
+		return "", fmt.Errorf("%s has more than %d decimal places", s, decimals)
+	}
+	return out, nil
+}
+
+// abapSign moves the trailing minus sign of ABAP's number format to the
+// front, e.g. "12.50-" becomes "-12.50".
+func abapSign(s string) string {
+	if t, ok := strings.CutSuffix(s, "-"); ok && t != "" {
+		return "-" + strings.TrimSpace(t)
+	}
+	return s
+}
+
+// exactInt converts val to an integer within [min, max].
+func exactInt(val interface{}, min, max int64) (int, error) {
+	var i int64
+	switch v := val.(type) {
+	case json.Number:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected integer, got %s", v)
+		}
+		i = n
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v > math.MaxInt64 {
+			return 0, fmt.Errorf("expected integer, got %v", v)
+		}
+		i = int64(v)
+	case int:
+		i = int64(v)
+	case int64:
+		i = v
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected integer, got %q", v)
+		}
+		i = n
+	default:
+		return 0, fmt.Errorf("cannot coerce %T to an integer", val)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d]", i, min, max)
+	}
+	return int(i), nil
+}
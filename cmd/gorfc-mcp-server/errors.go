@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Error classification ─────────────────────────────────────────────────────
+
+// Error classes reported to MCP clients in rfcErrorInfo.Class.
+const (
+	errCommunication    = "communication"      // SAP could not be reached or the connection broke
+	errLogon            = "logon"              // wrong credentials, locked user, invalid client
+	errABAPException    = "abap_exception"     // classic or class-based exception raised by the function; see Key
+	errABAPRuntime      = "abap_runtime_error" // short dump in the backend
+	errSystemFailure    = "system_failure"     // ABAP message or other failure of the backend or the SDK
+	errAuthorization    = "authorization"      // missing S_RFC or similar authorization, or refused by the call policy
+	errInvalidParameter = "invalid_parameter"  // arguments that do not fit the function's interface
+	errCancelled        = "cancelled"          // cancelled by the client or timed out
+	errOther            = "other"              // any other error, e.g. invalid tool arguments
+)
+
+// rfcErrorInfo is the structured form of an error, returned to MCP clients
+// as the structured content of error results.
+type rfcErrorInfo struct {
+	Class         string `json:"class"`
+	Code          string `json:"code,omitempty"`  // NW RFC SDK return code, e.g. RFC_ABAP_EXCEPTION
+	Group         string `json:"group,omitempty"` // NW RFC SDK error group, e.g. ABAP_APPLICATION_FAILURE
+	Key           string `json:"key,omitempty"`   // ABAP exception or short dump name
+	Message       string `json:"message"`
+	AbapMsgClass  string `json:"abap_msg_class,omitempty"`
+	AbapMsgType   string `json:"abap_msg_type,omitempty"`
+	AbapMsgNumber string `json:"abap_msg_number,omitempty"`
+	Retryable     bool   `json:"retryable"`
+}
+
+// rfcReturnCodes maps NW RFC SDK return codes to their error group and
+// class. Codes not listed are system failures. Only communication failures
+// that leave no doubt about the connection being unusable are retryable.
+var rfcReturnCodes = map[string]struct {
+	group, class string
+	retryable    bool
+}{
+	"RFC_COMMUNICATION_FAILURE":       {"COMMUNICATION_FAILURE", errCommunication, true},
+	"RFC_INVALID_HANDLE":              {"COMMUNICATION_FAILURE", errCommunication, true},
+	"RFC_CLOSED":                      {"COMMUNICATION_FAILURE", errCommunication, true},
+	"RFC_IO_FAILURE":                  {"COMMUNICATION_FAILURE", errCommunication, true},
+	"RFC_TIMEOUT":                     {"COMMUNICATION_FAILURE", errCommunication, false},
+	"RFC_LOGON_FAILURE":               {"LOGON_FAILURE", errLogon, false},
+	"RFC_AUTHENTICATION_FAILURE":      {"EXTERNAL_AUTHENTICATION_FAILURE", errLogon, false},
+	"RFC_AUTHORIZATION_FAILURE":       {"EXTERNAL_AUTHORIZATION_FAILURE", errAuthorization, false},
+	"RFC_ABAP_EXCEPTION":              {"ABAP_APPLICATION_FAILURE", errABAPException, false},
+	"RFC_ABAP_CLASS_EXCEPTION":        {"ABAP_APPLICATION_FAILURE", errABAPException, false},
+	"RFC_ABAP_RUNTIME_FAILURE":        {"ABAP_RUNTIME_FAILURE", errABAPRuntime, false},
+	"RFC_ABAP_MESSAGE":                {"ABAP_RUNTIME_FAILURE", errSystemFailure, false},
+	"RFC_INVALID_PARAMETER":           {"EXTERNAL_RUNTIME_FAILURE", errInvalidParameter, false},
+	"RFC_CONVERSION_FAILURE":          {"EXTERNAL_RUNTIME_FAILURE", errInvalidParameter, false},
+	"RFC_CODEPAGE_CONVERSION_FAILURE": {"EXTERNAL_RUNTIME_FAILURE", errInvalidParameter, false},
+	"RFC_BUFFER_TOO_SMALL":            {"EXTERNAL_RUNTIME_FAILURE", errInvalidParameter, false},
+	"RFC_NOT_FOUND":                   {"EXTERNAL_RUNTIME_FAILURE", errInvalidParameter, false},
+	"RFC_CANCELED":                    {"EXTERNAL_RUNTIME_FAILURE", errCancelled, false},
+}
+
+// authorizationKeys are the ABAP exceptions and short dumps that report a
+// missing authorization, e.g. RFC_NO_AUTHORITY when S_RFC does not permit
+// the call or NOT_AUTHORIZED from RFC_READ_TABLE. They are classified as
+// errAuthorization whatever the return code.
+var authorizationKeys = map[string]bool{
+	"RFC_NO_AUTHORITY": true,
+	"NO_AUTHORITY":     true,
+	"NO_AUTHORIZATION": true,
+	"NOT_AUTHORIZED":   true,
+}
+
+// handleMismatch appears in the errors of a call whose connection handle
+// no longer belongs to the session it was opened for, e.g. after the SDK
+// reconnected it. The connection is unusable, so the call is retried.
+const handleMismatch = "HANDLE_MISMATCH"
+
+// gorfcNotConnected is the description of the error gorfc returns for calls
+// on a closed connection.
+const gorfcNotConnected = "Call() method requires an open connection"
+
+// invalidParameterError marks errors in the arguments of a call that were
+// found before SAP was asked.
+type invalidParameterError struct{ err error }
+
+func (e *invalidParameterError) Error() string { return e.err.Error() }
+func (e *invalidParameterError) Unwrap() error { return e.err }
+
+// classifyError maps err to its class and, for errors of the NW RFC SDK, the
+// details SAP reported.
+func classifyError(err error) rfcErrorInfo {
+	info := rfcErrorInfo{Class: errOther, Message: err.Error()}
+	var rfcErr *gorfc.RfcError
+	var goErr *gorfc.GoRfcError
+	var pe *policyError
+	var ipe *invalidParameterError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		info.Class = errCancelled
+	case errors.As(err, &rfcErr):
+		ei := rfcErr.ErrorInfo
+		info.Code, info.Key = ei.Code, ei.Key
+		info.AbapMsgClass, info.AbapMsgType, info.AbapMsgNumber = ei.AbapMsgClass, ei.AbapMsgType, ei.AbapMsgNumber
+		info.Message = rfcErr.Description
+		if ei.Message != "" {
+			info.Message = ei.Message
+		}
+		info.Class = errSystemFailure
+		if rc, ok := rfcReturnCodes[ei.Code]; ok {
+			info.Group, info.Class, info.Retryable = rc.group, rc.class, rc.retryable
+		}
+		switch {
+		case authorizationKeys[ei.Key]:
+			info.Class = errAuthorization
+		case ei.Key == handleMismatch || strings.Contains(info.Message, handleMismatch):
+			info.Class, info.Retryable = errCommunication, true
+		}
+	case errors.As(err, &goErr):
+		// gorfc's own errors are conversion errors, except for calls on a
+		// closed or mismatched handle.
+		info.Class = errInvalidParameter
+		if goErr.Description == gorfcNotConnected || strings.Contains(goErr.Description, handleMismatch) {
+			info.Class, info.Retryable = errCommunication, true
+		}
+	case errors.As(err, &pe):
+		info.Class = errAuthorization
+	case errors.As(err, &ipe):
+		info.Class = errInvalidParameter
+	}
+	return info
+}
+
+// isConnErr reports whether err means the connection is unusable, so that
+// retrying on a new connection may succeed.
+func isConnErr(err error) bool {
+	return err != nil && classifyError(err).Retryable
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// fakeSAP is an in-process SAP system for unit tests. It serves canned
+// function descriptions and results through the rfcConn interface, with
+// implementations of STFC_CONNECTION, RFC_READ_TABLE and DDIF_FIELDINFO_GET
+// over in-memory tables, and of /BODS/RFC_READ_TABLE2 once addBODSReader is
+// called. Communication failures can be injected.
+type fakeSAP struct {
+	mu        sync.Mutex
+	attrs     gorfc.ConnectionAttributes
+	functions map[string]*fakeFunction
+	tables    map[string]*fakeTable
+	failures  int           // operations that fail with RFC_COMMUNICATION_FAILURE
+	block     chan struct{} // if set, Call waits until it is closed
+	dials     int
+	calls     []string // function modules called, in order
+}
+
+// fakeFunction is a function module of a fakeSAP. A nil call returns an
+// empty result.
+type fakeFunction struct {
+	desc gorfc.FunctionDescription
+	call func(params map[string]interface{}) (map[string]interface{}, error)
+}
+
+type fakeTable struct {
+	fields []fakeField
+	rows   [][]string // values in field order
+}
+
+type fakeField struct {
+	name      string
+	intType   string
+	length    int
+	outputLen int
+	decimals  int
+	key       bool
+	convExit  string
+	text      string
+}
+
+func newFakeSAP() *fakeSAP {
+	f := &fakeSAP{
+		attrs: gorfc.ConnectionAttributes{
+			"sysId":       "FAK",
+			"client":      "100",
+			"user":        "TESTER",
+			"host":        "fakehost",
+			"isoLanguage": "EN",
+		},
+		functions: map[string]*fakeFunction{},
+		tables:    map[string]*fakeTable{},
+	}
+	f.addFunction(gorfc.FunctionDescription{
+		Name: "STFC_CONNECTION",
+		Parameters: []gorfc.ParameterDescription{
+			charParam("REQUTEXT", "RFC_IMPORT", 255),
+			charParam("ECHOTEXT", "RFC_EXPORT", 255),
+			charParam("RESPTEXT", "RFC_EXPORT", 255),
+		},
+	}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		text, _ := params["REQUTEXT"].(string)
+		return map[string]interface{}{
+			"ECHOTEXT": text,
+			"RESPTEXT": "SAP R/3 Rel. 758 Sysid: FAK Client: 100",
+		}, nil
+	})
+	f.addFunction(gorfc.FunctionDescription{
+		Name: "RFC_READ_TABLE",
+		Parameters: []gorfc.ParameterDescription{
+			charParam("QUERY_TABLE", "RFC_IMPORT", 30),
+			charParam("DELIMITER", "RFC_IMPORT", 1),
+			charParam("NO_DATA", "RFC_IMPORT", 1),
+			{Name: "ROWSKIPS", ParameterType: "RFCTYPE_INT", Direction: "RFC_IMPORT", NucLength: 4},
+			{Name: "ROWCOUNT", ParameterType: "RFCTYPE_INT", Direction: "RFC_IMPORT", NucLength: 4},
+			tableParam("OPTIONS", "RFC_DB_OPT", 72, "TEXT"),
+			tableParam("FIELDS", "RFC_DB_FLD", 103, "FIELDNAME", "OFFSET", "LENGTH", "TYPE", "FIELDTEXT"),
+			tableParam("DATA", "TAB512", 512, "WA"),
+		},
+	}, f.readTable)
+	f.addFunction(gorfc.FunctionDescription{
+		Name: "DDIF_FIELDINFO_GET",
+		Parameters: []gorfc.ParameterDescription{
+			charParam("TABNAME", "RFC_IMPORT", 30),
+			charParam("LANGU", "RFC_IMPORT", 1),
+			tableParam("DFIES_TAB", "DFIES", 1000, "FIELDNAME", "INTTYPE", "LENG", "OUTPUTLEN", "DECIMALS", "KEYFLAG", "CONVEXIT", "FIELDTEXT"),
+		},
+	}, f.fieldInfo)
+
+	f.addTable("T000", []fakeField{
+		{name: "MANDT", intType: "C", length: 3, key: true, text: "Client"},
+		{name: "MTEXT", intType: "C", length: 25, text: "Client name"},
+		{name: "ORT01", intType: "C", length: 25, text: "City"},
+	}, [][]string{
+		{"000", "SAP AG", "Walldorf"},
+		{"001", "Auslieferungsmandant R11", "Kundstadt"},
+		{"100", "Test client", "Berlin"},
+	})
+	f.addTable("DD02T", []fakeField{
+		{name: "TABNAME", intType: "C", length: 30, key: true},
+		{name: "DDLANGUAGE", intType: "C", length: 1, key: true},
+		{name: "DDTEXT", intType: "C", length: 60},
+	}, [][]string{
+		{"MARA", "E", "General Material Data"},
+		{"MAKT", "E", "Material Descriptions"},
+		{"T000", "E", "Clients"},
+		{"MARA", "D", "Allgemeine Materialdaten"},
+	})
+	return f
+}
+
+func charParam(name, direction string, length uint) gorfc.ParameterDescription {
+	return gorfc.ParameterDescription{Name: name, ParameterType: "RFCTYPE_CHAR", Direction: direction, NucLength: length, UcLength: 2 * length}
+}
+
+func tableParam(name, typeName string, width uint, fields ...string) gorfc.ParameterDescription {
+	td := gorfc.TypeDescription{Name: typeName, NucLength: width, UcLength: 2 * width}
+	for _, fn := range fields {
+		td.Fields = append(td.Fields, gorfc.FieldDescription{Name: fn, FieldType: "RFCTYPE_CHAR"})
+	}
+	return gorfc.ParameterDescription{Name: name, ParameterType: "RFCTYPE_TABLE", Direction: "RFC_TABLES", TypeDesc: td}
+}
+
+func (f *fakeSAP) addFunction(desc gorfc.FunctionDescription, call func(map[string]interface{}) (map[string]interface{}, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.functions[desc.Name] = &fakeFunction{desc: desc, call: call}
+}
+
+func (f *fakeSAP) addTable(name string, fields []fakeField, rows [][]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables[name] = &fakeTable{fields: fields, rows: rows}
+}
+
+// failNext makes the next n operations fail with a communication failure.
+func (f *fakeSAP) failNext(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func (f *fakeSAP) called() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// fail consumes one injected failure.
+func (f *fakeSAP) fail() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures == 0 {
+		return nil
+	}
+	f.failures--
+	return rfcErr("RFC_COMMUNICATION_FAILURE", "", "RFC_COMMUNICATION_FAILURE: connection reset by fake")
+}
+
+// rfcErr builds an error as the NW RFC SDK reports it, with return code
+// code and, for ABAP exceptions, key.
+func rfcErr(code, key, description string) *gorfc.RfcError {
+	err := &gorfc.RfcError{Description: description}
+	err.ErrorInfo.Code = code
+	err.ErrorInfo.Key = key
+	err.ErrorInfo.Message = key
+	return err
+}
+
+func (f *fakeSAP) dial(params gorfc.ConnectionParameters) (rfcConn, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.dials++
+	f.mu.Unlock()
+	return &fakeConn{sap: f}, nil
+}
+
+// fakeConn is one connection to a fakeSAP.
+type fakeConn struct {
+	sap    *fakeSAP
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *fakeConn) check() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return rfcErr("RFC_INVALID_HANDLE", "", "RFC_INVALID_HANDLE: connection is closed")
+	}
+	return c.sap.fail()
+}
+
+func (c *fakeConn) Ping() error { return c.check() }
+
+func (c *fakeConn) GetConnectionAttributes() (gorfc.ConnectionAttributes, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	c.sap.mu.Lock()
+	defer c.sap.mu.Unlock()
+	out := make(gorfc.ConnectionAttributes, len(c.sap.attrs))
+	for k, v := range c.sap.attrs {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (c *fakeConn) GetFunctionDescription(name string) (gorfc.FunctionDescription, error) {
+	if err := c.check(); err != nil {
+		return gorfc.FunctionDescription{}, err
+	}
+	c.sap.mu.Lock()
+	defer c.sap.mu.Unlock()
+	fn, ok := c.sap.functions[strings.ToUpper(name)]
+	if !ok {
+		return gorfc.FunctionDescription{}, rfcErr("RFC_ABAP_EXCEPTION", "FU_NOT_FOUND", "FU_NOT_FOUND: function module "+name+" not found")
+	}
+	return fn.desc, nil
+}
+
+func (c *fakeConn) Call(name string, params interface{}) (map[string]interface{}, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	c.sap.mu.Lock()
+	fn, ok := c.sap.functions[strings.ToUpper(name)]
+	c.sap.calls = append(c.sap.calls, strings.ToUpper(name))
+	block := c.sap.block
+	c.sap.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	if !ok {
+		return nil, rfcErr("RFC_ABAP_EXCEPTION", "FU_NOT_FOUND", "FU_NOT_FOUND: function module "+name+" not found")
+	}
+	p, _ := params.(map[string]interface{})
+	if fn.call == nil {
+		return map[string]interface{}{}, nil
+	}
+	return fn.call(p)
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// ── function module implementations ──────────────────────────────────────────
+
+func (f *fakeSAP) table(name string) (*fakeTable, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[strings.ToUpper(name)]
+	if !ok {
+		return nil, rfcErr("RFC_ABAP_EXCEPTION", "TABLE_NOT_AVAILABLE", "TABLE_NOT_AVAILABLE: table "+name+" not found")
+	}
+	return t, nil
+}
+
+func (f *fakeSAP) fieldInfo(params map[string]interface{}) (map[string]interface{}, error) {
+	name, _ := params["TABNAME"].(string)
+	t, err := f.table(name)
+	if err != nil {
+		return nil, rfcErr("RFC_ABAP_EXCEPTION", "NOT_FOUND", "NOT_FOUND: "+err.Error())
+	}
+	rows := make([]interface{}, len(t.fields))
+	for i, fd := range t.fields {
+		key := ""
+		if fd.key {
+			key = "X"
+		}
+		rows[i] = map[string]interface{}{
+			"FIELDNAME": fd.name,
+			"INTTYPE":   fd.intType,
+			"LENG":      fmt.Sprintf("%06d", fd.length),
+			"OUTPUTLEN": fmt.Sprintf("%06d", fd.outputLen),
+			"DECIMALS":  fmt.Sprintf("%06d", fd.decimals),
+			"KEYFLAG":   key,
+			"CONVEXIT":  fd.convExit,
+			"FIELDTEXT": fd.text,
+		}
+	}
+	return map[string]interface{}{"DFIES_TAB": rows}, nil
+}
+
+// bodsOutTables are the output tables of /BODS/RFC_READ_TABLE2 by row width.
+var bodsOutTables = []struct {
+	name  string
+	width int
+}{{"TBLOUT128", 128}, {"TBLOUT512", 512}, {"TBLOUT2048", 2048}, {"TBLOUT8192", 8192}, {"TBLOUT30000", 30000}}
+
+// addBODSReader adds /BODS/RFC_READ_TABLE2, which the table reader probe
+// prefers over RFC_READ_TABLE.
+func (f *fakeSAP) addBODSReader() {
+	params := []gorfc.ParameterDescription{
+		charParam("QUERY_TABLE", "RFC_IMPORT", 30),
+		charParam("DELIMITER", "RFC_IMPORT", 1),
+		charParam("NO_DATA", "RFC_IMPORT", 1),
+		{Name: "ROWSKIPS", ParameterType: "RFCTYPE_INT", Direction: "RFC_IMPORT", NucLength: 4},
+		{Name: "ROWCOUNT", ParameterType: "RFCTYPE_INT", Direction: "RFC_IMPORT", NucLength: 4},
+		charParam("OUT_TABLE", "RFC_EXPORT", 30),
+		tableParam("OPTIONS", "RFC_DB_OPT", 72, "TEXT"),
+		tableParam("FIELDS", "RFC_DB_FLD", 103, "FIELDNAME", "OFFSET", "LENGTH", "TYPE", "FIELDTEXT"),
+	}
+	for _, out := range bodsOutTables {
+		params = append(params, tableParam(out.name, "/BODS/TAB"+strconv.Itoa(out.width), uint(out.width), "WA"))
+	}
+	f.addFunction(gorfc.FunctionDescription{Name: readerBODS, Parameters: params}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		fields, data, width, err := f.selectRows(params, 30000)
+		if err != nil {
+			return nil, err
+		}
+		// The narrowest output table that holds a row is used.
+		out := bodsOutTables[len(bodsOutTables)-1].name
+		for _, t := range bodsOutTables {
+			if width <= t.width {
+				out = t.name
+				break
+			}
+		}
+		return map[string]interface{}{"OUT_TABLE": out, "FIELDS": fields, out: data}, nil
+	})
+}
+
+func (f *fakeSAP) readTable(params map[string]interface{}) (map[string]interface{}, error) {
+	fields, data, _, err := f.selectRows(params, 512)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"FIELDS": fields, "DATA": data}, nil
+}
+
+// selectRows implements the table read shared by RFC_READ_TABLE and
+// /BODS/RFC_READ_TABLE2: it returns the FIELDS rows, the data rows of at most
+// maxWidth characters and their width.
+func (f *fakeSAP) selectRows(params map[string]interface{}, maxWidth int) ([]interface{}, []interface{}, int, error) {
+	name, _ := params["QUERY_TABLE"].(string)
+	t, err := f.table(name)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	index := make(map[string]int, len(t.fields))
+	for i, fd := range t.fields {
+		index[fd.name] = i
+	}
+
+	var cols []int
+	if req, _ := params["FIELDS"].([]interface{}); len(req) > 0 {
+		for _, r := range req {
+			row, _ := r.(map[string]interface{})
+			fn, _ := row["FIELDNAME"].(string)
+			i, ok := index[strings.ToUpper(strings.TrimSpace(fn))]
+			if !ok {
+				return nil, nil, 0, rfcErr("RFC_ABAP_EXCEPTION", "FIELD_NOT_VALID", "FIELD_NOT_VALID: "+fn)
+			}
+			cols = append(cols, i)
+		}
+	} else {
+		for i := range t.fields {
+			cols = append(cols, i)
+		}
+	}
+	fields := make([]interface{}, len(cols))
+	offset := 0
+	for j, i := range cols {
+		fd := t.fields[i]
+		fields[j] = map[string]interface{}{
+			"FIELDNAME": fd.name,
+			"OFFSET":    fmt.Sprintf("%06d", offset),
+			"LENGTH":    fmt.Sprintf("%06d", fd.length),
+			"TYPE":      fd.intType,
+			"FIELDTEXT": fd.text,
+		}
+		offset += fd.length
+	}
+	if offset > maxWidth {
+		return nil, nil, 0, rfcErr("RFC_ABAP_EXCEPTION", "DATA_BUFFER_EXCEEDED", "DATA_BUFFER_EXCEEDED")
+	}
+
+	var where []string
+	if opts, _ := params["OPTIONS"].([]interface{}); len(opts) > 0 {
+		var text []string
+		for _, o := range opts {
+			row, _ := o.(map[string]interface{})
+			s, _ := row["TEXT"].(string)
+			text = append(text, s)
+		}
+		where = abapTokens(strings.Join(text, " "))
+	}
+
+	skip, count := intParam(params["ROWSKIPS"]), intParam(params["ROWCOUNT"])
+	var data []interface{}
+	for _, row := range t.rows {
+		ok, err := matchWhere(where, index, row)
+		if err != nil {
+			return nil, nil, 0, rfcErr("RFC_ABAP_EXCEPTION", "OPTION_NOT_VALID", "OPTION_NOT_VALID: "+err.Error())
+		}
+		if !ok {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if count > 0 && len(data) == count {
+			break
+		}
+		var wa strings.Builder
+		for _, i := range cols {
+			wa.WriteString(fmt.Sprintf("%-*s", t.fields[i].length, row[i]))
+		}
+		data = append(data, map[string]interface{}{"WA": wa.String()})
+	}
+	return fields, data, offset, nil
+}
+
+func intParam(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
+
+// abapTokens splits an OPTIONS WHERE clause into tokens, keeping quoted
+// literals (with ” escapes) together.
+func abapTokens(s string) []string {
+	var out []string
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ':
+			i++
+		case s[i] == '\'':
+			j := i + 1
+			for j < len(s) {
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			out = append(out, s[i:j+1])
+			i = j + 1
+		case s[i] == '(' || s[i] == ')' || s[i] == ',':
+			out = append(out, s[i:i+1])
+			i++
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" (),'", rune(s[j])) {
+				j++
+			}
+			out = append(out, s[i:j])
+			i = j
+		}
+	}
+	return out
+}
+
+func unquote(tok string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(tok, "'"), "'"), "''", "'")
+}
+
+// matchWhere evaluates conditions joined by AND: FIELD op 'lit',
+// FIELD [NOT] LIKE 'pat', FIELD IN ( 'a' , 'b' ) and FIELD BETWEEN 'a' AND 'b';
+// or parenthesized groups of them joined by OR, as read_table selects rows by
+// their keys.
+func matchWhere(tokens []string, index map[string]int, row []string) (bool, error) {
+	if len(tokens) > 0 && tokens[0] == "(" {
+		return matchGroups(tokens, index, row)
+	}
+	ok := true
+	for i := 0; i < len(tokens); {
+		if i > 0 {
+			if tokens[i] != "AND" {
+				return false, fmt.Errorf("expected AND, got %q", tokens[i])
+			}
+			i++
+		}
+		if i+2 >= len(tokens) {
+			return false, fmt.Errorf("incomplete condition")
+		}
+		col, known := index[tokens[i]]
+		if !known {
+			return false, fmt.Errorf("unknown field %q", tokens[i])
+		}
+		v := row[col]
+		op := tokens[i+1]
+		i += 2
+		if op == "NOT" && tokens[i] == "LIKE" {
+			op = "NOT LIKE"
+			i++
+		}
+		var match bool
+		switch op {
+		case "IN":
+			if tokens[i] != "(" {
+				return false, fmt.Errorf("expected ( after IN")
+			}
+			for i++; i < len(tokens) && tokens[i] != ")"; i++ {
+				if tokens[i] != "," && unquote(tokens[i]) == v {
+					match = true
+				}
+			}
+			i++
+		case "BETWEEN":
+			if i+2 >= len(tokens) || tokens[i+1] != "AND" {
+				return false, fmt.Errorf("malformed BETWEEN")
+			}
+			match = v >= unquote(tokens[i]) && v <= unquote(tokens[i+2])
+			i += 3
+		default:
+			lit := unquote(tokens[i])
+			i++
+			switch op {
+			case "=", "EQ":
+				match = v == lit
+			case "<>", "NE":
+				match = v != lit
+			case "<", "LT":
+				match = v < lit
+			case "<=", "LE":
+				match = v <= lit
+			case ">", "GT":
+				match = v > lit
+			case ">=", "GE":
+				match = v >= lit
+			case "LIKE":
+				match = likeMatch(lit, v)
+			case "NOT LIKE":
+				match = !likeMatch(lit, v)
+			default:
+				return false, fmt.Errorf("unsupported operator %q", op)
+			}
+		}
+		ok = ok && match
+	}
+	return ok, nil
+}
+
+// matchGroups evaluates ( conditions ) OR ( conditions ) ...
+func matchGroups(tokens []string, index map[string]int, row []string) (bool, error) {
+	ok := false
+	for i := 0; i < len(tokens); {
+		if i > 0 {
+			if tokens[i] != "OR" {
+				return false, fmt.Errorf("expected OR, got %q", tokens[i])
+			}
+			i++
+		}
+		if i >= len(tokens) || tokens[i] != "(" {
+			return false, fmt.Errorf("expected (")
+		}
+		depth, j := 0, i
+		for ; j < len(tokens); j++ {
+			if tokens[j] == "(" {
+				depth++
+			} else if tokens[j] == ")" {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		if j == len(tokens) {
+			return false, fmt.Errorf("unbalanced (")
+		}
+		match, err := matchWhere(tokens[i+1:j], index, row)
+		if err != nil {
+			return false, err
+		}
+		ok = ok || match
+		i = j + 1
+	}
+	return ok, nil
+}
+
+// likeMatch implements LIKE with % and _ wildcards.
+func likeMatch(pattern, s string) bool {
+	p := strings.NewReplacer("%", "*", "_", "?").Replace(pattern)
+	return wildcardMatch(p, s)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Function tools ───────────────────────────────────────────────────────────
+
+// functionToolConfig turns one function module into a dedicated MCP tool.
+// The tool's input schema is the function's input schema (see
+// schemasForFunction), read from System when the server starts.
+type functionToolConfig struct {
+	Function    string                 `json:"function"`
+	Name        string                 `json:"name,omitempty"`        // default: the function name in lower case
+	Description string                 `json:"description,omitempty"` // default: the function's short text
+	System      string                 `json:"system,omitempty"`      // default: the default system
+	Defaults    map[string]interface{} `json:"defaults,omitempty"`    // parameter values used when the caller passes none
+}
+
+// functionToolsFromEnv combines the function_tools section of the config
+// file with MCP_FUNCTION_TOOLS, a comma-separated list of function modules
+// that get a tool with default settings.
+func functionToolsFromEnv(cfg *serverConfig) ([]functionToolConfig, error) {
+	var tools []functionToolConfig
+	if cfg != nil {
+		tools = append(tools, cfg.FunctionTools...)
+	}
+	for _, fn := range splitList(os.Getenv("MCP_FUNCTION_TOOLS")) {
+		tools = append(tools, functionToolConfig{Function: fn})
+	}
+	seen := make(map[string]bool, len(tools))
+	for i := range tools {
+		ft := &tools[i]
+		if ft.Function == "" {
+			return nil, fmt.Errorf("function_tools[%d]: function is required", i)
+		}
+		ft.Function = strings.ToUpper(ft.Function)
+		if ft.Name == "" {
+			ft.Name = functionToolName(ft.Function)
+		}
+		if seen[ft.Name] {
+			return nil, fmt.Errorf("function_tools: duplicate tool name %q", ft.Name)
+		}
+		seen[ft.Name] = true
+	}
+	return tools, nil
+}
+
+// functionToolName derives a tool name from a function module name, e.g.
+// "bapi_material_get_detail" or "bods_rfc_read_table2" for
+// "/BODS/RFC_READ_TABLE2".
+func functionToolName(function string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, function)
+	return strings.Trim(name, "_")
+}
+
+// registerFunctionTools adds one tool per entry of tools. Tools whose
+// function cannot be described, is refused by the system's call policy or
+// whose name is taken are skipped with a log message, so that one stale entry
+// does not keep the server from starting.
+func registerFunctionTools(ctx context.Context, addTool func(*mcp.Tool, mcp.ToolHandler), systems *systemRegistry, m *metrics, tools []functionToolConfig, taken map[string]bool) {
+	for _, ft := range tools {
+		tool, err := functionTool(ctx, systems, ft)
+		if err == nil && taken[tool.Name] {
+			err = fmt.Errorf("tool name %q is already in use", tool.Name)
+		}
+		if err != nil {
+			logger.Warn("function tool skipped", "function", ft.Function, "err", err)
+			continue
+		}
+		taken[tool.Name] = true
+		_, sessionArg := tool.InputSchema.(*jsonschema.Schema).Properties["session_id"]
+		addTool(tool, functionToolHandler(systems, m, ft, sessionArg))
+		logger.Info("registered function tool", "tool", tool.Name, "function", ft.Function)
+	}
+}
+
+// functionTool builds the MCP tool for ft from the function's description
+// and short text.
+func functionTool(ctx context.Context, systems *systemRegistry, ft functionToolConfig) (*mcp.Tool, error) {
+	s, err := systems.lookup(ft.System)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.policy.check(ft.Function); err != nil {
+		return nil, err
+	}
+	desc, _, err := systems.describe(ctx, s, ft.Function)
+	if err != nil {
+		return nil, fmt.Errorf("describe: %w", err)
+	}
+	input := schemasForFunction(desc, systems.output).Input
+	for name, val := range ft.Defaults {
+		name = strings.ToUpper(name)
+		prop, ok := input.Properties[name]
+		if !ok {
+			return nil, fmt.Errorf("default for unknown parameter %q", name)
+		}
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("default for %s: %w", name, err)
+		}
+		prop.Default = b
+		for i, r := range input.Required {
+			if r == name {
+				input.Required = append(input.Required[:i:i], input.Required[i+1:]...)
+				break
+			}
+		}
+	}
+	// Unless the function has a parameter of that name, the tool accepts
+	// the session_id of rfc_call.
+	if _, ok := input.Properties["SESSION_ID"]; !ok {
+		input.Properties["session_id"] = &jsonschema.Schema{
+			Type:        "string",
+			Description: "Run the call in this session (see begin_session), so that a later commit covers its changes.",
+		}
+	}
+
+	text := ft.Description
+	if text == "" {
+		if cm, err := s.manager(ctx, systems); err == nil {
+			text = functionShortText(ctx, cm, ft.Function)
+		}
+	}
+	if text == "" {
+		text = "Calls the SAP function module " + ft.Function + "."
+	}
+	return &mcp.Tool{
+		Name: ft.Name,
+		Description: fmt.Sprintf("%s (SAP function module %s on system %s. Parameter names are case-insensitive; DATE fields use YYYYMMDD, TIME fields use HHMMSS, BYTE/XSTRING fields use base64.)",
+			strings.TrimSuffix(text, "."), ft.Function, s.cfg.Name),
+		InputSchema: input,
+	}, nil
+}
+
+// functionShortText returns the short text of function from
+// RFC_FUNCTION_SEARCH in the logon language, or "" if it is unavailable.
+func functionShortText(ctx context.Context, cm *connManager, function string) string {
+	out, err := cm.call(ctx, "RFC_FUNCTION_SEARCH", map[string]interface{}{"FUNCNAME": function})
+	if err != nil {
+		logger.WarnContext(ctx, "short text unavailable", "function", function, "err", err)
+		return ""
+	}
+	rows, _ := out["FUNCTIONS"].([]interface{})
+	for _, r := range rows {
+		row, _ := r.(map[string]interface{})
+		if name, _ := row["FUNCNAME"].(string); strings.TrimSpace(name) == function {
+			text, _ := row["STEXT"].(string)
+			return strings.TrimSpace(text)
+		}
+	}
+	return ""
+}
+
+// functionToolHandler calls ft.Function with the tool's arguments as its
+// parameters, after filling in ft.Defaults. If sessionArg is set, the
+// session_id argument selects a session instead of being a parameter.
+func functionToolHandler(systems *systemRegistry, m *metrics, ft functionToolConfig, sessionArg bool) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		params := map[string]interface{}{}
+		if len(req.Params.Arguments) > 0 {
+			if err := decodeArguments(req.Params.Arguments, &params); err != nil {
+				return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+			}
+		}
+		for name, val := range ft.Defaults {
+			if !hasParameter(params, name) {
+				params[name] = val
+			}
+		}
+		var sessionID string
+		if sessionArg {
+			if v, ok := params["session_id"]; ok {
+				sessionID, _ = v.(string)
+				delete(params, "session_id")
+			}
+		}
+		cm, sess, err := systems.target(ctx, ft.System, sessionID, req.Session)
+		if err != nil {
+			return errResult(err), nil
+		}
+		return callFunction(ctx, cm, sess, m, ft.Function, params, nil, systems.output), nil
+	}
+}
+
+// hasParameter reports whether params contains name, ignoring case.
+func hasParameter(params map[string]interface{}, name string) bool {
+	for k := range params {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
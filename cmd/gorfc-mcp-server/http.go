@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── HTTP transport ───────────────────────────────────────────────────────────
+
+// httpConfig configures the optional HTTP transport, which serves MCP
+// streamable HTTP at /mcp and the older SSE transport at /sse.
+type httpConfig struct {
+	Addr    string
+	TLSCert string
+	TLSKey  string
+	// Tokens maps each accepted bearer token / API key to the client name
+	// reported as the MCP session's user.
+	Tokens map[string]string
+	NoAuth bool
+}
+
+// httpConfigFromEnv returns nil when MCP_HTTP_ADDR is unset (stdio mode).
+//
+//	MCP_HTTP_ADDR     – listen address, e.g. ":8080" (enables HTTP mode)
+//	MCP_TLS_CERT      – TLS certificate file (optional, requires MCP_TLS_KEY)
+//	MCP_TLS_KEY       – TLS private key file
+//	MCP_AUTH_TOKENS   – accepted tokens, comma-separated, each "token" or
+//	                    "client:token"; sent as "Authorization: Bearer <token>"
+//	                    or "X-API-Key: <token>". The client name ends at the
+//	                    last colon, so it may contain colons and the token may
+//	                    not
+//	MCP_HTTP_NO_AUTH  – "true" allows running without MCP_AUTH_TOKENS
+func httpConfigFromEnv() (*httpConfig, error) {
+	addr := os.Getenv("MCP_HTTP_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	c := &httpConfig{
+		Addr:    addr,
+		TLSCert: os.Getenv("MCP_TLS_CERT"),
+		TLSKey:  os.Getenv("MCP_TLS_KEY"),
+		Tokens:  map[string]string{},
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return nil, fmt.Errorf("MCP_TLS_CERT and MCP_TLS_KEY must be set together")
+	}
+	for i, entry := range splitList(os.Getenv("MCP_AUTH_TOKENS")) {
+		name, token := fmt.Sprintf("token-%d", i+1), entry
+		if j := strings.LastIndex(entry, ":"); j >= 0 {
+			name, token = entry[:j], entry[j+1:]
+		}
+		switch {
+		case name == "":
+			return nil, fmt.Errorf(`MCP_AUTH_TOKENS: entry %d has an empty client name; entries are "token" or "client:token"`, i+1)
+		case token == "":
+			return nil, fmt.Errorf(`MCP_AUTH_TOKENS: entry %d (%q) has an empty token; entries are "token" or "client:token", with the token after the last colon`, i+1, name)
+		case c.Tokens[token] != "":
+			return nil, fmt.Errorf("MCP_AUTH_TOKENS: entry %d (%q) repeats the token of %q", i+1, name, c.Tokens[token])
+		}
+		c.Tokens[token] = name
+	}
+	if s := os.Getenv("MCP_HTTP_NO_AUTH"); s != "" {
+		noAuth, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_HTTP_NO_AUTH: %w", err)
+		}
+		c.NoAuth = noAuth
+	}
+	if len(c.Tokens) == 0 && !c.NoAuth {
+		return nil, fmt.Errorf("HTTP mode requires MCP_AUTH_TOKENS (or MCP_HTTP_NO_AUTH=true)")
+	}
+	return c, nil
+}
+
+func (c *httpConfig) handler(server *mcp.Server) http.Handler {
+	getServer := func(*http.Request) *mcp.Server { return server }
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", c.authenticate(mcp.NewStreamableHTTPHandler(getServer, nil)))
+	mux.Handle("/sse", c.authenticate(mcp.NewSSEHandler(getServer, nil)))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// authenticate requires a configured token, either as a bearer token or in
+// the X-API-Key header. The client name becomes the MCP session's user ID.
+func (c *httpConfig) authenticate(h http.Handler) http.Handler {
+	if c.NoAuth {
+		return h
+	}
+	bearer := auth.RequireBearerToken(c.verifyToken, nil)(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		bearer.ServeHTTP(w, r)
+	})
+}
+
+func (c *httpConfig) verifyToken(ctx context.Context, token string, req *http.Request) (*auth.TokenInfo, error) {
+	for t, name := range c.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			// Static tokens do not expire; RequireBearerToken insists on a
+			// non-zero expiration, so report one just ahead.
+			return &auth.TokenInfo{UserID: name, Expiration: time.Now().Add(time.Hour)}, nil
+		}
+	}
+	return nil, auth.ErrInvalidToken
+}
+
+// httpResponseGrace is how long shutdown waits, after the last tool call
+// returned, for its response to reach the client.
+const httpResponseGrace = 500 * time.Millisecond
+
+// serveHTTP serves server on c.Addr until ctx is done; see serve.
+func serveHTTP(ctx context.Context, server *mcp.Server, c *httpConfig, calls *inflightCalls, drainTimeout time.Duration) error {
+	ln, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	return c.serve(ctx, server, ln, calls, drainTimeout)
+}
+
+// serve serves server on ln until ctx is done. It then stops accepting new
+// connections, waits up to drainTimeout for in-flight tool calls and their
+// responses, and closes the remaining connections (e.g. idle SSE streams).
+func (c *httpConfig) serve(ctx context.Context, server *mcp.Server, ln net.Listener, calls *inflightCalls, drainTimeout time.Duration) error {
+	srv := &http.Server{
+		Handler:           c.handler(server),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if c.TLSCert != "" {
+			errCh <- srv.ServeTLS(ln, c.TLSCert, c.TLSKey)
+		} else {
+			errCh <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("shutting down HTTP server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	shutdown := make(chan struct{})
+	go func() {
+		srv.Shutdown(shutdownCtx)
+		close(shutdown)
+	}()
+	if calls.wait(drainTimeout) {
+		// Shutdown returns once the responses are written, unless a stream
+		// stays open.
+		select {
+		case <-shutdown:
+		case <-time.After(httpResponseGrace):
+		}
+	}
+	if err := srv.Close(); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Logging ──────────────────────────────────────────────────────────────────
+
+// logger is replaced in main by one configured from the environment.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// fatalf logs an error and exits.
+func fatalf(format string, args ...interface{}) {
+	logger.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Log formats.
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// logConfig configures logging to stderr and to MCP clients.
+type logConfig struct {
+	Level   slog.Level
+	Format  string // logText or logJSON
+	Forward bool   // send the entries of a tool call to its client
+}
+
+// logConfigFromEnv reads logging settings from the environment.
+//
+//	MCP_LOG_LEVEL    – debug, info (default), warn or error
+//	MCP_LOG_FORMAT   – text (default) or json
+//	MCP_LOG_FORWARD  – "true" also sends the entries logged during a tool call
+//	                   to the calling client as logging notifications, at the
+//	                   level the client chose with logging/setLevel
+func logConfigFromEnv() (logConfig, error) {
+	cfg := logConfig{Level: slog.LevelInfo, Format: logText}
+	if s := os.Getenv("MCP_LOG_LEVEL"); s != "" {
+		if err := cfg.Level.UnmarshalText([]byte(s)); err != nil {
+			return cfg, fmt.Errorf("MCP_LOG_LEVEL: %w", err)
+		}
+	}
+	if s := os.Getenv("MCP_LOG_FORMAT"); s != "" {
+		cfg.Format = strings.ToLower(s)
+		if cfg.Format != logText && cfg.Format != logJSON {
+			return cfg, fmt.Errorf("MCP_LOG_FORMAT must be %q or %q, got %q", logText, logJSON, s)
+		}
+	}
+	if s := os.Getenv("MCP_LOG_FORWARD"); s != "" {
+		forward, err := strconv.ParseBool(s)
+		if err != nil {
+			return cfg, fmt.Errorf("MCP_LOG_FORWARD: %w", err)
+		}
+		cfg.Forward = forward
+	}
+	return cfg, nil
+}
+
+// newLogger returns a logger writing to w as cfg prescribes, with the values
+// hidden by redact scrubbed from every entry.
+func newLogger(cfg logConfig, w io.Writer, redact *redactor) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var base slog.Handler = slog.NewTextHandler(w, opts)
+	if cfg.Format == logJSON {
+		base = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&logHandler{base: base, redact: redact})
+}
+
+// logCall identifies the tool call a context belongs to.
+type logCall struct {
+	id     string
+	tool   string
+	client *mcp.LoggingHandler // forwards entries to the client, if enabled
+}
+
+type logCallKey struct{}
+
+// requestID returns the ID of the tool call of ctx, or "".
+func requestID(ctx context.Context) string {
+	if call, ok := ctx.Value(logCallKey{}).(*logCall); ok {
+		return call.id
+	}
+	return ""
+}
+
+// wrap assigns every call of the tool name a request ID, which is added to
+// the entries logged with the call's context, and logs the call at debug
+// level.
+func (cfg logConfig) wrap(name string, h mcp.ToolHandler) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var id [8]byte
+		rand.Read(id[:])
+		call := &logCall{id: hex.EncodeToString(id[:]), tool: name}
+		if cfg.Forward && req.Session != nil {
+			call.client = mcp.NewLoggingHandler(req.Session, &mcp.LoggingHandlerOptions{LoggerName: "gorfc-mcp"})
+		}
+		ctx = context.WithValue(ctx, logCallKey{}, call)
+		logger.DebugContext(ctx, "tool call started")
+		t0 := time.Now()
+		res, err := h(ctx, req)
+		attrs := []interface{}{"duration_ms", time.Since(t0).Milliseconds()}
+		if class := toolErrorClass(res, err); class != "" {
+			attrs = append(attrs, "error_class", class)
+		}
+		logger.DebugContext(ctx, "tool call finished", attrs...)
+		return res, err
+	}
+}
+
+// logHandler adds the request ID and tool of the call of the context to
+// every entry, scrubs redacted values, and forwards the entries of tool
+// calls to the client when enabled. Groups apply to stderr only.
+type logHandler struct {
+	base   slog.Handler
+	redact *redactor
+	attrs  []slog.Attr // added with WithAttrs, for forwarded entries
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.base.Enabled(ctx, level) {
+		return true
+	}
+	call, _ := ctx.Value(logCallKey{}).(*logCall)
+	return call != nil && call.client != nil && call.client.Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.redact.scrub(r.Message), r.PC)
+	call, _ := ctx.Value(logCallKey{}).(*logCall)
+	if call != nil {
+		out.AddAttrs(slog.String("request_id", call.id), slog.String("tool", call.tool))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.scrubAttr(a))
+		return true
+	})
+	var err error
+	if h.base.Enabled(ctx, r.Level) {
+		err = h.base.Handle(ctx, out)
+	}
+	if call != nil && call.client != nil && call.client.Enabled(ctx, r.Level) {
+		fwd := out.Clone()
+		fwd.AddAttrs(h.attrs...)
+		// A client that went away must not fail the tool call.
+		call.client.Handle(ctx, fwd)
+	}
+	return err
+}
+
+func (h *logHandler) scrubAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.redact.scrub(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, h.redact.scrub(err.Error()))
+		}
+	}
+	return a
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scrubbed := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		scrubbed[i] = h.scrubAttr(a)
+	}
+	return &logHandler{
+		base:   h.base.WithAttrs(scrubbed),
+		redact: h.redact,
+		attrs:  append(h.attrs[:len(h.attrs):len(h.attrs)], scrubbed...),
+	}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{base: h.base.WithGroup(name), redact: h.redact, attrs: h.attrs}
+}
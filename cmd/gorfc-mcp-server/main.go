@@ -4,52 +4,93 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	gorfc "github.com/thm-ma/gorfc/gorfc"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
-var logger = log.New(os.Stderr, "[gorfc-mcp] ", log.LstdFlags)
-
 // ─── Connection Manager ───────────────────────────────────────────────────────
 
-// connManager is a thread-safe wrapper around gorfc.Connection.
-// All RFC calls are serialized through the mutex since the SAP NW RFC SDK is
-// not thread-safe per connection handle. Auto-reconnect uses exponential
-// backoff (3 retries, starting at 100 ms).
+// connManager is a thread-safe pool of RFC connection handles (rfcConn,
+// normally a gorfc.Connection).
+// The SAP NW RFC SDK is not thread-safe per connection handle, so each handle
+// is used by one goroutine at a time, while independent calls run in parallel
+// on separate handles (see connPool). Auto-reconnect uses exponential backoff
+// (3 retries, starting at 100 ms).
 type connManager struct {
-	mu         sync.Mutex
-	conn       *gorfc.Connection
+	system     string // name of the SAP system in the systemRegistry
 	connParams gorfc.ConnectionParameters
+	dial       dialFunc
+	pool       *connPool
+	policy     *callPolicy // consulted before every call; nil permits all
+	readers    readerSelector
+	cache      *metadataCache // shared by all systems; nil disables caching
+	snapshots  *snapshotStore // nil disables snapshots
+	reconnects atomic.Int64   // retries on a new connection after communication failures
+	redact     *redactor      // hides sensitive values in results; nil hides nothing
+
+	idMu sync.Mutex
+	id   sapIdentity // recorded from the first connection
 }
 
 // newConnManager connects using a destination name from sapnwrfc.ini.
-func newConnManager(dest string) (*connManager, error) {
-	return newConnManagerFromParams(gorfc.ConnectionParameters{"dest": dest})
+func newConnManager(dest string, cfg poolConfig) (*connManager, error) {
+	return newConnManagerFromParams(gorfc.ConnectionParameters{"dest": dest}, cfg)
 }
 
 // newConnManagerFromParams connects using explicit SAP connection parameters.
-func newConnManagerFromParams(params gorfc.ConnectionParameters) (*connManager, error) {
-	cm := &connManager{connParams: params}
-	if err := cm.connect(); err != nil {
+func newConnManagerFromParams(params gorfc.ConnectionParameters, cfg poolConfig) (*connManager, error) {
+	return newConnManagerWithDial(params, cfg, dialGorfc)
+}
+
+// newConnManagerWithDial connects through dial instead of the NW RFC SDK.
+func newConnManagerWithDial(params gorfc.ConnectionParameters, cfg poolConfig, dial dialFunc) (*connManager, error) {
+	cm := &connManager{connParams: params, dial: dial}
+	pool, err := newConnPool(cfg, cm.connect)
+	if err != nil {
 		return nil, err
 	}
+	cm.pool = pool
 	return cm, nil
 }
 
-func (cm *connManager) connect() error {
-	conn, err := gorfc.ConnectionFromParams(cm.connParams)
+func (cm *connManager) connect() (rfcConn, error) {
+	conn, err := cm.dial(cm.connParams)
 	if err != nil {
-		return fmt.Errorf("connect: %w", err)
+		return nil, fmt.Errorf("connect: %w", err)
 	}
-	cm.conn = conn
-	return nil
+	cm.idMu.Lock()
+	if cm.id.SystemID == "" {
+		if attrs, err := conn.GetConnectionAttributes(); err == nil {
+			cm.id = sapIdentity{SystemID: attrs["sysId"], Client: attrs["client"], User: attrs["user"]}
+		}
+	}
+	cm.idMu.Unlock()
+	return conn, nil
+}
+
+// identity returns the system ID, client and user, once a connection was
+// opened.
+func (cm *connManager) identity() sapIdentity {
+	cm.idMu.Lock()
+	defer cm.idMu.Unlock()
+	return cm.id
+}
+
+// close releases all pooled connections.
+func (cm *connManager) close() {
+	cm.pool.close()
 }
 
 // connParamsFromEnv builds ConnectionParameters from SAP_* environment variables.
@@ -131,12 +172,12 @@ func connParamsFromEnv() (gorfc.ConnectionParameters, error) {
 	return params, nil
 }
 
-// withConn runs fn under the mutex, retrying up to 3 times with reconnect on
-// communication failures.
-func (cm *connManager) withConn(fn func(*gorfc.Connection) error) error {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-
+// withConn runs fn on a pooled connection, retrying up to 3 times on a freshly
+// dialed connection after communication failures. Waiting for a connection and
+// the backoff between retries end early when ctx is done; an RFC still running
+// at that point is abandoned (see run).
+func (cm *connManager) withConn(ctx context.Context, fn func(rfcConn) error) error {
+	auditRFC(ctx, cm, "")
 	backoff := 100 * time.Millisecond
 	var lastErr error
 	for attempt := 0; attempt < 3; attempt++ {
@@ -144,15 +185,34 @@ func (cm *connManager) withConn(fn func(*gorfc.Connection) error) error {
 			if !isConnErr(lastErr) {
 				return lastErr
 			}
-			logger.Printf("reconnect attempt %d (backoff %v)", attempt, backoff)
-			time.Sleep(backoff)
-			backoff *= 2
-			if err := cm.connect(); err != nil {
-				lastErr = err
-				continue
+			logger.WarnContext(ctx, "reconnecting", "system", cm.system, "attempt", attempt, "backoff", backoff, "err", lastErr)
+			cm.reconnects.Add(1)
+			_, sp := startSpan(ctx, "rfc.reconnect_backoff")
+			sp.set("rfc.attempt", attempt)
+			select {
+			case <-time.After(backoff):
+				sp.end()
+			case <-ctx.Done():
+				sp.end()
+				return ctxErr(ctx)
 			}
+			backoff *= 2
+		}
+		_, sp := startSpan(ctx, "rfc.pool_wait")
+		pc, err := cm.pool.get(ctx, attempt > 0)
+		sp.failOn(err)
+		sp.end()
+		if err != nil {
+			lastErr = err
+			continue
 		}
-		if err := fn(cm.conn); err != nil {
+		_, sp = startSpan(ctx, "rfc.execute")
+		sp.setKind(spanKindClient)
+		sp.set("rfc.attempt", attempt)
+		err = cm.run(ctx, pc, fn)
+		sp.failOn(err)
+		sp.end()
+		if err != nil {
 			lastErr = err
 			continue
 		}
@@ -161,25 +221,49 @@ func (cm *connManager) withConn(fn func(*gorfc.Connection) error) error {
 	return lastErr
 }
 
-// isConnErr returns true for connection-level errors that may be resolved by
-// reconnecting (communication failure, invalid handle).
-func isConnErr(err error) bool {
-	if err == nil {
-		return false
+// run executes fn on pc and hands pc back to the pool. If ctx ends first, ctx's
+// error is reported to the caller right away and the RFC is left to finish in
+// the background: the SDK does not allow closing a handle while a call on it
+// is in progress, so the handle keeps its pool slot until fn returns and is
+// retired then.
+func (cm *connManager) run(ctx context.Context, pc *pooledConn, fn func(rfcConn) error) error {
+	if err := ctx.Err(); err != nil {
+		cm.pool.put(pc, true)
+		return ctxErr(ctx)
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn(pc.conn) }()
+	select {
+	case err := <-done:
+		cm.pool.put(pc, !isConnErr(err))
+		return err
+	case <-ctx.Done():
+		logger.WarnContext(ctx, "abandoning in-flight RFC", "system", cm.system, "err", ctxErr(ctx))
+		go func() {
+			<-done
+			cm.pool.put(pc, false)
+		}()
+		return ctxErr(ctx)
+	}
+}
+
+// ctxErr reports why ctx ended. The result wraps context.Canceled or
+// context.DeadlineExceeded and includes the cancellation cause, if any.
+func ctxErr(ctx context.Context) error {
+	err := ctx.Err()
+	if cause := context.Cause(ctx); cause != nil && cause != err {
+		return fmt.Errorf("%w: %v", err, cause)
 	}
-	s := err.Error()
-	return strings.Contains(s, "RFC_COMMUNICATION_FAILURE") ||
-		strings.Contains(s, "RFC_INVALID_HANDLE") ||
-		strings.Contains(s, "HANDLE_MISMATCH")
+	return err
 }
 
 func (cm *connManager) ping(ctx context.Context) error {
-	return cm.withConn(func(c *gorfc.Connection) error { return c.Ping() })
+	return cm.withConn(ctx, func(c rfcConn) error { return c.Ping() })
 }
 
 func (cm *connManager) connectionAttributes(ctx context.Context) (gorfc.ConnectionAttributes, error) {
 	var out gorfc.ConnectionAttributes
-	err := cm.withConn(func(c *gorfc.Connection) error {
+	err := cm.withConn(ctx, func(c rfcConn) error {
 		var e error
 		out, e = c.GetConnectionAttributes()
 		return e
@@ -187,23 +271,101 @@ func (cm *connManager) connectionAttributes(ctx context.Context) (gorfc.Connecti
 	return out, err
 }
 
+// describe returns the interface of funcName, from the metadata cache or a
+// current snapshot when possible. Fetched interfaces are also saved to the
+// snapshot, which systemRegistry.describe falls back to while SAP is
+// unreachable.
 func (cm *connManager) describe(ctx context.Context, funcName string) (gorfc.FunctionDescription, error) {
+	auditRFC(ctx, cm, "")
+	ctx, sp := startSpan(ctx, "rfc.describe")
+	defer sp.end()
+	sp.set("rfc.function", funcName)
+	if v, ok := cm.cache.get(cacheFunction, cm.system, funcName); ok {
+		sp.set("rfc.describe.source", "cache")
+		return v.(gorfc.FunctionDescription), nil
+	}
 	var out gorfc.FunctionDescription
-	err := cm.withConn(func(c *gorfc.Connection) error {
+	if cm.fromSnapshot(ctx, cacheFunction, funcName, &out) {
+		sp.set("rfc.describe.source", "snapshot")
+		cm.cache.put(cacheFunction, cm.system, funcName, out)
+		return out, nil
+	}
+	sp.set("rfc.describe.source", "sap")
+	err := cm.withConn(ctx, func(c rfcConn) error {
 		var e error
 		out, e = c.GetFunctionDescription(funcName)
 		return e
 	})
+	sp.failOn(err)
+	if err == nil {
+		cm.cache.put(cacheFunction, cm.system, funcName, out)
+		cm.snapshots.save(cm.system, cm.identity(), cacheFunction, funcName, out)
+	}
 	return out, err
 }
 
+// fieldInfo returns the DDIF_FIELDINFO_GET result for table in lang (the
+// logon language if empty), from the metadata cache or a current snapshot
+// when possible, and saves it to the snapshot like describe.
+func (cm *connManager) fieldInfo(ctx context.Context, table, lang string) (map[string]interface{}, error) {
+	auditRFC(ctx, cm, "")
+	key := table + "@" + lang
+	if v, ok := cm.cache.get(cacheTable, cm.system, key); ok {
+		return v.(map[string]interface{}), nil
+	}
+	var out map[string]interface{}
+	if cm.fromSnapshot(ctx, cacheTable, key, &out) {
+		cm.cache.put(cacheTable, cm.system, key, out)
+		return out, nil
+	}
+	params := map[string]interface{}{"TABNAME": table}
+	if lang != "" {
+		params["LANGU"] = lang
+	}
+	out, err := cm.call(ctx, "DDIF_FIELDINFO_GET", params)
+	if err == nil {
+		cm.cache.put(cacheTable, cm.system, key, out)
+		cm.snapshots.save(cm.system, cm.identity(), cacheTable, key, out)
+	}
+	return out, err
+}
+
+// fromSnapshot decodes the snapshot entry kind/name into dst if one younger
+// than the snapshot's maximum age was saved for the system ID and client cm
+// is logged on to. The identity is recorded by the first connection, so one
+// is opened if there is none yet.
+func (cm *connManager) fromSnapshot(ctx context.Context, kind, name string, dst interface{}) bool {
+	if cm.snapshots == nil || cm.snapshots.maxAge <= 0 {
+		return false
+	}
+	id := cm.identity()
+	if id.namespace() == "" {
+		if err := cm.withConn(ctx, func(rfcConn) error { return nil }); err != nil {
+			return false
+		}
+		id = cm.identity()
+	}
+	return cm.snapshots.current(cm.system, id, kind, name, dst)
+}
+
 func (cm *connManager) call(ctx context.Context, funcName string, params map[string]interface{}) (map[string]interface{}, error) {
+	auditRFC(ctx, cm, funcName)
+	ctx, sp := startSpan(ctx, "rfc.call "+funcName)
+	defer sp.end()
+	sp.set("rfc.function", funcName)
+	traceSAP(sp, cm)
+	if err := cm.policy.check(funcName); err != nil {
+		sp.failOn(err)
+		return nil, err
+	}
 	var out map[string]interface{}
-	err := cm.withConn(func(c *gorfc.Connection) error {
+	err := cm.withConn(ctx, func(c rfcConn) error {
 		var e error
 		out, e = c.Call(funcName, params)
 		return e
 	})
+	sp.failOn(err)
+	sp.set("rfc.rows", resultRows(out))
 	return out, err
 }
 
@@ -216,13 +378,33 @@ type metrics struct {
 	failure     int64
 	totalDur    time.Duration
 	perFunction map[string]int64
+	perSystem   map[string]int64
+	rejected    map[string]int64 // calls refused by the call policy, per function
+	bapi        map[string]int64 // BAPI return messages, per message type
+
+	// Exported at the Prometheus endpoint (see prometheus.go).
+	latency     map[[2]string]*histogram // per system and function
+	errs        map[[3]string]int64      // per system, function and error class
+	toolLatency map[[2]string]*histogram // per tool and system
+	toolErrs    map[[3]string]int64      // per tool, system and error class
+	toolBytes   map[[2]string]int64      // result bytes per tool and system
 }
 
 func newMetrics() *metrics {
-	return &metrics{perFunction: make(map[string]int64)}
+	return &metrics{
+		perFunction: make(map[string]int64),
+		perSystem:   make(map[string]int64),
+		rejected:    make(map[string]int64),
+		bapi:        make(map[string]int64),
+		latency:     make(map[[2]string]*histogram),
+		errs:        make(map[[3]string]int64),
+		toolLatency: make(map[[2]string]*histogram),
+		toolErrs:    make(map[[3]string]int64),
+		toolBytes:   make(map[[2]string]int64),
+	}
 }
 
-func (m *metrics) record(name string, dur time.Duration, err error) {
+func (m *metrics) record(system, name string, dur time.Duration, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.total++
@@ -233,6 +415,28 @@ func (m *metrics) record(name string, dur time.Duration, err error) {
 		m.failure++
 	}
 	m.perFunction[name]++
+	m.perSystem[system]++
+	var pe *policyError
+	if errors.As(err, &pe) {
+		m.rejected[pe.Function]++
+	}
+	key := [2]string{system, name}
+	if m.latency[key] == nil {
+		m.latency[key] = newHistogram()
+	}
+	m.latency[key].observe(dur)
+	if err != nil {
+		m.errs[[3]string{system, name, errorClass(err)}]++
+	}
+}
+
+// recordBAPI counts the BAPI return messages of a call by type.
+func (m *metrics) recordBAPI(msgs []bapiMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range msgs {
+		m.bapi[msg.Type]++
+	}
 }
 
 func (m *metrics) snapshot() map[string]interface{} {
@@ -246,6 +450,20 @@ func (m *metrics) snapshot() map[string]interface{} {
 	for k, v := range m.perFunction {
 		pf[k] = v
 	}
+	ps := make(map[string]int64, len(m.perSystem))
+	for k, v := range m.perSystem {
+		ps[k] = v
+	}
+	var rejectedTotal int64
+	rj := make(map[string]int64, len(m.rejected))
+	for k, v := range m.rejected {
+		rj[k] = v
+		rejectedTotal += v
+	}
+	bm := make(map[string]int64, len(m.bapi))
+	for k, v := range m.bapi {
+		bm[k] = v
+	}
 	return map[string]interface{}{
 		"total":             m.total,
 		"success":           m.success,
@@ -253,6 +471,9 @@ func (m *metrics) snapshot() map[string]interface{} {
 		"total_duration_ms": m.totalDur.Milliseconds(),
 		"avg_duration_ms":   avg,
 		"per_function":      pf,
+		"per_system":        ps,
+		"rejected":          map[string]interface{}{"total": rejectedTotal, "per_function": rj},
+		"bapi_messages":     bm,
 	}
 }
 
@@ -294,7 +515,7 @@ func coerceParams(params map[string]interface{}, funcDesc gorfc.FunctionDescript
 			out[upper] = val
 			continue
 		}
-		coerced, err := coerceValue(val, pd.ParameterType, pd.TypeDesc)
+		coerced, err := coerceValue(val, pd.ParameterType, pd.Decimals, pd.TypeDesc)
 		if err != nil {
 			return nil, fmt.Errorf("parameter %q: %w", upper, err)
 		}
@@ -304,60 +525,49 @@ func coerceParams(params map[string]interface{}, funcDesc gorfc.FunctionDescript
 }
 
 // coerceValue converts a single value from its JSON type to what gorfc needs.
-func coerceValue(val interface{}, rfcType string, typeDesc gorfc.TypeDescription) (interface{}, error) {
+// Numbers arrive as json.Number (see decodeArguments) or float64; decimals is
+// the number of decimal places of a BCD value.
+func coerceValue(val interface{}, rfcType string, decimals uint, typeDesc gorfc.TypeDescription) (interface{}, error) {
 	if val == nil {
 		return nil, nil
 	}
 	switch rfcType {
-	case "RFCTYPE_INT", "RFCTYPE_INT2", "RFCTYPE_INT8":
-		switch v := val.(type) {
-		case float64:
-			return int(v), nil
-		case int:
-			return v, nil
-		case int64:
-			return int(v), nil
-		case string:
-			var i int
-			if _, err := fmt.Sscan(v, &i); err != nil {
-				return nil, fmt.Errorf("expected integer, got %q", v)
-			}
-			return i, nil
-		}
-		return int(0), fmt.Errorf("cannot coerce %T to INT", val)
-
+	case "RFCTYPE_INT":
+		return exactInt(val, math.MinInt32, math.MaxInt32)
 	case "RFCTYPE_INT1":
-		switch v := val.(type) {
-		case float64:
-			return int(v), nil
-		case int:
-			return v, nil
-		case string:
-			var i int
-			if _, err := fmt.Sscan(v, &i); err != nil {
-				return nil, fmt.Errorf("expected integer, got %q", v)
-			}
-			return i, nil
+		return exactInt(val, 0, math.MaxUint8)
+	case "RFCTYPE_INT2":
+		return exactInt(val, math.MinInt16, math.MaxInt16)
+	case "RFCTYPE_INT8":
+		// gorfc sets INT8 values through RfcSetInt, which takes 4 bytes, so
+		// larger values are refused rather than truncated.
+		i, err := exactInt(val, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return nil, fmt.Errorf("INT8: %w (gorfc passes INT8 values as 4-byte integers)", err)
 		}
-		return int(0), fmt.Errorf("cannot coerce %T to INT1", val)
+		return i, nil
 
-	case "RFCTYPE_FLOAT", "RFCTYPE_BCD", "RFCTYPE_DECF16", "RFCTYPE_DECF34":
+	case "RFCTYPE_FLOAT":
 		switch v := val.(type) {
-		case float64:
-			return v, nil
+		case json.Number:
+			return v.Float64()
 		case int:
 			return float64(v), nil
-		case string:
-			return v, nil // gorfc handles string for BCD/DECF
 		}
 		return val, nil
+	case "RFCTYPE_BCD":
+		return exactDecimal(val, int(decimals))
+	case "RFCTYPE_DECF16", "RFCTYPE_DECF34":
+		return exactDecimal(val, -1)
 
 	case "RFCTYPE_CHAR", "RFCTYPE_STRING", "RFCTYPE_NUM", "RFCTYPE_UTCLONG":
 		switch v := val.(type) {
 		case string:
 			return v, nil
+		case json.Number:
+			return v.String(), nil
 		case float64:
-			return fmt.Sprintf("%g", v), nil
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
 		default:
 			return fmt.Sprintf("%v", v), nil
 		}
@@ -409,15 +619,16 @@ func coerceValue(val interface{}, rfcType string, typeDesc gorfc.TypeDescription
 		result := make(map[string]interface{}, len(m))
 		for k, v := range m {
 			upper := strings.ToUpper(k)
-			fieldType, fieldTypeDesc := "", gorfc.TypeDescription{}
+			fieldType, fieldDecimals, fieldTypeDesc := "", uint(0), gorfc.TypeDescription{}
 			for _, f := range typeDesc.Fields {
 				if f.Name == upper {
 					fieldType = f.FieldType
+					fieldDecimals = f.Decimals
 					fieldTypeDesc = f.TypeDesc
 					break
 				}
 			}
-			coerced, err := coerceValue(v, fieldType, fieldTypeDesc)
+			coerced, err := coerceValue(v, fieldType, fieldDecimals, fieldTypeDesc)
 			if err != nil {
 				return nil, fmt.Errorf("field %q: %w", upper, err)
 			}
@@ -432,7 +643,7 @@ func coerceValue(val interface{}, rfcType string, typeDesc gorfc.TypeDescription
 		}
 		result := make([]interface{}, len(arr))
 		for i, row := range arr {
-			coerced, err := coerceValue(row, "RFCTYPE_STRUCTURE", typeDesc)
+			coerced, err := coerceValue(row, "RFCTYPE_STRUCTURE", 0, typeDesc)
 			if err != nil {
 				return nil, fmt.Errorf("row %d: %w", i, err)
 			}
@@ -472,9 +683,10 @@ func parseReadTableResult(result map[string]interface{}) ([]map[string]string, e
 				name, _ := f["FIELDNAME"].(string)
 				offsetStr, _ := f["OFFSET"].(string)
 				lengthStr, _ := f["LENGTH"].(string)
-				var offset, length int
-				fmt.Sscan(strings.TrimSpace(offsetStr), &offset)
-				fmt.Sscan(strings.TrimSpace(lengthStr), &length)
+				// NUMC values like "000010" are decimal; fmt.Sscan would
+				// read the leading zero as an octal prefix.
+				offset, _ := strconv.Atoi(strings.TrimSpace(offsetStr))
+				length, _ := strconv.Atoi(strings.TrimSpace(lengthStr))
 				fields = append(fields, fieldMeta{
 					name:   strings.TrimSpace(name),
 					offset: offset,
@@ -533,50 +745,195 @@ func jsonResult(v interface{}) *mcp.CallToolResult {
 	return textResult(string(b))
 }
 
+// errResult reports err to the client, as text and, classified by
+// classifyError, as structured content. Cancellations and timeouts are called
+// out explicitly so the model does not mistake them for SAP-side failures.
 func errResult(err error) *mcp.CallToolResult {
+	text := err.Error()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		text = "timed out: the request did not complete before its deadline (" + text + ")"
+	case errors.Is(err, context.Canceled):
+		text = "cancelled: the request was cancelled before it completed (" + text + ")"
+	}
 	return &mcp.CallToolResult{
-		IsError: true,
-		Content: []mcp.Content{&mcp.TextContent{Text: err.Error()}},
+		IsError:           true,
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: classifyError(err),
 	}
 }
 
-// ─── Main ─────────────────────────────────────────────────────────────────────
+// inflightCalls counts running tool calls so that shutdown can drain them.
+type inflightCalls struct {
+	mu sync.Mutex
+	n  int
+}
 
-func main() {
-	dest := os.Getenv("SAP_DEST")
-	if dest == "" && len(os.Args) > 1 {
-		dest = os.Args[1]
+func (c *inflightCalls) wrap(h mcp.ToolHandler) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		c.mu.Lock()
+		c.n++
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			c.n--
+			c.mu.Unlock()
+		}()
+		return h(ctx, req)
 	}
+}
 
-	var cm *connManager
-	var connErr error
-
-	if dest != "" {
-		logger.Printf("connecting to SAP destination %q", dest)
-		cm, connErr = newConnManager(dest)
-	} else {
-		params, err := connParamsFromEnv()
-		if err != nil {
-			logger.Fatalf("SAP connection config error: %v", err)
+// wait blocks until no tool call is running or timeout elapses, and reports
+// whether all calls finished.
+func (c *inflightCalls) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		n := c.n
+		c.mu.Unlock()
+		if n == 0 {
+			return true
 		}
-		if params == nil {
-			logger.Fatal("SAP connection required: set SAP_DEST (or pass as argument) for " +
-				"ini-based connections, or set SAP_ASHOST + SAP_CLIENT + SAP_USER + SAP_PASSWD " +
-				"(or SAP_MSHOST for load-balancing) for direct connections")
+		if time.Now().After(deadline) {
+			logger.Warn("shutdown: tool calls still running", "calls", n, "timeout", timeout)
+			return false
 		}
-		host := params["ashost"]
-		if host == "" {
-			host = params["mshost"]
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// systemProp is the JSON Schema of the optional "system" argument accepted by
+// every tool that talks to SAP.
+const systemProp = `"system":{"type":"string","description":"Name of the target SAP system (see list_systems). Defaults to the server's default system."}`
+
+// ─── Main ─────────────────────────────────────────────────────────────────────
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(runAuditVerify(os.Args[2:]))
+	}
+	logCfg, err := logConfigFromEnv()
+	if err != nil {
+		fatalf("logging config error: %v", err)
+	}
+	logger = newLogger(logCfg, os.Stderr, nil)
+	poolCfg, err := poolConfigFromEnv()
+	if err != nil {
+		fatalf("connection pool config error: %v", err)
+	}
+	timeouts, err := toolTimeoutsFromEnv()
+	if err != nil {
+		fatalf("tool timeout config error: %v", err)
+	}
+	httpCfg, err := httpConfigFromEnv()
+	if err != nil {
+		fatalf("HTTP transport config error: %v", err)
+	}
+	drainTimeout := 30 * time.Second
+	if s := os.Getenv("MCP_SHUTDOWN_TIMEOUT"); s != "" {
+		if drainTimeout, err = time.ParseDuration(s); err != nil {
+			fatalf("MCP_SHUTDOWN_TIMEOUT: %v", err)
 		}
-		logger.Printf("connecting directly to SAP (host=%s, client=%s, user=%s)",
-			host, params["client"], params["user"])
-		cm, connErr = newConnManagerFromParams(params)
 	}
 
-	if connErr != nil {
-		logger.Fatalf("failed to connect: %v", connErr)
+	fileCfg, err := serverConfigFromEnv()
+	if err != nil {
+		fatalf("config error: %v", err)
+	}
+	policy, err := policyFromEnv(fileCfg)
+	if err != nil {
+		fatalf("call policy config error: %v", err)
+	}
+	funcTools, err := functionToolsFromEnv(fileCfg)
+	if err != nil {
+		fatalf("function tool config error: %v", err)
+	}
+	sysConfigs, defSystem, err := systemsFromEnv(fileCfg, os.Args[1:])
+	if err != nil {
+		fatalf("SAP connection config error: %v", err)
+	}
+	if len(sysConfigs) == 0 {
+		fatalf("SAP connection required: set SAP_DEST (or pass as argument) for " +
+			"ini-based connections, set SAP_ASHOST + SAP_CLIENT + SAP_USER + SAP_PASSWD " +
+			"(or SAP_MSHOST for load-balancing) for direct connections, or set MCP_CONFIG " +
+			"to a config file listing several systems")
+	}
+	redact, err := redactionFromEnv(fileCfg)
+	if err != nil {
+		fatalf("redaction config error: %v", err)
+	}
+	// From here on, values hidden by the redaction rules are scrubbed from
+	// log entries.
+	logger = newLogger(logCfg, os.Stderr, redact)
+	auditCfg, err := auditConfigFromEnv()
+	if err != nil {
+		fatalf("audit config error: %v", err)
+	}
+	audit, err := openAuditLog(auditCfg, redact)
+	if err != nil {
+		fatalf("audit log: %v", err)
+	}
+	defer audit.close()
+	if audit != nil {
+		logger.Info("auditing tool calls", "file", auditCfg.Path)
+	}
+	traceCfg, err := tracingConfigFromEnv()
+	if err != nil {
+		fatalf("tracing config error: %v", err)
+	}
+	tracer := newTracer(traceCfg, redact)
+	defer tracer.close()
+	if tracer != nil {
+		logger.Info("exporting traces", "endpoint", traceCfg.Endpoint)
+	}
+	sessionCfg, err := sessionConfigFromEnv()
+	if err != nil {
+		fatalf("session config error: %v", err)
+	}
+	output, err := outputFormatFromEnv(fileCfg)
+	if err != nil {
+		fatalf("output format config error: %v", err)
+	}
+	cacheCfg, err := cacheConfigFromEnv()
+	if err != nil {
+		fatalf("cache config error: %v", err)
 	}
-	logger.Printf("connected")
+	cache := newMetadataCache(cacheCfg)
+	snapshots, err := snapshotStoreFromEnv()
+	if err != nil {
+		fatalf("snapshot config error: %v", err)
+	}
+	tape, err := cassetteFromEnv()
+	if err != nil {
+		fatalf("record/replay config error: %v", err)
+	}
+	defer tape.close()
+	switch {
+	case tape == nil:
+	case tape.replay:
+		logger.Info("replaying RFC traffic; SAP is not contacted", "file", tape.path)
+	default:
+		// Replay needs the values as SAP returned them, so redaction does not
+		// apply to the cassette.
+		logger.Warn("recording RFC traffic; parameters and results are written unredacted", "file", tape.path)
+	}
+	systems, err := newSystemRegistry(sysConfigs, defSystem, registryOptions{
+		Pool:        poolCfg,
+		Policy:      policy,
+		TableReader: tableReaderFromEnv(fileCfg),
+		Cache:       cache,
+		Snapshots:   snapshots,
+		Cassette:    tape,
+		Sessions:    sessionCfg,
+		Output:      output,
+		Redact:      redact,
+	})
+	if err != nil {
+		fatalf("SAP connection config error: %v", err)
+	}
+	systems.connectAll()
+	logger.Info("systems configured", "systems", strings.Join(systems.names(), ","),
+		"pool_min", poolCfg.MinSize, "pool_max", poolCfg.MaxSize)
 
 	m := newMetrics()
 
@@ -585,15 +942,70 @@ func main() {
 		Version: "1.0.0",
 	}, nil)
 
+	calls := &inflightCalls{}
+	taken := map[string]bool{}
+	addTool := func(t *mcp.Tool, h mcp.ToolHandler) {
+		taken[t.Name] = true
+		server.AddTool(t, calls.wrap(logCfg.wrap(t.Name, tracer.wrap(t.Name, audit.wrap(t.Name, m.wrap(t.Name, timeouts.wrap(t.Name, h)))))))
+	}
+	registerTools(addTool, systems, m)
+	if len(funcTools) > 0 {
+		startCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		registerFunctionTools(startCtx, addTool, systems, m, funcTools, taken)
+		cancel()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// MCP_METRICS_ADDR, e.g. ":9464", enables the Prometheus endpoint.
+	if addr := os.Getenv("MCP_METRICS_ADDR"); addr != "" {
+		logger.Info("serving Prometheus metrics", "addr", addr, "path", "/metrics")
+		go serveMetrics(ctx, addr, m, systems)
+	}
+
+	if httpCfg != nil {
+		logger.Info("MCP server listening", "addr", httpCfg.Addr,
+			"streamable_http", "/mcp", "sse", "/sse", "tls", httpCfg.TLSCert != "")
+		err = serveHTTP(ctx, server, httpCfg, calls, drainTimeout)
+	} else {
+		logger.Info("MCP server starting", "transport", "stdio")
+		err = server.Run(ctx, &mcp.StdioTransport{})
+		calls.wait(drainTimeout)
+	}
+	systems.close()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatalf("server error: %v", err)
+	}
+}
+
+// registerTools registers every tool through addTool, which lets the caller
+// wrap the handlers (timeouts, auditing, metrics, tracing, logging, in-flight
+// tracking).
+func registerTools(addTool func(*mcp.Tool, mcp.ToolHandler), systems *systemRegistry, m *metrics) {
+	cache, snapshots := systems.cache, systems.snapshots
+
 	// ── rfc_ping ──────────────────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "rfc_ping",
 		Description: "Verify SAP connectivity by pinging the connected system.",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{}}`),
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `}}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var args struct {
+			System string `json:"system"`
+		}
+		if len(req.Params.Arguments) > 0 {
+			if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+				return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+			}
+		}
+		cm, err := systems.get(ctx, args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
 		t0 := time.Now()
-		err := cm.ping(ctx)
-		m.record("rfc_ping", time.Since(t0), err)
+		err = cm.ping(ctx)
+		m.record(cm.system, "rfc_ping", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
@@ -601,62 +1013,97 @@ func main() {
 	})
 
 	// ── rfc_connection_info ───────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "rfc_connection_info",
 		Description: "Get SAP connection attributes (SID, client, host, user) and NW RFC SDK version.",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{}}`),
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `}}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var args struct {
+			System string `json:"system"`
+		}
+		if len(req.Params.Arguments) > 0 {
+			if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+				return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+			}
+		}
+		cm, err := systems.get(ctx, args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
 		t0 := time.Now()
 		attrs, err := cm.connectionAttributes(ctx)
-		m.record("rfc_connection_info", time.Since(t0), err)
+		m.record(cm.system, "rfc_connection_info", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
 		major, minor, patch := gorfc.GetNWRFCLibVersion()
 		return jsonResult(map[string]interface{}{
+			"system":      cm.system,
 			"connection":  attrs,
 			"sdk_version": fmt.Sprintf("%d.%d.%d", major, minor, patch),
 		}), nil
 	})
 
 	// ── rfc_describe ──────────────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "rfc_describe",
-		Description: "Get function module metadata: parameters, types, directions, and optionally field details for structures/tables.",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{"function_name":{"type":"string","description":"Name of the RFC function module (e.g. STFC_CONNECTION)"}},"required":["function_name"]}`),
+		Description: "Get function module metadata: parameters, types, directions, and field details for structures/tables, plus JSON Schemas of the function's input (input_schema, the shape of rfc_call's parameters) and output (output_schema, the shape of rfc_call's result in the given output format).",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,"function_name":{"type":"string","description":"Name of the RFC function module (e.g. STFC_CONNECTION)"},` + outputProp + `},"required":["function_name"]}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		var args struct {
-			FunctionName string `json:"function_name"`
+			System       string        `json:"system"`
+			FunctionName string        `json:"function_name"`
+			Output       *outputFormat `json:"output"`
 		}
 		if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
 			return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
 		}
+		format, err := systems.output.with(args.Output)
+		if err != nil {
+			return errResult(fmt.Errorf("output: %w", err)), nil
+		}
 		if args.FunctionName == "" {
 			return errResult(fmt.Errorf("function_name is required")), nil
 		}
 		funcName := strings.ToUpper(args.FunctionName)
+		s, err := systems.lookup(args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
 		t0 := time.Now()
-		desc, err := cm.describe(ctx, funcName)
-		m.record("rfc_describe", time.Since(t0), err)
+		desc, snap, err := systems.describe(ctx, s, funcName)
+		m.record(s.cfg.Name, "rfc_describe", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
-		return jsonResult(desc), nil
+		res := describeResult{desc, schemasForFunction(desc, format)}
+		if snap != nil {
+			return snapshotResult(res, snap), nil
+		}
+		return jsonResult(res), nil
 	})
 
 	// ── rfc_call ──────────────────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "rfc_call",
-		Description: "Invoke an RFC function module with parameters and return the result. Parameter names are case-insensitive.",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{"function_name":{"type":"string","description":"Name of the RFC function module to call"},"parameters":{"type":"object","description":"Input parameters (IMPORT/CHANGING/TABLE). DATE fields use YYYYMMDD, TIME fields use HHMMSS, BYTE/XSTRING fields use base64."}},"required":["function_name"]}`),
+		Description: "Invoke an RFC function module with parameters and return the result. Parameter names are case-insensitive. Pass a session_id from begin_session for BAPIs whose changes must be committed.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,` + sessionIDProp + `,"function_name":{"type":"string","description":"Name of the RFC function module to call"},"parameters":{"type":"object","description":"Input parameters (IMPORT/CHANGING/TABLE), as described by the input_schema returned by rfc_describe. DATE fields use YYYYMMDD, TIME fields use HHMMSS, BYTE/XSTRING fields use base64. Pass decimals as strings to keep every digit."},` + resultViewProps + `,` + outputProp + `},"required":["function_name"]}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		var args struct {
+			System       string                 `json:"system"`
+			SessionID    string                 `json:"session_id"`
 			FunctionName string                 `json:"function_name"`
 			Parameters   map[string]interface{} `json:"parameters"`
+			Output       *outputFormat          `json:"output"`
+			resultView
 		}
-		if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+		if err := decodeArguments(req.Params.Arguments, &args); err != nil {
 			return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
 		}
+		format, err := systems.output.with(args.Output)
+		if err != nil {
+			return errResult(fmt.Errorf("output: %w", err)), nil
+		}
 		if args.FunctionName == "" {
 			return errResult(fmt.Errorf("function_name is required")), nil
 		}
@@ -664,35 +1111,21 @@ func main() {
 		if args.Parameters == nil {
 			args.Parameters = map[string]interface{}{}
 		}
-
-		desc, err := cm.describe(ctx, funcName)
+		cm, sess, err := systems.target(ctx, args.System, args.SessionID, req.Session)
 		if err != nil {
-			return errResult(fmt.Errorf("describe %q: %w", funcName, err)), nil
-		}
-		if err := validateParameters(args.Parameters, desc); err != nil {
 			return errResult(err), nil
 		}
-		coerced, err := coerceParams(args.Parameters, desc)
-		if err != nil {
-			return errResult(fmt.Errorf("coerce parameters: %w", err)), nil
-		}
-
-		t0 := time.Now()
-		result, err := cm.call(ctx, funcName, coerced)
-		m.record(funcName, time.Since(t0), err)
-		if err != nil {
-			return errResult(err), nil
-		}
-		return jsonResult(result), nil
+		return callFunction(ctx, cm, sess, m, funcName, args.Parameters, &args.resultView, format), nil
 	})
 
 	// ── get_table_metadata ────────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "get_table_metadata",
 		Description: "Retrieve field details (name, type, length, domain, description) for a SAP table via DDIF_FIELDINFO_GET.",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{"table_name":{"type":"string","description":"SAP table name (e.g. SFLIGHT)"},"language":{"type":"string","description":"Language key for descriptions (default: D)"}},"required":["table_name"]}`),
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,"table_name":{"type":"string","description":"SAP table name (e.g. SFLIGHT)"},"language":{"type":"string","description":"Language key for descriptions (default: D)"}},"required":["table_name"]}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		var args struct {
+			System    string `json:"system"`
 			TableName string `json:"table_name"`
 			Language  string `json:"language"`
 		}
@@ -705,25 +1138,30 @@ func main() {
 		if args.Language == "" {
 			args.Language = "D"
 		}
+		s, err := systems.lookup(args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
 		t0 := time.Now()
-		result, err := cm.call(ctx, "DDIF_FIELDINFO_GET", map[string]interface{}{
-			"TABNAME": strings.ToUpper(args.TableName),
-			"LANGU":   args.Language,
-		})
-		m.record("get_table_metadata", time.Since(t0), err)
+		result, snap, err := systems.fieldInfo(ctx, s, strings.ToUpper(args.TableName), args.Language)
+		m.record(s.cfg.Name, "get_table_metadata", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
+		if snap != nil {
+			return snapshotResult(result, snap), nil
+		}
 		return jsonResult(result), nil
 	})
 
 	// ── get_table_relations ───────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "get_table_relations",
 		Description: "Retrieve foreign-key relationships and cardinalities for a SAP table via FAPI_GET_FOREIGN_KEY_RELATIONS.",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{"table_name":{"type":"string","description":"SAP table name"}},"required":["table_name"]}`),
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,"table_name":{"type":"string","description":"SAP table name"}},"required":["table_name"]}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		var args struct {
+			System    string `json:"system"`
 			TableName string `json:"table_name"`
 		}
 		if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
@@ -732,11 +1170,15 @@ func main() {
 		if args.TableName == "" {
 			return errResult(fmt.Errorf("table_name is required")), nil
 		}
+		cm, err := systems.get(ctx, args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
 		t0 := time.Now()
 		result, err := cm.call(ctx, "FAPI_GET_FOREIGN_KEY_RELATIONS", map[string]interface{}{
 			"TABNAME": strings.ToUpper(args.TableName),
 		})
-		m.record("get_table_relations", time.Since(t0), err)
+		m.record(cm.system, "get_table_relations", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
@@ -744,12 +1186,13 @@ func main() {
 	})
 
 	// ── search_sap_tables ─────────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "search_sap_tables",
-		Description: "Search SAP tables by description/business term via RFC_READ_TABLE on DD02T. Use % as wildcard (e.g. '%material%').",
-		InputSchema: json.RawMessage(`{"type":"object","properties":{"search_term":{"type":"string","description":"Text to search for with LIKE semantics (% as wildcard)"},"language":{"type":"string","description":"Language key (default: D)"},"max_results":{"type":"integer","description":"Maximum results to return (default: 100)"}},"required":["search_term"]}`),
+		Description: "Search SAP tables by description/business term by reading DD02T. Use % as wildcard (e.g. '%material%').",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,"search_term":{"type":"string","description":"Text to search for with LIKE semantics (% as wildcard)"},"language":{"type":"string","description":"Language key (default: D)"},"max_results":{"type":"integer","description":"Maximum results to return (default: 100)"}},"required":["search_term"]}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		var args struct {
+			System     string `json:"system"`
 			SearchTerm string `json:"search_term"`
 			Language   string `json:"language"`
 			MaxResults int    `json:"max_results"`
@@ -766,44 +1209,206 @@ func main() {
 		if args.MaxResults <= 0 {
 			args.MaxResults = 100
 		}
+		cm, err := systems.get(ctx, args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
 
-		where := fmt.Sprintf("DDLANGUAGE = '%s' AND DDTEXT LIKE '%s'",
-			sanitizeABAPString(args.Language),
-			sanitizeABAPString(args.SearchTerm))
-
-		t0 := time.Now()
-		result, err := cm.call(ctx, "RFC_READ_TABLE", map[string]interface{}{
-			"QUERY_TABLE": "DD02T",
-			"ROWCOUNT":    args.MaxResults,
-			"OPTIONS":     []interface{}{map[string]interface{}{"TEXT": where}},
-			"FIELDS": []interface{}{
-				map[string]interface{}{"FIELDNAME": "TABNAME"},
-				map[string]interface{}{"FIELDNAME": "DDTEXT"},
-			},
+		options, err := optionsLines([]string{
+			"DDLANGUAGE", "=", "'" + sanitizeABAPString(args.Language) + "'",
+			"AND", "DDTEXT", "LIKE", "'" + sanitizeABAPString(args.SearchTerm) + "'",
 		})
-		m.record("search_sap_tables", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
 
-		rows, err := parseReadTableResult(result)
+		t0 := time.Now()
+		reader, err := cm.tableReader(ctx)
+		var rows []map[string]string
+		if err == nil {
+			rows, err = reader.read(ctx, cm, tableQuery{
+				Table:   "DD02T",
+				Fields:  []string{"TABNAME", "DDTEXT"},
+				Options: options,
+				Count:   args.MaxResults,
+			})
+		}
+		m.record(cm.system, "search_sap_tables", time.Since(t0), err)
 		if err != nil {
 			return errResult(err), nil
 		}
 		return jsonResult(rows), nil
 	})
 
+	// ── read_table ────────────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "read_table",
+		Description: "Read rows from a SAP table with structured filters and paging, via RFC_READ_TABLE or the table reader configured for the system. Values are converted to their DDIC types: integers as numbers, packed decimals and 8-byte integers as exact decimal strings (numbers with output.decimals \"number\"), dates as YYYY-MM-DD, times as HH:MM:SS.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,"table_name":{"type":"string","description":"SAP table name (e.g. T001)"},"fields":{"type":"array","items":{"type":"string"},"description":"Fields to return (default: all fields)"},"filters":{"type":"array","description":"Conditions combined with AND","items":{"type":"object","properties":{"field":{"type":"string"},"op":{"type":"string","enum":["eq","ne","lt","le","gt","ge","like","not_like","in","between"]},"value":{"description":"Comparison value; an array for 'in', two elements for 'between'. Use % as wildcard with 'like'. Dates as YYYYMMDD."}},"required":["field","op","value"]}},"order_by":{"type":"array","items":{"type":"string"},"description":"Sort the returned rows, e.g. [\"ERDAT DESC\", \"VBELN\"]. Table reads have no ORDER BY, so only the returned page is sorted."},"skip":{"type":"integer","description":"Number of rows to skip (default: 0)"},"limit":{"type":"integer","description":"Maximum rows to return (default: 100, max: 10000)"},` + tableOutputProp + `},"required":["table_name"]}`),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var args struct {
+			System    string        `json:"system"`
+			TableName string        `json:"table_name"`
+			Fields    []string      `json:"fields"`
+			Filters   []tableFilter `json:"filters"`
+			OrderBy   []string      `json:"order_by"`
+			Skip      int           `json:"skip"`
+			Limit     int           `json:"limit"`
+			Output    *outputFormat `json:"output"`
+		}
+		if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+			return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+		}
+		format, err := systems.output.with(args.Output)
+		if err != nil {
+			return errResult(fmt.Errorf("output: %w", err)), nil
+		}
+		if args.TableName == "" {
+			return errResult(fmt.Errorf("table_name is required")), nil
+		}
+		if args.Limit <= 0 {
+			args.Limit = 100
+		}
+		if args.Limit > readTableMaxRows {
+			args.Limit = readTableMaxRows
+		}
+		if args.Skip < 0 {
+			args.Skip = 0
+		}
+		cm, err := systems.get(ctx, args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
+		t0 := time.Now()
+		result, err := readTable(ctx, cm, readTableRequest{
+			Table:   args.TableName,
+			Fields:  args.Fields,
+			Filters: args.Filters,
+			OrderBy: args.OrderBy,
+			Skip:    args.Skip,
+			Limit:   args.Limit,
+		})
+		m.record(cm.system, "read_table", time.Since(t0), err)
+		if err != nil {
+			return errResult(err), nil
+		}
+		_, sp := startSpan(ctx, "mcp.serialize")
+		defer sp.end()
+		sp.set("sap.table", strings.ToUpper(args.TableName))
+		sp.set("sap.table.rows", len(result.Rows))
+		cm.redact.rows(strings.ToUpper(args.TableName), result.Rows, result.Fields)
+		formatRows(result.Rows, result.Fields, format)
+		res := jsonResult(result)
+		sp.set("mcp.result.bytes", resultBytes(res))
+		return res, nil
+	})
+
 	// ── metrics_get ───────────────────────────────────────────────────────────
-	server.AddTool(&mcp.Tool{
+	addTool(&mcp.Tool{
 		Name:        "metrics_get",
-		Description: "Return RFC call statistics: total/success/failure counts, durations, and per-function call counts.",
+		Description: "Return RFC call statistics: total/success/failure counts, durations, per-function and per-system call counts, calls rejected by the call policy, BAPI return messages per type, connection pool usage per system, open sessions, and metadata cache hits and misses.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{}}`),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		snap := m.snapshot()
+		snap["pools"] = systems.poolStats()
+		snap["cache"] = cache.stats()
+		snap["sessions"] = systems.sessions.stats()
+		return jsonResult(snap), nil
+	})
+
+	// ── cache_invalidate ──────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "cache_invalidate",
+		Description: "Drop cached function interfaces and table field lists of a system, from memory and from the on-disk snapshot, e.g. after an interface was changed in SE37 or SE11. Without a name, everything cached for the system is dropped.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `,"kind":{"type":"string","enum":["function","table"],"description":"Only drop function interfaces or table field lists (default: both)"},"name":{"type":"string","description":"Function module or table name; * and ? are wildcards (default: all)"}}}`),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var args struct {
+			System string `json:"system"`
+			Kind   string `json:"kind"`
+			Name   string `json:"name"`
+		}
+		if len(req.Params.Arguments) > 0 {
+			if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+				return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+			}
+		}
+		if args.Kind != "" && args.Kind != cacheFunction && args.Kind != cacheTable {
+			return errResult(fmt.Errorf("kind must be %q or %q", cacheFunction, cacheTable)), nil
+		}
+		s, err := systems.lookup(args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
+		n := cache.invalidate(s.cfg.Name, args.Kind, args.Name)
+		files := snapshots.invalidate(s.cfg.Name, s.identity(), args.Kind, args.Name)
+		return jsonResult(map[string]interface{}{"system": s.cfg.Name, "removed": n, "removed_snapshots": files}), nil
+	})
+
+	// ── list_systems ──────────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "list_systems",
+		Description: "List the SAP systems this server can connect to. Pass a system's name as the 'system' argument of other tools to target it.",
 		InputSchema: json.RawMessage(`{"type":"object","properties":{}}`),
 	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		return jsonResult(m.snapshot()), nil
+		return jsonResult(systems.list()), nil
 	})
 
-	logger.Printf("MCP server starting (stdio)")
-	if err := server.Run(context.Background(), &mcp.StdioTransport{}); err != nil {
-		logger.Fatalf("server error: %v", err)
+	registerSessionTools(addTool, systems, m)
+}
+
+// callFunction validates params against the interface of funcName, coerces
+// them and calls the function, on the connection of sess if it is not nil,
+// hides the values matching the redaction rules and renders the part of the
+// result selected by view in format. It serves rfc_call and the function tools.
+func callFunction(ctx context.Context, cm *connManager, sess *rfcSession, m *metrics, funcName string, params map[string]interface{}, view *resultView, format outputFormat) *mcp.CallToolResult {
+	desc, err := cm.describe(ctx, funcName)
+	if err != nil {
+		return errResult(fmt.Errorf("describe %q: %w", funcName, err))
+	}
+	cm.redact.input(funcName, desc, params)
+	if err := validateParameters(params, desc); err != nil {
+		return errResult(&invalidParameterError{err})
+	}
+	if err := checkTableReaderCall(ctx, cm, funcName, desc, params); err != nil {
+		return errResult(err)
+	}
+	if err := validateInput(params, desc); err != nil {
+		return errResult(&invalidParameterError{err})
+	}
+	if err := view.check(desc); err != nil {
+		return errResult(&invalidParameterError{err})
+	}
+	_, sp := startSpan(ctx, "rfc.coerce")
+	coerced, err := coerceParams(params, desc)
+	sp.failOn(err)
+	sp.end()
+	if err != nil {
+		return errResult(&invalidParameterError{fmt.Errorf("coerce parameters: %w", err)})
+	}
+
+	t0 := time.Now()
+	var result map[string]interface{}
+	if sess != nil {
+		result, err = sess.call(ctx, funcName, coerced)
+	} else {
+		result, err = cm.call(ctx, funcName, coerced)
+	}
+	if err != nil {
+		m.record(cm.system, funcName, time.Since(t0), err)
+		return errResult(err)
+	}
+	result = cm.redact.result(funcName, desc, result)
+	msgs := bapiMessages(desc, result)
+	m.record(cm.system, funcName, time.Since(t0), bapiFailure(funcName, msgs))
+	m.recordBAPI(msgs)
+	_, sp = startSpan(ctx, "mcp.serialize")
+	defer sp.end()
+	result, note := view.apply(result, desc)
+	sp.set("rfc.rows", resultRows(result))
+	res := bapiResult(formatResult(result, desc, format), msgs)
+	if note != "" {
+		res.Content = append(res.Content, &mcp.TextContent{Text: note})
 	}
+	sp.set("mcp.result.bytes", resultBytes(res))
+	return res
 }
@@ -4,11 +4,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
 )
 
 func TestMain(m *testing.M) {
@@ -30,7 +35,7 @@ func TestMain(m *testing.M) {
 func connManagerOrSkip(t *testing.T) *connManager {
 	t.Helper()
 	if dest := os.Getenv("SAP_DEST"); dest != "" {
-		cm, err := newConnManager(dest)
+		cm, err := newConnManager(dest, defaultPoolConfig())
 		if err != nil {
 			t.Fatalf("newConnManager: %v", err)
 		}
@@ -43,7 +48,7 @@ func connManagerOrSkip(t *testing.T) *connManager {
 	if params == nil {
 		t.Skip("neither SAP_DEST nor SAP_ASHOST/SAP_MSHOST set — skipping integration test")
 	}
-	cm, err := newConnManagerFromParams(params)
+	cm, err := newConnManagerFromParams(params, defaultPoolConfig())
 	if err != nil {
 		t.Fatalf("newConnManagerFromParams: %v", err)
 	}
@@ -163,6 +168,200 @@ func TestCallAbapException(t *testing.T) {
 	}
 }
 
+// TestPoolParallelCalls runs more concurrent calls than the pool allows and
+// checks that the pool never opens more than MaxSize handles.
+func TestPoolParallelCalls(t *testing.T) {
+	cm := connManagerOrSkip(t)
+	cm.close()
+	cfg := poolConfig{MinSize: 1, MaxSize: 2, IdleTimeout: time.Minute}
+	cm, err := newConnManagerFromParams(cm.connParams, cfg)
+	if err != nil {
+		t.Fatalf("newConnManagerFromParams: %v", err)
+	}
+	defer cm.close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- cm.ping(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("ping: %v", err)
+		}
+	}
+
+	stats := cm.pool.stats()
+	if open := stats["open"].(int); open > cfg.MaxSize {
+		t.Errorf("pool open = %d, want <= %d", open, cfg.MaxSize)
+	}
+	if inUse := stats["in_use"].(int); inUse != 0 {
+		t.Errorf("pool in_use = %d after all calls returned, want 0", inUse)
+	}
+}
+
+// TestCallCancelledContext checks that a cancelled context stops the call
+// without touching SAP and is reported as context.Canceled.
+func TestCallCancelledContext(t *testing.T) {
+	cm := connManagerOrSkip(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := cm.call(ctx, "STFC_CONNECTION", map[string]interface{}{"REQUTEXT": "x"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("call with cancelled context: err = %v, want context.Canceled", err)
+	}
+	if pingErr := cm.ping(context.Background()); pingErr != nil {
+		t.Errorf("ping after cancelled call failed: %v", pingErr)
+	}
+}
+
+// TestPolicyRejectsCall checks that a read-only policy blocks a module outside
+// the curated set before it reaches SAP, while still permitting known readers.
+func TestPolicyRejectsCall(t *testing.T) {
+	cm := connManagerOrSkip(t)
+	cm.policy = &callPolicy{ReadOnly: true, Deny: []string{"*_EXCEPTION"}}
+	ctx := context.Background()
+
+	_, err := cm.call(ctx, "STFC_EXCEPTION", map[string]interface{}{})
+	var pe *policyError
+	if !errors.As(err, &pe) {
+		t.Fatalf("call STFC_EXCEPTION: err = %v, want *policyError", err)
+	}
+	if !strings.Contains(pe.Reason, "deny") {
+		t.Errorf("policy reason = %q, want deny pattern match", pe.Reason)
+	}
+
+	if _, err := cm.call(ctx, "STFC_CONNECTION", map[string]interface{}{"REQUTEXT": "x"}); err != nil {
+		t.Errorf("call STFC_CONNECTION under read-only policy: %v", err)
+	}
+}
+
+// TestReadTable reads the client table T000, which exists on every system,
+// with a filter and a one-row page.
+func TestReadTable(t *testing.T) {
+	cm := connManagerOrSkip(t)
+
+	res, err := readTable(context.Background(), cm, readTableRequest{
+		Table:   "t000",
+		Fields:  []string{"MANDT", "MTEXT"},
+		Filters: []tableFilter{{Field: "MANDT", Op: "like", Value: "%"}},
+		Limit:   1,
+	})
+	if err != nil {
+		t.Fatalf("readTable T000: %v", err)
+	}
+	if res.RowCount != 1 {
+		t.Fatalf("row_count = %d, want 1", res.RowCount)
+	}
+	if mandt, _ := res.Rows[0]["MANDT"].(string); len(mandt) != 3 {
+		t.Errorf("MANDT = %q, want a 3-character client", mandt)
+	}
+	if res.HasMore && res.NextSkip != 1 {
+		t.Errorf("next_skip = %d, want 1", res.NextSkip)
+	}
+}
+
+// TestReadTableWide reads all fields of T001, whose rows exceed the
+// 512-character RFC_READ_TABLE buffer, and checks that the column chunks were
+// joined into complete rows.
+func TestReadTableWide(t *testing.T) {
+	cm := connManagerOrSkip(t)
+
+	res, err := readTable(context.Background(), cm, readTableRequest{Table: "T001", Limit: 5})
+	if err != nil {
+		t.Fatalf("readTable T001: %v", err)
+	}
+	if res.ColumnChunks < 2 {
+		t.Skipf("T001 fits into one row on this system (column_chunks = %d)", res.ColumnChunks)
+	}
+	for _, row := range res.Rows {
+		if len(row) != len(res.Fields) {
+			t.Errorf("row has %d fields, want %d", len(row), len(res.Fields))
+		}
+		if bukrs, _ := row["BUKRS"].(string); bukrs == "" {
+			t.Errorf("row without BUKRS: %v", row)
+		}
+	}
+}
+
+// TestTableReaderAuto probes the available table reader and reads T000
+// through it directly.
+func TestTableReaderAuto(t *testing.T) {
+	cm := connManagerOrSkip(t)
+
+	reader, err := cm.tableReader(context.Background())
+	if err != nil {
+		t.Fatalf("tableReader: %v", err)
+	}
+	if !containsStr(tableReaderCandidates, reader.name()) {
+		t.Errorf("reader = %q, want one of %v", reader.name(), tableReaderCandidates)
+	}
+	if reader.rowWidth() < readTableRowWidth {
+		t.Errorf("rowWidth = %d, want at least %d", reader.rowWidth(), readTableRowWidth)
+	}
+	rows, err := reader.read(context.Background(), cm, tableQuery{Table: "T000", Fields: []string{"MANDT"}, Count: 1})
+	if err != nil {
+		t.Fatalf("%s T000: %v", reader.name(), err)
+	}
+	if len(rows) != 1 || len(rows[0]["MANDT"]) != 3 {
+		t.Errorf("rows = %v, want one 3-character client", rows)
+	}
+}
+
+// TestDescribeCache checks that a second describe is served from the metadata
+// cache and that cache_invalidate's pattern drops the entry.
+func TestDescribeCache(t *testing.T) {
+	cm := connManagerOrSkip(t)
+	cm.cache = newMetadataCache(cacheConfig{TTL: time.Minute, MaxSize: 10})
+
+	for i := 0; i < 2; i++ {
+		if _, err := cm.describe(context.Background(), "STFC_CONNECTION"); err != nil {
+			t.Fatalf("describe: %v", err)
+		}
+	}
+	stats := cm.cache.stats()
+	if stats["hits"] != int64(1) || stats["misses"] != int64(1) {
+		t.Errorf("hits/misses = %v/%v, want 1/1", stats["hits"], stats["misses"])
+	}
+	if n := cm.cache.invalidate(cm.system, cacheFunction, "STFC_*"); n != 1 {
+		t.Errorf("invalidate removed %d entries, want 1", n)
+	}
+}
+
+// TestDescribeSnapshot checks that a fetched interface is written to the
+// snapshot directory and can be loaded by system name alone, as done while
+// SAP is unreachable.
+func TestDescribeSnapshot(t *testing.T) {
+	cm := connManagerOrSkip(t)
+	cm.system = "TEST"
+	cm.snapshots = &snapshotStore{dir: t.TempDir(), index: map[string]string{}}
+
+	want, err := cm.describe(context.Background(), "STFC_CONNECTION")
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	var got gorfc.FunctionDescription
+	info, ok := cm.snapshots.load("TEST", sapIdentity{}, cacheFunction, "STFC_CONNECTION", &got)
+	if !ok {
+		t.Fatal("no snapshot entry for STFC_CONNECTION")
+	}
+	if got.Name != want.Name || len(got.Parameters) != len(want.Parameters) {
+		t.Errorf("snapshot = %s with %d parameters, want %s with %d", got.Name, len(got.Parameters), want.Name, len(want.Parameters))
+	}
+	if id := cm.identity(); info.SystemID != id.SystemID || info.Client != id.Client {
+		t.Errorf("snapshot of %s/%s, want %s/%s", info.SystemID, info.Client, id.SystemID, id.Client)
+	}
+	if n := cm.snapshots.invalidate("TEST", sapIdentity{}, "", "STFC_CONNECTION"); n != 1 {
+		t.Errorf("invalidate removed %d files, want 1", n)
+	}
+}
 
 // ── helpers ───────────────────────────────────────────────────────────────────
 
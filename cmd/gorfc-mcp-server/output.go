@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Output format ────────────────────────────────────────────────────────────
+
+// Renderings of packed decimals and 8-byte integers in results.
+const (
+	decimalsString = "string" // decimal strings, exact in every JSON parser
+	decimalsNumber = "number" // JSON numbers carrying every digit
+)
+
+// Output profiles: the defaults of the normalization options.
+const (
+	profileRaw     = "raw"     // values as gorfc returns them
+	profileCompact = "compact" // every normalization option on except alpha
+)
+
+// outputFormat controls how function results are rendered. The server-wide
+// format comes from outputFormatFromEnv; rfc_call and read_table may
+// override it per call. The normalization options default to the profile
+// when unset.
+type outputFormat struct {
+	Profile   string `json:"profile,omitempty"`    // profileRaw (default) or profileCompact
+	Decimals  string `json:"decimals,omitempty"`   // decimalsString (default) or decimalsNumber
+	Trim      *bool  `json:"trim,omitempty"`       // trim blanks of CHAR and STRING values
+	ISODates  *bool  `json:"iso_dates,omitempty"`  // DATE as YYYY-MM-DD, TIME as HH:MM:SS
+	OmitEmpty *bool  `json:"omit_empty,omitempty"` // drop fields and parameters with initial values
+	Alpha     *bool  `json:"alpha,omitempty"`      // strip leading zeros of NUMC values (read_table: also of ALPHA fields)
+	Flatten   *bool  `json:"flatten,omitempty"`    // return single-row tables as the row
+}
+
+// outputFormatFromEnv combines the output section of the config file with
+// the environment.
+//
+//	MCP_OUTPUT_PROFILE   – "raw" (default) or "compact": defaults of the normalization options
+//	MCP_OUTPUT_DECIMALS  – "string" (default) or "number": rendering of BCD, DECF and INT8 values
+func outputFormatFromEnv(cfg *serverConfig) (outputFormat, error) {
+	var f outputFormat
+	if cfg != nil && cfg.Output != nil {
+		f = *cfg.Output
+	}
+	if s := os.Getenv("MCP_OUTPUT_PROFILE"); s != "" {
+		f.Profile = s
+	}
+	if s := os.Getenv("MCP_OUTPUT_DECIMALS"); s != "" {
+		f.Decimals = s
+	}
+	if err := f.validate(); err != nil {
+		return f, fmt.Errorf("output: %w", err)
+	}
+	return f, nil
+}
+
+func (f outputFormat) validate() error {
+	switch f.Profile {
+	case "", profileRaw, profileCompact:
+	default:
+		return fmt.Errorf("profile must be %q or %q, got %q", profileRaw, profileCompact, f.Profile)
+	}
+	switch f.Decimals {
+	case "", decimalsString, decimalsNumber:
+		return nil
+	}
+	return fmt.Errorf("decimals must be %q or %q, got %q", decimalsString, decimalsNumber, f.Decimals)
+}
+
+// with returns f with the settings of o that are set. A profile in o
+// replaces the normalization options of f.
+func (f outputFormat) with(o *outputFormat) (outputFormat, error) {
+	if o == nil {
+		return f, nil
+	}
+	if err := o.validate(); err != nil {
+		return f, err
+	}
+	if o.Profile != "" {
+		f = outputFormat{Profile: o.Profile, Decimals: f.Decimals}
+	}
+	if o.Decimals != "" {
+		f.Decimals = o.Decimals
+	}
+	for _, opt := range []struct{ dst, src **bool }{
+		{&f.Trim, &o.Trim},
+		{&f.ISODates, &o.ISODates},
+		{&f.OmitEmpty, &o.OmitEmpty},
+		{&f.Alpha, &o.Alpha},
+		{&f.Flatten, &o.Flatten},
+	} {
+		if *opt.src != nil {
+			*opt.dst = *opt.src
+		}
+	}
+	return f, nil
+}
+
+// on reports whether the normalization option opt of f is enabled.
+func (f outputFormat) on(opt *bool) bool {
+	if opt != nil {
+		return *opt
+	}
+	return f.Profile == profileCompact
+}
+
+// alpha reports whether leading zeros are stripped. Function descriptions
+// do not name conversion exits, so in function results only NUMC values are
+// stripped; a CHAR value of digits may as well be a postal code. No profile
+// enables it.
+func (f outputFormat) alpha() bool {
+	return f.Alpha != nil && *f.Alpha
+}
+
+// Schemas of the options of the optional "output" argument.
+const (
+	outputProfileProp   = `"profile":{"type":"string","enum":["raw","compact"],"description":"raw: values as returned by SAP; compact: trim, iso_dates, omit_empty and flatten enabled. Options given here override the profile"}`
+	outputOmitEmptyProp = `"omit_empty":{"type":"boolean","description":"Drop fields and parameters with initial values: blank, zero, initial dates and times, empty tables and structures"}`
+	outputAlphaProp     = `"alpha":{"type":"boolean","description":"Strip leading zeros from NUMC values, e.g. 000010 becomes 10; read_table also strips them from fields with the ALPHA conversion exit"}`
+	outputDecimalsProp  = `"decimals":{"type":"string","enum":["string","number"],"description":"BCD, DECF and INT8 values as exact decimal strings (string) or as JSON numbers with every digit (number)"}`
+)
+
+// outputProp is the JSON Schema of the optional "output" argument of
+// rfc_call.
+const outputProp = `"output":{"type":"object","description":"Rendering of the result; defaults to the server's settings","properties":{` +
+	outputProfileProp + `,` +
+	outputDecimalsProp + `,` +
+	`"trim":{"type":"boolean","description":"Trim blanks from CHAR and STRING values"},` +
+	`"iso_dates":{"type":"boolean","description":"DATE values as YYYY-MM-DD and TIME values as HH:MM:SS"},` +
+	outputOmitEmptyProp + `,` +
+	outputAlphaProp + `,` +
+	`"flatten":{"type":"boolean","description":"Return tables with exactly one row as that row"}}}`
+
+// tableOutputProp is the JSON Schema of the optional "output" argument of
+// read_table, whose values are always trimmed and whose dates are ISO 8601.
+const tableOutputProp = `"output":{"type":"object","description":"Rendering of the rows; defaults to the server's settings","properties":{` +
+	outputProfileProp + `,` + outputDecimalsProp + `,` + outputOmitEmptyProp + `,` + outputAlphaProp + `}}`
+
+// jsonNumberRE matches the JSON number syntax.
+var jsonNumberRE = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// formatResult renders the EXPORT, CHANGING and TABLES parameters of result
+// as f prescribes.
+func formatResult(result map[string]interface{}, desc gorfc.FunctionDescription, f outputFormat) map[string]interface{} {
+	out := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		out[k] = v
+	}
+	for _, p := range desc.Parameters {
+		v, ok := out[p.Name]
+		if !ok || p.Direction == "RFC_IMPORT" {
+			continue
+		}
+		v = formatValue(v, p.ParameterType, p.TypeDesc, f)
+		if f.on(f.OmitEmpty) && isInitial(v, p.ParameterType) {
+			delete(out, p.Name)
+		} else {
+			out[p.Name] = v
+		}
+	}
+	return out
+}
+
+func formatValue(val interface{}, rfcType string, td gorfc.TypeDescription, f outputFormat) interface{} {
+	switch rfcType {
+	case "RFCTYPE_BCD", "RFCTYPE_DECF16", "RFCTYPE_DECF34":
+		s, ok := val.(string)
+		if !ok {
+			return val
+		}
+		s = abapSign(strings.TrimSpace(s))
+		if f.Decimals == decimalsNumber && jsonNumberRE.MatchString(s) {
+			return json.Number(s)
+		}
+		return s
+	case "RFCTYPE_INT8":
+		if i, ok := val.(int64); ok && f.Decimals != decimalsNumber {
+			return strconv.FormatInt(i, 10)
+		}
+		return val
+	case "RFCTYPE_CHAR", "RFCTYPE_STRING":
+		s, ok := val.(string)
+		if !ok {
+			return val
+		}
+		if f.on(f.Trim) {
+			s = strings.TrimSpace(s)
+		}
+		return s
+	case "RFCTYPE_NUM":
+		if s, ok := val.(string); ok && f.alpha() {
+			return alphaOutput(s)
+		}
+		return val
+	case "RFCTYPE_DATE":
+		if f.on(f.ISODates) {
+			return isoDateTime(val, "2006-01-02", "20060102")
+		}
+		return val
+	case "RFCTYPE_TIME":
+		if f.on(f.ISODates) {
+			return isoDateTime(val, "15:04:05", "150405")
+		}
+		return val
+	case "RFCTYPE_STRUCTURE":
+		row, ok := val.(map[string]interface{})
+		if !ok {
+			return val
+		}
+		out := make(map[string]interface{}, len(row))
+		for k, v := range row {
+			out[k] = v
+		}
+		for _, fd := range td.Fields {
+			v, ok := out[fd.Name]
+			if !ok {
+				continue
+			}
+			v = formatValue(v, fd.FieldType, fd.TypeDesc, f)
+			if f.on(f.OmitEmpty) && isInitial(v, fd.FieldType) {
+				delete(out, fd.Name)
+			} else {
+				out[fd.Name] = v
+			}
+		}
+		return out
+	case "RFCTYPE_TABLE":
+		rows, ok := val.([]interface{})
+		if !ok {
+			return val
+		}
+		out := make([]interface{}, len(rows))
+		for i, row := range rows {
+			out[i] = formatValue(row, "RFCTYPE_STRUCTURE", td, f)
+		}
+		if len(out) == 1 && f.on(f.Flatten) {
+			return out[0]
+		}
+		return out
+	}
+	return val
+}
+
+// alphaOutput strips the leading zeros of s if it consists of digits only,
+// as the ALPHA conversion exit does on output.
+func alphaOutput(s string) string {
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return s
+	}
+	if t := strings.TrimLeft(s, "0"); t != "" {
+		return t
+	}
+	return "0"
+}
+
+// isoDateTime formats a DATE or TIME value, a time.Time from gorfc or an
+// ABAP string in the layout abap, in the ISO 8601 layout iso.
+func isoDateTime(val interface{}, iso, abap string) interface{} {
+	switch v := val.(type) {
+	case time.Time:
+		return v.Format(iso)
+	case string:
+		if t, err := time.Parse(abap, v); err == nil {
+			return t.Format(iso)
+		}
+	}
+	return val
+}
+
+// isInitial reports whether val, a formatted value of the given RFC type,
+// is ABAP's initial value for it or an empty table or structure.
+func isInitial(val interface{}, rfcType string) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case string:
+		switch rfcType {
+		case "RFCTYPE_NUM", "RFCTYPE_DATE":
+			return strings.Trim(v, "0- ") == ""
+		case "RFCTYPE_TIME":
+			return strings.Trim(v, "0: ") == ""
+		case "RFCTYPE_BCD", "RFCTYPE_DECF16", "RFCTYPE_DECF34", "RFCTYPE_INT8":
+			r, ok := new(big.Rat).SetString(v)
+			return ok && r.Sign() == 0
+		}
+		return strings.TrimSpace(v) == ""
+	case json.Number:
+		r, ok := new(big.Rat).SetString(v.String())
+		return ok && r.Sign() == 0
+	case time.Time:
+		if rfcType == "RFCTYPE_TIME" {
+			return v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0
+		}
+		return v.IsZero()
+	case []byte:
+		return len(bytes.Trim(v, "\x00")) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	// Numbers, in whichever type gorfc returns them: int32 for INT, uint8
+	// for INT1, int16 for INT2, int64 for INT8 and float64 for FLOAT.
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	}
+	return false
+}
+
+// ddicRFCTypes maps the ABAP internal types of table fields to RFC types.
+var ddicRFCTypes = map[string]string{
+	"C": "RFCTYPE_CHAR",
+	"g": "RFCTYPE_STRING",
+	"N": "RFCTYPE_NUM",
+	"D": "RFCTYPE_DATE",
+	"T": "RFCTYPE_TIME",
+	"P": "RFCTYPE_BCD",
+	"a": "RFCTYPE_DECF16",
+	"e": "RFCTYPE_DECF34",
+	"8": "RFCTYPE_INT8",
+	"I": "RFCTYPE_INT",
+	"b": "RFCTYPE_INT1",
+	"s": "RFCTYPE_INT2",
+	"F": "RFCTYPE_FLOAT",
+}
+
+// formatRows applies the decimals, alpha and omit_empty options of f to
+// table rows converted by convertDDICValue. As in formatValue, packed
+// decimals and 8-byte integers become strings unless decimals is number.
+// Unlike formatValue, alpha only touches NUMC fields and fields with the
+// ALPHA conversion exit, which the DDIC tells apart for tables.
+func formatRows(rows []map[string]interface{}, fields []ddicField, f outputFormat) {
+	alpha, omit := f.alpha(), f.on(f.OmitEmpty)
+	for _, row := range rows {
+		for _, fd := range fields {
+			v, ok := row[fd.Name]
+			if !ok {
+				continue
+			}
+			switch x := v.(type) {
+			case json.Number:
+				if f.Decimals != decimalsNumber {
+					v = x.String()
+				}
+			case int64:
+				if fd.IntType == "8" && f.Decimals != decimalsNumber {
+					v = strconv.FormatInt(x, 10)
+				}
+			case string:
+				if alpha && (fd.IntType == "N" || fd.ConvExit == "ALPHA") {
+					v = alphaOutput(x)
+				}
+			}
+			if omit && isInitial(v, ddicRFCTypes[fd.IntType]) {
+				delete(row, fd.Name)
+			} else {
+				row[fd.Name] = v
+			}
+		}
+	}
+}
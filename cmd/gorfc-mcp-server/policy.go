@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ─── Call policy ──────────────────────────────────────────────────────────────
+
+// readOnlyFunctions is the curated set of function modules permitted in
+// read-only mode. They only read data or metadata.
+var readOnlyFunctions = []string{
+	"RFC_PING",
+	"RFC_SYSTEM_INFO",
+	"RFC_GET_FUNCTION_INTERFACE",
+	"RFC_FUNCTION_SEARCH",
+	"STFC_CONNECTION",
+	"RFC_READ_TABLE",
+	"BBP_RFC_READ_TABLE",
+	"/BODS/RFC_READ_TABLE2",
+	"DDIF_FIELDINFO_GET",
+	"FAPI_GET_FOREIGN_KEY_RELATIONS",
+	"BAPI_*_GETLIST",
+	"BAPI_*_GETDETAIL",
+	"BAPI_*_GET_DETAIL",
+	"BAPI_*_GETSTATUS",
+	"BAPI_*_EXISTENCECHECK",
+}
+
+// callPolicy decides which function modules may be invoked. Patterns are
+// matched case-insensitively; '*' matches any run of characters and '?' a
+// single character. Deny patterns always win. A non-empty Allow list and
+// read-only mode each restrict calls further.
+type callPolicy struct {
+	ReadOnly          bool     `json:"read_only,omitempty"`
+	ReadOnlyFunctions []string `json:"read_only_functions,omitempty"` // extends readOnlyFunctions
+	Allow             []string `json:"allow,omitempty"`
+	Deny              []string `json:"deny,omitempty"`
+
+	parent *callPolicy // must permit a call as well; see within
+}
+
+// policyError is returned for calls rejected by a callPolicy.
+type policyError struct {
+	Function string
+	Reason   string
+}
+
+func (e *policyError) Error() string {
+	return fmt.Sprintf("call to %s rejected by policy: %s", e.Function, e.Reason)
+}
+
+// policyFromEnv combines the policy section of the config file with the
+// environment. Environment lists extend the file's lists.
+//
+//	MCP_READ_ONLY            – "true" permits only known read-only modules
+//	MCP_READ_ONLY_FUNCTIONS  – additional read-only patterns, comma-separated
+//	MCP_RFC_ALLOW            – allow-list patterns, comma-separated
+//	MCP_RFC_DENY             – deny-list patterns, comma-separated
+func policyFromEnv(cfg *serverConfig) (*callPolicy, error) {
+	p := &callPolicy{}
+	if cfg != nil && cfg.Policy != nil {
+		*p = *cfg.Policy
+	}
+	if s := os.Getenv("MCP_READ_ONLY"); s != "" {
+		ro, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_READ_ONLY: %w", err)
+		}
+		p.ReadOnly = p.ReadOnly || ro
+	}
+	p.ReadOnlyFunctions = append(p.ReadOnlyFunctions, splitList(os.Getenv("MCP_READ_ONLY_FUNCTIONS"))...)
+	p.Allow = append(p.Allow, splitList(os.Getenv("MCP_RFC_ALLOW"))...)
+	p.Deny = append(p.Deny, splitList(os.Getenv("MCP_RFC_DENY"))...)
+	return p, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// within returns p restricted by parent: a call must be permitted by both, so
+// that a system's policy can narrow the server-wide policy but never lift its
+// deny list, allow-list or read-only mode.
+func (p *callPolicy) within(parent *callPolicy) *callPolicy {
+	switch {
+	case p == nil:
+		return parent
+	case parent == nil:
+		return p
+	}
+	out := *p
+	out.parent = parent
+	return &out
+}
+
+// readOnly reports whether p or a policy it is restricted by is in read-only
+// mode.
+func (p *callPolicy) readOnly() bool {
+	return p != nil && (p.ReadOnly || p.parent.readOnly())
+}
+
+// check returns a *policyError if funcName may not be called. A nil policy
+// permits everything.
+func (p *callPolicy) check(funcName string) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.parent.check(funcName); err != nil {
+		return err
+	}
+	name := strings.ToUpper(funcName)
+	if pat, ok := matchAny(p.Deny, name); ok {
+		return &policyError{Function: name, Reason: fmt.Sprintf("matches deny pattern %q", pat)}
+	}
+	if len(p.Allow) > 0 {
+		if _, ok := matchAny(p.Allow, name); !ok {
+			return &policyError{Function: name, Reason: "not on the allow-list"}
+		}
+	}
+	if p.ReadOnly {
+		_, builtin := matchAny(readOnlyFunctions, name)
+		_, extra := matchAny(p.ReadOnlyFunctions, name)
+		if !builtin && !extra {
+			return &policyError{Function: name, Reason: "read-only mode permits only known read-only function modules"}
+		}
+	}
+	return nil
+}
+
+// matchAny returns the first pattern matching name.
+func matchAny(patterns []string, name string) (string, bool) {
+	for _, pat := range patterns {
+		if wildcardMatch(strings.ToUpper(pat), name) {
+			return pat, true
+		}
+	}
+	return "", false
+}
+
+// wildcardMatch reports whether name matches pattern, where '*' matches any
+// run of characters (including '/') and '?' matches exactly one.
+func wildcardMatch(pattern, name string) bool {
+	p, n := 0, 0
+	star, mark := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, n
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			n = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
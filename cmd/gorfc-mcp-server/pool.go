@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ─── Connection Pool ──────────────────────────────────────────────────────────
+
+// poolConfig bounds the number and age of connections held by a connPool.
+type poolConfig struct {
+	MinSize     int           // connections opened eagerly and kept open when idle
+	MaxSize     int           // upper bound of open connections (idle + in use)
+	IdleTimeout time.Duration // idle connections above MinSize are closed after this; 0 disables
+	MaxLifetime time.Duration // connections are closed after this age; 0 disables
+}
+
+func defaultPoolConfig() poolConfig {
+	return poolConfig{
+		MinSize:     1,
+		MaxSize:     4,
+		IdleTimeout: 5 * time.Minute,
+		MaxLifetime: time.Hour,
+	}
+}
+
+func (c poolConfig) validate() error {
+	if c.MaxSize < 1 {
+		return fmt.Errorf("pool max size must be at least 1, got %d", c.MaxSize)
+	}
+	if c.MinSize < 0 || c.MinSize > c.MaxSize {
+		return fmt.Errorf("pool min size must be between 0 and %d, got %d", c.MaxSize, c.MinSize)
+	}
+	if c.IdleTimeout < 0 || c.MaxLifetime < 0 {
+		return fmt.Errorf("pool timeouts must not be negative")
+	}
+	return nil
+}
+
+// poolConfigFromEnv reads pool settings from SAP_POOL_* environment variables,
+// falling back to defaultPoolConfig for unset values.
+//
+//	SAP_POOL_MIN           – connections kept open (default 1)
+//	SAP_POOL_MAX           – maximum concurrent connections (default 4)
+//	SAP_POOL_IDLE_TIMEOUT  – close idle connections after this duration (default 5m, 0 disables)
+//	SAP_POOL_MAX_LIFETIME  – recycle connections after this duration (default 1h, 0 disables)
+func poolConfigFromEnv() (poolConfig, error) {
+	cfg := defaultPoolConfig()
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{{"SAP_POOL_MIN", &cfg.MinSize}, {"SAP_POOL_MAX", &cfg.MaxSize}} {
+		if s := os.Getenv(v.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %w", v.name, err)
+			}
+			*v.dst = n
+		}
+	}
+	for _, v := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"SAP_POOL_IDLE_TIMEOUT", &cfg.IdleTimeout}, {"SAP_POOL_MAX_LIFETIME", &cfg.MaxLifetime}} {
+		if s := os.Getenv(v.name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %w", v.name, err)
+			}
+			*v.dst = d
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+var errPoolClosed = errors.New("connection pool closed")
+
+type pooledConn struct {
+	conn     rfcConn
+	created  time.Time
+	lastUsed time.Time
+}
+
+// connPool hands out RFC connections so that each handle is used by exactly
+// one goroutine at a time while independent calls run in parallel. The slots
+// channel holds one token per connection that may be in use; idle connections
+// are reused LIFO so that surplus handles age out via IdleTimeout.
+type connPool struct {
+	cfg   poolConfig
+	dial  func() (rfcConn, error)
+	slots chan struct{}
+	done  chan struct{}
+
+	mu      sync.Mutex
+	idle    []*pooledConn
+	open    int
+	inUse   int
+	closed  bool
+	created int64
+	retired int64
+	waits   int64
+	waitDur time.Duration
+}
+
+// newConnPool opens cfg.MinSize connections and starts the background reaper.
+func newConnPool(cfg poolConfig, dial func() (rfcConn, error)) (*connPool, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	p := &connPool{
+		cfg:   cfg,
+		dial:  dial,
+		slots: make(chan struct{}, cfg.MaxSize),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < cfg.MinSize; i++ {
+		pc, err := p.newConn()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.mu.Lock()
+		p.idle = append(p.idle, pc)
+		p.mu.Unlock()
+	}
+	go p.reap()
+	return p, nil
+}
+
+func (p *connPool) newConn() (*pooledConn, error) {
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p.mu.Lock()
+	p.open++
+	p.created++
+	p.mu.Unlock()
+	return &pooledConn{conn: conn, created: now, lastUsed: now}, nil
+}
+
+// retire closes a connection that is no longer in the pool's idle list.
+func (p *connPool) retire(pc *pooledConn) {
+	pc.conn.Close()
+	p.mu.Lock()
+	p.open--
+	p.retired++
+	p.mu.Unlock()
+}
+
+func (p *connPool) expired(pc *pooledConn, now time.Time) bool {
+	return p.cfg.MaxLifetime > 0 && now.Sub(pc.created) >= p.cfg.MaxLifetime
+}
+
+// get blocks until a slot is free or ctx ends and returns an idle connection,
+// or a newly dialed one when none is idle or fresh is set (used after a
+// communication failure, when the remaining idle handles are suspect too).
+func (p *connPool) get(ctx context.Context, fresh bool) (*pooledConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		t0 := time.Now()
+		var err error
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			err = ctxErr(ctx)
+		}
+		p.mu.Lock()
+		p.waits++
+		p.waitDur += time.Since(t0)
+		p.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, errPoolClosed
+	}
+	var stale []*pooledConn
+	var pc *pooledConn
+	now := time.Now()
+	for !fresh && pc == nil && len(p.idle) > 0 {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.expired(last, now) {
+			stale = append(stale, last)
+			continue
+		}
+		pc = last
+	}
+	p.inUse++
+	p.mu.Unlock()
+
+	for _, s := range stale {
+		p.retire(s)
+	}
+	if pc != nil {
+		return pc, nil
+	}
+	pc, err := p.newConn()
+	if err != nil {
+		p.mu.Lock()
+		p.inUse--
+		p.mu.Unlock()
+		<-p.slots
+		return nil, err
+	}
+	return pc, nil
+}
+
+// put returns a connection obtained from get. Unhealthy or expired connections
+// are closed instead of being reused.
+func (p *connPool) put(pc *pooledConn, healthy bool) {
+	now := time.Now()
+	p.mu.Lock()
+	p.inUse--
+	keep := healthy && !p.closed && !p.expired(pc, now)
+	if keep {
+		pc.lastUsed = now
+		p.idle = append(p.idle, pc)
+	}
+	p.mu.Unlock()
+	if !keep {
+		p.retire(pc)
+	}
+	<-p.slots
+}
+
+// reap periodically closes connections that exceeded IdleTimeout or
+// MaxLifetime while keeping at least MinSize connections open.
+func (p *connPool) reap() {
+	interval := time.Minute
+	for _, d := range []time.Duration{p.cfg.IdleTimeout, p.cfg.MaxLifetime} {
+		if d > 0 && d/2 < interval {
+			interval = d / 2
+		}
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.reapOnce(time.Now())
+		}
+	}
+}
+
+func (p *connPool) reapOnce(now time.Time) {
+	p.mu.Lock()
+	var stale []*pooledConn
+	kept := p.idle[:0]
+	open := p.open
+	for _, pc := range p.idle {
+		idleTooLong := p.cfg.IdleTimeout > 0 && now.Sub(pc.lastUsed) >= p.cfg.IdleTimeout && open > p.cfg.MinSize
+		if p.expired(pc, now) || idleTooLong {
+			stale = append(stale, pc)
+			open--
+			continue
+		}
+		kept = append(kept, pc)
+	}
+	p.idle = kept
+	p.mu.Unlock()
+
+	for _, pc := range stale {
+		p.retire(pc)
+	}
+
+	// Top up to MinSize after retiring expired connections.
+	for {
+		p.mu.Lock()
+		short := !p.closed && p.open < p.cfg.MinSize
+		p.mu.Unlock()
+		if !short {
+			return
+		}
+		pc, err := p.newConn()
+		if err != nil {
+			logger.Warn("pool refill failed", "err", err)
+			return
+		}
+		p.mu.Lock()
+		p.idle = append(p.idle, pc)
+		p.mu.Unlock()
+	}
+}
+
+// close closes all idle connections and stops the reaper. Connections still
+// in use are closed when they are returned.
+func (p *connPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	close(p.done)
+	for _, pc := range idle {
+		p.retire(pc)
+	}
+}
+
+func (p *connPool) stats() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"min_size":         p.cfg.MinSize,
+		"max_size":         p.cfg.MaxSize,
+		"idle_timeout_ms":  p.cfg.IdleTimeout.Milliseconds(),
+		"max_lifetime_ms":  p.cfg.MaxLifetime.Milliseconds(),
+		"open":             p.open,
+		"idle":             len(p.idle),
+		"in_use":           p.inUse,
+		"created":          p.created,
+		"retired":          p.retired,
+		"waits":            p.waits,
+		"wait_duration_ms": p.waitDur.Milliseconds(),
+	}
+}
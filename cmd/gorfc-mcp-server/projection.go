@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Result projection ────────────────────────────────────────────────────────
+
+// resultView selects the parts of a function result that rfc_call returns:
+// parameters and nested fields, table rows matching filters, and at most
+// MaxRows rows per table. The zero value selects everything.
+type resultView struct {
+	Fields  []string    `json:"fields,omitempty"`   // PARAM or PARAM.FIELD[.FIELD...]
+	Filters []rowFilter `json:"filters,omitempty"`  // combined with AND per table
+	MaxRows int         `json:"max_rows,omitempty"` // per table; 0 is unlimited
+}
+
+// rowFilter is a condition on a field of the rows of a table parameter. Op
+// takes the operators of read_table's filters.
+type rowFilter struct {
+	Table string      `json:"table"`
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// resultViewProps are the JSON Schemas of the resultView arguments of
+// rfc_call.
+const resultViewProps = `"fields":{"type":"array","items":{"type":"string"},"description":"EXPORT, CHANGING and TABLES parameters or field paths to return, e.g. [\"ADDRESS.CITY\", \"ITEMS.MATNR\"]; other parameters and fields are omitted (default: all)"},` +
+	`"filters":{"type":"array","description":"Keep only the rows of a table parameter matching all its conditions","items":{"type":"object","properties":{"table":{"type":"string"},"field":{"type":"string"},"op":{"type":"string","enum":["eq","ne","lt","le","gt","ge","like","not_like","in","between"]},"value":{"description":"Comparison value; an array for 'in', two elements for 'between'. Use % and _ as wildcards with 'like'"}},"required":["table","field","op","value"]}},` +
+	`"max_rows":{"type":"integer","minimum":0,"description":"Maximum rows returned per table parameter (default: all)"}`
+
+// projection is the tree of selected fields below a parameter or field; nil
+// selects the whole value.
+type projection map[string]projection
+
+func (p projection) add(path []string) {
+	sub, seen := p[path[0]]
+	if seen && sub == nil {
+		return
+	}
+	if len(path) == 1 {
+		p[path[0]] = nil
+		return
+	}
+	if !seen {
+		sub = projection{}
+		p[path[0]] = sub
+	}
+	sub.add(path[1:])
+}
+
+// check validates v against the interface of the called function, so that
+// mistakes are reported before SAP is called.
+func (v *resultView) check(desc gorfc.FunctionDescription) error {
+	if v == nil {
+		return nil
+	}
+	if v.MaxRows < 0 {
+		return fmt.Errorf("max_rows must not be negative")
+	}
+	if _, err := v.projection(desc); err != nil {
+		return err
+	}
+	for i, f := range v.Filters {
+		if _, _, err := f.resolve(desc); err != nil {
+			return fmt.Errorf("filter %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// projection builds the tree of v.Fields, or returns nil if all parameters
+// are selected.
+func (v *resultView) projection(desc gorfc.FunctionDescription) (projection, error) {
+	if len(v.Fields) == 0 {
+		return nil, nil
+	}
+	params := outputParams(desc)
+	proj := projection{}
+	for _, path := range v.Fields {
+		parts := strings.Split(strings.ToUpper(strings.TrimSpace(path)), ".")
+		p, ok := params[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("fields: %s has no EXPORT, CHANGING or TABLES parameter %q", desc.Name, parts[0])
+		}
+		rfcType, td := p.ParameterType, p.TypeDesc
+		for i, name := range parts[1:] {
+			if rfcType != "RFCTYPE_STRUCTURE" && rfcType != "RFCTYPE_TABLE" {
+				return nil, fmt.Errorf("fields: %s is not a structure or table", strings.Join(parts[:i+1], "."))
+			}
+			fd, ok := typeField(td, name)
+			if !ok {
+				return nil, fmt.Errorf("fields: %s has no field %q", strings.Join(parts[:i+1], "."), name)
+			}
+			rfcType, td = fd.FieldType, fd.TypeDesc
+		}
+		proj.add(parts)
+	}
+	return proj, nil
+}
+
+// resolve returns the table parameter and the field f refers to.
+func (f rowFilter) resolve(desc gorfc.FunctionDescription) (gorfc.ParameterDescription, gorfc.FieldDescription, error) {
+	p, ok := outputParams(desc)[strings.ToUpper(strings.TrimSpace(f.Table))]
+	if !ok || p.ParameterType != "RFCTYPE_TABLE" {
+		return p, gorfc.FieldDescription{}, fmt.Errorf("%s has no table parameter %q", desc.Name, f.Table)
+	}
+	fd, ok := typeField(p.TypeDesc, strings.ToUpper(strings.TrimSpace(f.Field)))
+	if !ok {
+		return p, fd, fmt.Errorf("table %s has no field %q", p.Name, f.Field)
+	}
+	op, ok := filterOps[strings.ToLower(strings.TrimSpace(f.Op))]
+	if !ok {
+		return p, fd, fmt.Errorf("unsupported operator %q", f.Op)
+	}
+	if op == "IN" || op == "BETWEEN" {
+		vals, ok := f.Value.([]interface{})
+		if !ok || len(vals) == 0 || (op == "BETWEEN" && len(vals) != 2) {
+			return p, fd, fmt.Errorf("%s needs an array value (two elements for BETWEEN)", op)
+		}
+	}
+	return p, fd, nil
+}
+
+// outputParams returns the EXPORT, CHANGING and TABLES parameters of desc by
+// name.
+func outputParams(desc gorfc.FunctionDescription) map[string]gorfc.ParameterDescription {
+	out := make(map[string]gorfc.ParameterDescription, len(desc.Parameters))
+	for _, p := range desc.Parameters {
+		if p.Direction != "RFC_IMPORT" {
+			out[p.Name] = p
+		}
+	}
+	return out
+}
+
+func typeField(td gorfc.TypeDescription, name string) (gorfc.FieldDescription, bool) {
+	for _, fd := range td.Fields {
+		if fd.Name == name {
+			return fd, true
+		}
+	}
+	return gorfc.FieldDescription{}, false
+}
+
+// apply returns the part of result that v selects and a note on what was
+// omitted, or "" if nothing was. v must have passed check.
+func (v *resultView) apply(result map[string]interface{}, desc gorfc.FunctionDescription) (map[string]interface{}, string) {
+	if v == nil {
+		return result, ""
+	}
+	proj, _ := v.projection(desc)
+	filters := map[string][]rowFilter{}
+	for _, f := range v.Filters {
+		p, _, _ := f.resolve(desc)
+		filters[p.Name] = append(filters[p.Name], f)
+	}
+
+	out := make(map[string]interface{}, len(result))
+	var dropped, notes []string
+	for _, p := range desc.Parameters {
+		val, ok := result[p.Name]
+		if !ok || p.Direction == "RFC_IMPORT" {
+			continue
+		}
+		sub, selected := proj[p.Name]
+		if proj != nil && !selected {
+			dropped = append(dropped, p.Name)
+			continue
+		}
+		if rows, ok := val.([]interface{}); ok && p.ParameterType == "RFCTYPE_TABLE" {
+			kept, note := v.rows(rows, p.TypeDesc, filters[p.Name])
+			if note != "" {
+				notes = append(notes, p.Name+": "+note)
+			}
+			val = kept
+		}
+		out[p.Name] = project(val, p.ParameterType, p.TypeDesc, sub)
+	}
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		notes = append([]string{"parameters not in fields: " + strings.Join(dropped, ", ")}, notes...)
+	}
+	if len(notes) == 0 {
+		return out, ""
+	}
+	return out, "Omitted from the result:\n" + strings.Join(notes, "\n")
+}
+
+// rows applies filters and MaxRows to the rows of a table and describes
+// what was left out.
+func (v *resultView) rows(rows []interface{}, td gorfc.TypeDescription, filters []rowFilter) ([]interface{}, string) {
+	kept := rows
+	if len(filters) > 0 {
+		kept = make([]interface{}, 0, len(rows))
+		for _, r := range rows {
+			row, _ := r.(map[string]interface{})
+			if matchRow(row, td, filters) {
+				kept = append(kept, r)
+			}
+		}
+	}
+	unmatched, truncated := len(rows)-len(kept), 0
+	if v.MaxRows > 0 && len(kept) > v.MaxRows {
+		truncated = len(kept) - v.MaxRows
+		kept = kept[:v.MaxRows]
+	}
+	if unmatched == 0 && truncated == 0 {
+		return kept, ""
+	}
+	var why []string
+	if unmatched > 0 {
+		why = append(why, fmt.Sprintf("%d not matching the filters", unmatched))
+	}
+	if truncated > 0 {
+		why = append(why, fmt.Sprintf("%d beyond max_rows", truncated))
+	}
+	return kept, fmt.Sprintf("%d of %d rows returned (%s)", len(kept), len(rows), strings.Join(why, ", "))
+}
+
+// project keeps the fields of val selected by p.
+func project(val interface{}, rfcType string, td gorfc.TypeDescription, p projection) interface{} {
+	if p == nil {
+		return val
+	}
+	switch rfcType {
+	case "RFCTYPE_STRUCTURE":
+		row, ok := val.(map[string]interface{})
+		if !ok {
+			return val
+		}
+		out := make(map[string]interface{}, len(p))
+		for _, fd := range td.Fields {
+			sub, selected := p[fd.Name]
+			if v, ok := row[fd.Name]; ok && selected {
+				out[fd.Name] = project(v, fd.FieldType, fd.TypeDesc, sub)
+			}
+		}
+		return out
+	case "RFCTYPE_TABLE":
+		rows, ok := val.([]interface{})
+		if !ok {
+			return val
+		}
+		out := make([]interface{}, len(rows))
+		for i, row := range rows {
+			out[i] = project(row, "RFCTYPE_STRUCTURE", td, p)
+		}
+		return out
+	}
+	return val
+}
+
+// matchRow reports whether row satisfies all filters.
+func matchRow(row map[string]interface{}, td gorfc.TypeDescription, filters []rowFilter) bool {
+	for _, f := range filters {
+		fd, _ := typeField(td, strings.ToUpper(strings.TrimSpace(f.Field)))
+		got := compareString(row[fd.Name], fd.FieldType)
+		want := func(v interface{}) string { return filterString(v, fd.FieldType) }
+		var ok bool
+		switch op := filterOps[strings.ToLower(strings.TrimSpace(f.Op))]; op {
+		case "LIKE", "NOT LIKE":
+			ok = likePattern(want(f.Value)).MatchString(got) == (op == "LIKE")
+		case "IN":
+			for _, v := range f.Value.([]interface{}) {
+				if compareRFC(got, want(v), fd.FieldType) == 0 {
+					ok = true
+					break
+				}
+			}
+		case "BETWEEN":
+			vals := f.Value.([]interface{})
+			ok = compareRFC(got, want(vals[0]), fd.FieldType) >= 0 && compareRFC(got, want(vals[1]), fd.FieldType) <= 0
+		default:
+			c := compareRFC(got, want(f.Value), fd.FieldType)
+			ok = map[string]bool{"=": c == 0, "<>": c != 0, "<": c < 0, "<=": c <= 0, ">": c > 0, ">=": c >= 0}[op]
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareString renders a value of a function result for comparisons:
+// dates as YYYYMMDD, times as HHMMSS, numbers and text as written.
+func compareString(val interface{}, rfcType string) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if rfcType == "RFCTYPE_TIME" {
+			return v.Format("150405")
+		}
+		return v.Format("20060102")
+	case string:
+		return abapSign(strings.TrimSpace(v))
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(val)
+}
+
+// filterString renders a filter value like compareString, accepting dates
+// and times in ISO 8601 notation.
+func filterString(val interface{}, rfcType string) string {
+	s := compareString(val, rfcType)
+	switch rfcType {
+	case "RFCTYPE_DATE":
+		return strings.ReplaceAll(s, "-", "")
+	case "RFCTYPE_TIME":
+		return strings.ReplaceAll(s, ":", "")
+	}
+	return s
+}
+
+// compareRFC compares a and b numerically for numeric RFC types and as
+// text otherwise.
+func compareRFC(a, b, rfcType string) int {
+	switch rfcType {
+	case "RFCTYPE_INT", "RFCTYPE_INT1", "RFCTYPE_INT2", "RFCTYPE_INT8", "RFCTYPE_FLOAT", "RFCTYPE_BCD", "RFCTYPE_DECF16", "RFCTYPE_DECF34", "RFCTYPE_NUM":
+		x, okA := new(big.Rat).SetString(a)
+		y, okB := new(big.Rat).SetString(b)
+		if okA && okB {
+			return x.Cmp(y)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// likePattern translates an SQL LIKE pattern with % and _ into an anchored
+// regular expression.
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Prometheus metrics ───────────────────────────────────────────────────────
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// histogram counts observations per latencyBuckets bucket. It is guarded by
+// the mutex of its metrics.
+type histogram struct {
+	counts []int64 // per bucket, not cumulative
+	count  int64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	h.count++
+	h.sum += s
+	if i := sort.SearchFloat64s(latencyBuckets, s); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+}
+
+// errorClass returns the class of err for error counts: bapi_error for
+// error or abort messages in a BAPI's RETURN, else the class reported to
+// clients.
+func errorClass(err error) string {
+	var be *bapiError
+	if errors.As(err, &be) {
+		return auditBAPIError
+	}
+	return classifyError(err).Class
+}
+
+// toolErrorClass returns the error class of a tool result, or "" if the call
+// succeeded.
+func toolErrorClass(res *mcp.CallToolResult, err error) string {
+	switch {
+	case err != nil:
+		return errorClass(err)
+	case res == nil || !res.IsError:
+		return ""
+	}
+	if info, ok := res.StructuredContent.(rfcErrorInfo); ok {
+		return info.Class
+	}
+	return auditBAPIError
+}
+
+// wrap records the latency, errors and result size of every call of the tool
+// name, labelled with the SAP system the call used.
+func (m *metrics) wrap(name string, h mcp.ToolHandler) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, call := withAuditCall(ctx)
+		t0 := time.Now()
+		res, err := h(ctx, req)
+		call.mu.Lock()
+		system := call.system
+		call.mu.Unlock()
+		m.recordTool(name, system, time.Since(t0), toolErrorClass(res, err), resultBytes(res))
+		return res, err
+	}
+}
+
+func (m *metrics) recordTool(tool, system string, dur time.Duration, class string, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{tool, system}
+	if m.toolLatency[key] == nil {
+		m.toolLatency[key] = newHistogram()
+	}
+	m.toolLatency[key].observe(dur)
+	if class != "" {
+		m.toolErrs[[3]string{tool, system, class}]++
+	}
+	m.toolBytes[key] += int64(bytes)
+}
+
+// serveMetrics serves the Prometheus endpoint /metrics on addr until ctx is
+// done. It is not authenticated; bind it to an address only the monitoring
+// system can reach.
+func serveMetrics(ctx context.Context, addr string, m *metrics, systems *systemRegistry) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := m.writePrometheus(w, systems); err != nil {
+			logger.Error("metrics endpoint failed", "err", err)
+		}
+	})
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("metrics endpoint failed", "err", err)
+	}
+}
+
+// writePrometheus writes the metrics of m and the connection pools of
+// systems in the Prometheus text format.
+func (m *metrics) writePrometheus(w io.Writer, systems *systemRegistry) error {
+	p := &promWriter{w: bufio.NewWriter(w)}
+
+	m.mu.Lock()
+	p.histograms("gorfc_mcp_tool_duration_seconds", "Duration of MCP tool calls.", []string{"tool", "system"}, m.toolLatency)
+	p.counters("gorfc_mcp_tool_errors_total", "MCP tool calls that failed, by error class.", []string{"tool", "system", "class"}, m.toolErrs)
+	p.counters("gorfc_mcp_tool_result_bytes_total", "Bytes of text returned by MCP tool calls.", []string{"tool", "system"}, m.toolBytes)
+	p.histograms("gorfc_mcp_rfc_duration_seconds", "Duration of function module calls and other SAP requests.", []string{"system", "function"}, m.latency)
+	p.counters("gorfc_mcp_rfc_errors_total", "Function module calls and other SAP requests that failed, by error class.", []string{"system", "function", "class"}, m.errs)
+	bapi := make(map[[1]string]int64, len(m.bapi))
+	for k, v := range m.bapi {
+		bapi[[1]string{k}] = v
+	}
+	m.mu.Unlock()
+	p.counters("gorfc_mcp_bapi_messages_total", "BAPI return messages, by message type.", []string{"type"}, bapi)
+
+	connected := map[[1]string]int64{}
+	reconnects := map[[1]string]int64{}
+	conns := map[[2]string]int64{}
+	maxSize, created, retired, waits := map[[1]string]int64{}, map[[1]string]int64{}, map[[1]string]int64{}, map[[1]string]int64{}
+	waitSecs := map[[1]string]float64{}
+	for _, key := range systems.order {
+		s := systems.systems[key]
+		name := [1]string{s.cfg.Name}
+		s.mu.Lock()
+		cm := s.cm
+		s.mu.Unlock()
+		if cm == nil {
+			connected[name] = 0
+			continue
+		}
+		connected[name] = 1
+		reconnects[name] = cm.reconnects.Load()
+		pool := cm.pool
+		pool.mu.Lock()
+		conns[[2]string{s.cfg.Name, "idle"}] = int64(len(pool.idle))
+		conns[[2]string{s.cfg.Name, "in_use"}] = int64(pool.inUse)
+		maxSize[name] = int64(pool.cfg.MaxSize)
+		created[name], retired[name], waits[name] = pool.created, pool.retired, pool.waits
+		waitSecs[name] = pool.waitDur.Seconds()
+		pool.mu.Unlock()
+	}
+	p.gauges("gorfc_mcp_system_connected", "Whether the connection pool of the SAP system is open.", []string{"system"}, connected)
+	p.counters("gorfc_mcp_reconnects_total", "Retries on a new connection after communication failures.", []string{"system"}, reconnects)
+	p.gauges("gorfc_mcp_pool_connections", "Open RFC connections, by state.", []string{"system", "state"}, conns)
+	p.gauges("gorfc_mcp_pool_max_connections", "Maximum size of the connection pool.", []string{"system"}, maxSize)
+	p.counters("gorfc_mcp_pool_connections_created_total", "RFC connections opened.", []string{"system"}, created)
+	p.counters("gorfc_mcp_pool_connections_retired_total", "RFC connections closed.", []string{"system"}, retired)
+	p.counters("gorfc_mcp_pool_waits_total", "Requests that waited for a free connection.", []string{"system"}, waits)
+	p.counters("gorfc_mcp_pool_wait_seconds_total", "Time spent waiting for a free connection.", []string{"system"}, waitSecs)
+	return p.w.Flush()
+}
+
+// promWriter writes metric families in the Prometheus text format, with
+// samples sorted by labels so that scrapes are stable.
+type promWriter struct {
+	w *bufio.Writer
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name string, names, values []string, v float64) {
+	p.w.WriteString(name)
+	if len(names) > 0 {
+		p.w.WriteByte('{')
+		for i, n := range names {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			p.w.WriteString(n + `="` + promEscape(values[i]) + `"`)
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteString(" " + promFloat(v) + "\n")
+}
+
+func (p *promWriter) counters(name, help string, labels []string, samples interface{}) {
+	p.header(name, "counter", help)
+	p.samples(name, labels, samples)
+}
+
+func (p *promWriter) gauges(name, help string, labels []string, samples interface{}) {
+	p.header(name, "gauge", help)
+	p.samples(name, labels, samples)
+}
+
+// samples writes the entries of a map from label values ([n]string) to an
+// int64 or float64.
+func (p *promWriter) samples(name string, labels []string, samples interface{}) {
+	type entry struct {
+		values []string
+		v      float64
+	}
+	var entries []entry
+	switch s := samples.(type) {
+	case map[[1]string]int64:
+		for k, v := range s {
+			entries = append(entries, entry{[]string{k[0]}, float64(v)})
+		}
+	case map[[1]string]float64:
+		for k, v := range s {
+			entries = append(entries, entry{[]string{k[0]}, v})
+		}
+	case map[[2]string]int64:
+		for k, v := range s {
+			entries = append(entries, entry{k[:], float64(v)})
+		}
+	case map[[3]string]int64:
+		for k, v := range s {
+			entries = append(entries, entry{k[:], float64(v)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return labelsLess(entries[i].values, entries[j].values) })
+	for _, e := range entries {
+		p.sample(name, labels, e.values, e.v)
+	}
+}
+
+func (p *promWriter) histograms(name, help string, labels []string, hs map[[2]string]*histogram) {
+	p.header(name, "histogram", help)
+	keys := make([][2]string, 0, len(hs))
+	for k := range hs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return labelsLess(keys[i][:], keys[j][:]) })
+	withLE := append(labels[:len(labels):len(labels)], "le")
+	for _, k := range keys {
+		h := hs[k]
+		var cum int64
+		for i, le := range latencyBuckets {
+			cum += h.counts[i]
+			p.sample(name+"_bucket", withLE, []string{k[0], k[1], promFloat(le)}, float64(cum))
+		}
+		p.sample(name+"_bucket", withLE, []string{k[0], k[1], "+Inf"}, float64(h.count))
+		p.sample(name+"_sum", labels, k[:], h.sum)
+		p.sample(name+"_count", labels, k[:], float64(h.count))
+	}
+}
+
+func labelsLess(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(s string) string { return promEscaper.Replace(s) }
+
+func promFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
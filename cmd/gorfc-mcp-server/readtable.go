@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Table reading ────────────────────────────────────────────────────────────
+
+const (
+	// optionsLineWidth is the width of RFC_READ_TABLE's OPTIONS-TEXT column.
+	optionsLineWidth = 72
+	// readTablePageSize is the number of rows fetched per table reader call.
+	readTablePageSize = 500
+	// readTableMaxRows caps the rows returned by a single read_table call.
+	readTableMaxRows = 10000
+	// readTableKeyBatch is the number of rows selected by key per table
+	// reader call for the further column chunks of a wide read.
+	readTableKeyBatch = 100
+	// readTableRowWidth is the width of RFC_READ_TABLE's DATA rows (TAB512);
+	// wider field selections fail with DATA_BUFFER_EXCEEDED. Other table
+	// readers report their own width.
+	readTableRowWidth = 512
+)
+
+// ddicField is the subset of a DDIC field description (DFIES) needed to build
+// table reads and convert their results.
+type ddicField struct {
+	Name      string `json:"name"`
+	IntType   string `json:"type"` // ABAP internal type: C, N, D, T, P, I, F, X, ...
+	Length    int    `json:"length"`
+	OutputLen int    `json:"-"`
+	Decimals  int    `json:"decimals,omitempty"`
+	Key       bool   `json:"key,omitempty"`
+	ConvExit  string `json:"conv_exit,omitempty"` // conversion exit, e.g. ALPHA
+	Text      string `json:"description,omitempty"`
+}
+
+// readWidth is the number of characters f occupies in an RFC_READ_TABLE DATA
+// row. Non-character types are written in their output format, so the larger
+// of the internal and the output length is used.
+func (f ddicField) readWidth() int {
+	if f.OutputLen > f.Length {
+		return f.OutputLen
+	}
+	return f.Length
+}
+
+// tableFields fetches the field list of table via DDIF_FIELDINFO_GET.
+func tableFields(ctx context.Context, cm *connManager, table string) ([]ddicField, error) {
+	result, err := cm.fieldInfo(ctx, table, "")
+	if err != nil {
+		return nil, fmt.Errorf("field info for %s: %w", table, err)
+	}
+	fields := parseDFIES(result)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("table %s has no fields or does not exist", table)
+	}
+	return fields, nil
+}
+
+// parseDFIES extracts the DFIES_TAB rows of a DDIF_FIELDINFO_GET result.
+func parseDFIES(result map[string]interface{}) []ddicField {
+	rows, _ := result["DFIES_TAB"].([]interface{})
+	out := make([]ddicField, 0, len(rows))
+	for _, raw := range rows {
+		row, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		f := ddicField{
+			Name:      strings.TrimSpace(fmt.Sprint(row["FIELDNAME"])),
+			IntType:   strings.TrimSpace(fmt.Sprint(row["INTTYPE"])),
+			Length:    atoiLoose(row["LENG"]),
+			OutputLen: atoiLoose(row["OUTPUTLEN"]),
+			Decimals:  atoiLoose(row["DECIMALS"]),
+			Key:       strings.TrimSpace(fmt.Sprint(row["KEYFLAG"])) == "X",
+		}
+		if exit, ok := row["CONVEXIT"].(string); ok {
+			f.ConvExit = strings.TrimSpace(exit)
+		}
+		if text, ok := row["FIELDTEXT"].(string); ok {
+			f.Text = strings.TrimSpace(text)
+		}
+		if f.Name == "" || strings.HasPrefix(f.Name, ".") {
+			continue // .INCLUDE / .APPEND markers
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// atoiLoose converts a NUMC string or integer from an RFC result to int.
+func atoiLoose(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(strings.TrimSpace(n))
+		return i
+	}
+	return 0
+}
+
+// tableFilter is one condition of a read_table WHERE clause. Conditions are
+// combined with AND.
+type tableFilter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+var filterOps = map[string]string{
+	"eq": "=", "=": "=",
+	"ne": "<>", "<>": "<>", "!=": "<>",
+	"lt": "<", "<": "<",
+	"le": "<=", "<=": "<=",
+	"gt": ">", ">": ">",
+	"ge": ">=", ">=": ">=",
+	"like":     "LIKE",
+	"not_like": "NOT LIKE",
+	"in":       "IN",
+	"between":  "BETWEEN",
+}
+
+// abapLiteral renders v as a quoted ABAP character literal.
+func abapLiteral(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return "'" + sanitizeABAPString(x) + "'", nil
+	case float64:
+		return "'" + strconv.FormatFloat(x, 'f', -1, 64) + "'", nil
+	case json.Number:
+		return "'" + x.String() + "'", nil
+	case bool, nil:
+		return "", fmt.Errorf("unsupported filter value %v", v)
+	}
+	return "'" + sanitizeABAPString(fmt.Sprint(v)) + "'", nil
+}
+
+// whereTokens renders filters as WHERE clause tokens. Field names are checked
+// against known so that no free text reaches the dynamic WHERE clause.
+func whereTokens(filters []tableFilter, known map[string]ddicField) ([]string, error) {
+	var tokens []string
+	for i, f := range filters {
+		name := strings.ToUpper(strings.TrimSpace(f.Field))
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("filter %d: unknown field %q", i, f.Field)
+		}
+		op, ok := filterOps[strings.ToLower(strings.TrimSpace(f.Op))]
+		if !ok {
+			return nil, fmt.Errorf("filter %d: unsupported operator %q", i, f.Op)
+		}
+		if i > 0 {
+			tokens = append(tokens, "AND")
+		}
+		tokens = append(tokens, name, op)
+
+		switch op {
+		case "IN", "BETWEEN":
+			vals, ok := f.Value.([]interface{})
+			if !ok || len(vals) == 0 || (op == "BETWEEN" && len(vals) != 2) {
+				return nil, fmt.Errorf("filter %d: %s needs an array value (two elements for BETWEEN)", i, op)
+			}
+			lits := make([]string, len(vals))
+			for j, v := range vals {
+				lit, err := abapLiteral(v)
+				if err != nil {
+					return nil, fmt.Errorf("filter %d: %w", i, err)
+				}
+				lits[j] = lit
+			}
+			if op == "BETWEEN" {
+				tokens = append(tokens, lits[0], "AND", lits[1])
+				continue
+			}
+			tokens = append(tokens, "(")
+			for j, lit := range lits {
+				if j > 0 {
+					tokens = append(tokens, ",")
+				}
+				tokens = append(tokens, lit)
+			}
+			tokens = append(tokens, ")")
+		default:
+			lit, err := abapLiteral(f.Value)
+			if err != nil {
+				return nil, fmt.Errorf("filter %d: %w", i, err)
+			}
+			tokens = append(tokens, lit)
+		}
+	}
+	return tokens, nil
+}
+
+// optionsLines packs WHERE tokens into RFC_READ_TABLE OPTIONS rows of at most
+// optionsLineWidth characters. Tokens are never split, because a literal may
+// not span two OPTIONS lines.
+func optionsLines(tokens []string) ([]interface{}, error) {
+	var rows []interface{}
+	var line strings.Builder
+	for _, tok := range tokens {
+		if len(tok) > optionsLineWidth {
+			return nil, fmt.Errorf("condition value %s exceeds the %d-character OPTIONS line limit", tok, optionsLineWidth)
+		}
+		if line.Len() > 0 && line.Len()+1+len(tok) > optionsLineWidth {
+			rows = append(rows, map[string]interface{}{"TEXT": line.String()})
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(tok)
+	}
+	if line.Len() > 0 {
+		rows = append(rows, map[string]interface{}{"TEXT": line.String()})
+	}
+	return rows, nil
+}
+
+// convertDDICValue converts a trimmed RFC_READ_TABLE cell to the JSON value
+// matching its DDIC type. Values that do not parse are returned unchanged.
+func convertDDICValue(raw string, f ddicField) interface{} {
+	switch f.IntType {
+	case "I", "b", "s", "8":
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return n
+		}
+	case "P", "a", "e":
+		// Packed numbers are written with a trailing minus sign.
+		s := raw
+		if strings.HasSuffix(s, "-") {
+			s = "-" + strings.TrimSuffix(s, "-")
+		}
+		if s == "" {
+			return json.Number("0")
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(s)
+		}
+	case "F":
+		if x, err := strconv.ParseFloat(raw, 64); err == nil {
+			return x
+		}
+	case "D":
+		if raw == "" || raw == "00000000" {
+			return nil
+		}
+		if len(raw) == 8 {
+			return raw[0:4] + "-" + raw[4:6] + "-" + raw[6:8]
+		}
+	case "T":
+		if len(raw) == 6 {
+			return raw[0:2] + ":" + raw[2:4] + ":" + raw[4:6]
+		}
+	}
+	return raw
+}
+
+// orderRows sorts rows by the given "FIELD [ASC|DESC]" specs.
+func orderRows(rows []map[string]interface{}, specs []string, known map[string]ddicField) error {
+	type key struct {
+		name string
+		desc bool
+	}
+	var keys []key
+	for _, spec := range specs {
+		parts := strings.Fields(strings.ToUpper(spec))
+		if len(parts) == 0 || len(parts) > 2 {
+			return fmt.Errorf("invalid order_by entry %q", spec)
+		}
+		if _, ok := known[parts[0]]; !ok {
+			return fmt.Errorf("order_by: unknown field %q", parts[0])
+		}
+		k := key{name: parts[0]}
+		if len(parts) == 2 {
+			switch parts[1] {
+			case "DESC", "DESCENDING":
+				k.desc = true
+			case "ASC", "ASCENDING":
+			default:
+				return fmt.Errorf("invalid order_by direction %q", parts[1])
+			}
+		}
+		keys = append(keys, k)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareValues(rows[i][k.name], rows[j][k.name])
+			if c == 0 {
+				continue
+			}
+			if k.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// compareValues orders converted cell values; nil sorts first.
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	af, aNum := numericValue(a)
+	bf, bNum := numericValue(b)
+	if aNum && bNum {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// readTableRequest holds the arguments of the read_table tool.
+type readTableRequest struct {
+	Table   string
+	Fields  []string
+	Filters []tableFilter
+	OrderBy []string
+	Skip    int
+	Limit   int
+}
+
+// readTableResult is returned by the read_table tool.
+type readTableResult struct {
+	Table    string                   `json:"table"`
+	Reader   string                   `json:"reader"` // function module used
+	Fields   []ddicField              `json:"fields"`
+	Rows     []map[string]interface{} `json:"rows"`
+	RowCount int                      `json:"row_count"`
+	Skip     int                      `json:"skip"`
+	HasMore  bool                     `json:"has_more"`
+	NextSkip int                      `json:"next_skip,omitempty"`
+	// ColumnChunks is the number of column chunks the selected fields were
+	// split into because they exceed the reader's row width.
+	ColumnChunks int `json:"column_chunks,omitempty"`
+}
+
+// readTable reads rows of a table via the system's table reader. It fetches
+// the field metadata first, builds the OPTIONS lines from structured filters,
+// pages through the result with ROWSKIPS/ROWCOUNT, and converts each value to
+// its DDIC type. If the fields are split into column chunks, the first chunk
+// selects the page and the others are read by the keys of its rows, since
+// the reader has no ORDER BY that would make their pages line up.
+func readTable(ctx context.Context, cm *connManager, req readTableRequest) (*readTableResult, error) {
+	table := strings.ToUpper(req.Table)
+	all, err := tableFields(ctx, cm, table)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]ddicField, len(all))
+	for _, f := range all {
+		known[f.Name] = f
+	}
+
+	selected := all
+	if len(req.Fields) > 0 {
+		selected = make([]ddicField, 0, len(req.Fields))
+		for _, name := range req.Fields {
+			f, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+			if !ok {
+				return nil, fmt.Errorf("table %s has no field %q", table, name)
+			}
+			selected = append(selected, f)
+		}
+	}
+
+	// Filtering or sorting on a hidden field would reveal its values by
+	// bisection.
+	for _, f := range req.Filters {
+		if name := strings.ToUpper(strings.TrimSpace(f.Field)); cm.redact.hides(table, name) {
+			return nil, fmt.Errorf("field %s of table %s is redacted and cannot be filtered on", name, table)
+		}
+	}
+	for _, spec := range req.OrderBy {
+		if parts := strings.Fields(strings.ToUpper(spec)); len(parts) > 0 && cm.redact.hides(table, parts[0]) {
+			return nil, fmt.Errorf("field %s of table %s is redacted and cannot be sorted on", parts[0], table)
+		}
+	}
+
+	tokens, err := whereTokens(req.Filters, known)
+	if err != nil {
+		return nil, err
+	}
+	options, err := optionsLines(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := cm.tableReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := splitColumns(selected, all, reader.rowWidth())
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", table, err)
+	}
+
+	// Fetch one row beyond the limit to learn whether more rows exist.
+	want := req.Limit + 1
+	raw, err := fetchRows(ctx, cm, reader, table, chunks[0], options, req.Skip, want)
+	if err != nil {
+		return nil, err
+	}
+	out := &readTableResult{Table: table, Reader: reader.name(), Fields: selected, Skip: req.Skip}
+	if len(raw) > req.Limit {
+		raw = raw[:req.Limit]
+		out.HasMore = true
+		out.NextSkip = req.Skip + req.Limit
+	}
+	if len(chunks) > 1 {
+		out.ColumnChunks = len(chunks)
+		if err := joinChunks(ctx, cm, reader, table, chunks[1:], keyFields(all), raw); err != nil {
+			return nil, err
+		}
+	}
+	out.Rows = make([]map[string]interface{}, len(raw))
+	for i, r := range raw {
+		row := make(map[string]interface{}, len(selected))
+		for _, f := range selected {
+			if v, ok := r[f.Name]; ok {
+				row[f.Name] = convertDDICValue(v, f)
+			} else {
+				row[f.Name] = nil
+			}
+		}
+		out.Rows[i] = row
+	}
+	out.RowCount = len(out.Rows)
+	if len(req.OrderBy) > 0 {
+		if err := orderRows(out.Rows, req.OrderBy, known); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// checkTableReaderCall refuses an rfc_call or function tool call of a table
+// reader, i.e. a function module with a QUERY_TABLE parameter, if the
+// redaction rules hide a field of the table it reads. Such a reader returns
+// each row as one packed string, which the rules cannot be applied to, and
+// its OPTIONS could filter on the hidden field; read_table applies them.
+func checkTableReaderCall(ctx context.Context, cm *connManager, funcName string, desc gorfc.FunctionDescription, params map[string]interface{}) error {
+	if cm.redact == nil {
+		return nil
+	}
+	reader := false
+	for _, p := range desc.Parameters {
+		reader = reader || p.Name == "QUERY_TABLE"
+	}
+	if !reader {
+		return nil
+	}
+	var table string
+	for k, v := range params {
+		if strings.EqualFold(k, "QUERY_TABLE") {
+			table = strings.ToUpper(strings.TrimSpace(fmt.Sprint(v)))
+		}
+	}
+	if table == "" {
+		return nil
+	}
+	fields, err := tableFields(ctx, cm, table)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if cm.redact.hides(table, f.Name) {
+			return &policyError{Function: funcName, Reason: fmt.Sprintf("field %s of table %s is redacted; read the table with read_table", f.Name, table)}
+		}
+	}
+	return nil
+}
+
+// fetchRows pages through the table reader for one set of fields until want
+// rows were read or the table is exhausted.
+func fetchRows(ctx context.Context, cm *connManager, reader tableReader, table string, fields []ddicField, options []interface{}, skip, want int) ([]map[string]string, error) {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	var raw []map[string]string
+	for len(raw) < want {
+		page := want - len(raw)
+		if page > readTablePageSize {
+			page = readTablePageSize
+		}
+		rows, err := reader.read(ctx, cm, tableQuery{
+			Table:   table,
+			Fields:  names,
+			Options: options,
+			Skip:    skip + len(raw),
+			Count:   page,
+		})
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, rows...)
+		if len(rows) < page {
+			break
+		}
+	}
+	return raw, nil
+}
+
+// joinChunks reads the fields of chunks for the rows of the first chunk,
+// selecting them by their keys, and adds them to the rows. It fails if a row
+// is missing or appears in between, i.e. the table changed during the read.
+func joinChunks(ctx context.Context, cm *connManager, reader tableReader, table string, chunks [][]ddicField, keys []ddicField, rows []map[string]string) error {
+	byKey := make(map[string]map[string]string, len(rows))
+	for _, r := range rows {
+		byKey[rowKey(r, keys)] = r
+	}
+	for start := 0; start < len(rows); start += readTableKeyBatch {
+		batch := rows[start:min(start+readTableKeyBatch, len(rows))]
+		options, err := optionsLines(keyTokens(keys, batch))
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		for _, chunk := range chunks {
+			part, err := fetchRows(ctx, cm, reader, table, chunk, options, 0, len(batch)+1)
+			if err != nil {
+				return err
+			}
+			found := 0
+			for _, r := range part {
+				dst, ok := byKey[rowKey(r, keys)]
+				if !ok {
+					continue
+				}
+				found++
+				for k, v := range r {
+					dst[k] = v
+				}
+			}
+			if found != len(batch) || len(part) != len(batch) {
+				return fmt.Errorf("table %s changed while its column chunks were read (%d of %d rows found again); read the page again", table, found, len(batch))
+			}
+		}
+	}
+	return nil
+}
+
+// keyTokens renders WHERE clause tokens selecting rows by their key values.
+func keyTokens(keys []ddicField, rows []map[string]string) []string {
+	var tokens []string
+	if len(keys) == 1 {
+		tokens = append(tokens, keys[0].Name, "IN", "(")
+		for i, r := range rows {
+			if i > 0 {
+				tokens = append(tokens, ",")
+			}
+			tokens = append(tokens, "'"+sanitizeABAPString(r[keys[0].Name])+"'")
+		}
+		return append(tokens, ")")
+	}
+	for i, r := range rows {
+		if i > 0 {
+			tokens = append(tokens, "OR")
+		}
+		tokens = append(tokens, "(")
+		for j, k := range keys {
+			if j > 0 {
+				tokens = append(tokens, "AND")
+			}
+			tokens = append(tokens, k.Name, "=", "'"+sanitizeABAPString(r[k.Name])+"'")
+		}
+		tokens = append(tokens, ")")
+	}
+	return tokens
+}
+
+func keyFields(all []ddicField) []ddicField {
+	var keys []ddicField
+	for _, f := range all {
+		if f.Key {
+			keys = append(keys, f)
+		}
+	}
+	return keys
+}
+
+// rowKey joins the key field values of a raw row.
+func rowKey(r map[string]string, keys []ddicField) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = r[k.Name]
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// splitColumns partitions selected into field lists that each fit into one
+// row of rowWidth characters. When more than one list is needed, every list
+// starts with the table's key fields so the partial rows can be joined again.
+func splitColumns(selected, all []ddicField, rowWidth int) ([][]ddicField, error) {
+	width := 0
+	for _, f := range selected {
+		width += f.readWidth()
+	}
+	if width <= rowWidth {
+		return [][]ddicField{selected}, nil
+	}
+
+	keys := keyFields(all)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("selected fields need %d characters per row (limit %d) and the table has no key fields to split on", width, rowWidth)
+	}
+	keyWidth := 0
+	isKey := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		keyWidth += k.readWidth()
+		isKey[k.Name] = true
+	}
+
+	var chunks [][]ddicField
+	var cur []ddicField
+	curWidth := keyWidth
+	for _, f := range selected {
+		if isKey[f.Name] {
+			continue
+		}
+		w := f.readWidth()
+		if keyWidth+w > rowWidth {
+			return nil, fmt.Errorf("field %s (%d characters) does not fit into a %d-character row next to the key fields", f.Name, w, rowWidth)
+		}
+		if curWidth+w > rowWidth {
+			chunks = append(chunks, append(append([]ddicField{}, keys...), cur...))
+			cur, curWidth = nil, keyWidth
+		}
+		cur = append(cur, f)
+		curWidth += w
+	}
+	if len(cur) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, append(append([]ddicField{}, keys...), cur...))
+	}
+	return chunks, nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Redaction ────────────────────────────────────────────────────────────────
+
+// Redaction actions.
+const (
+	redactMask = "mask" // replace the value with redactedValue
+	redactHash = "hash" // replace the value with a keyed hash, so equal values stay recognizable
+)
+
+// redactedValue replaces masked values.
+const redactedValue = "***"
+
+// redactionRule hides the values at the paths matching one of Fields, in the
+// results of the function modules or tables matching Scope.
+//
+// A path names a value by its parameter and fields, e.g. ADDRESS.CITY for
+// the field CITY of the structure ADDRESS, or ITEMS.MATNR for a field of
+// every row of the table ITEMS; read_table rows are named TABLE.FIELD. A
+// field pattern is matched against the last segments of a path, so BANKN
+// hides the field BANKN wherever it occurs, *.PASSWORD any field named
+// PASSWORD and PA0002.* every field of PA0002. A segment matches by name or
+// by the DDIC type of a structure or table. Patterns are matched
+// case-insensitively with the wildcards of the call policy.
+type redactionRule struct {
+	Scope  string   `json:"scope,omitempty"` // function module or table pattern; empty matches all
+	Fields []string `json:"fields"`
+	Action string   `json:"action,omitempty"` // redactMask (default) or redactHash
+}
+
+// redactionConfig is the redaction section of the config file.
+type redactionConfig struct {
+	Rules   []redactionRule `json:"rules,omitempty"`
+	HashKey string          `json:"hash_key,omitempty"` // may reference ${VAR}
+}
+
+// builtinRedaction hides credentials in every result, argument and log line.
+var builtinRedaction = redactionRule{Fields: []string{"*PASSWORD*", "*PASSWD*", "*PASSCODE*", "*SECRET*", "*TOKEN*"}}
+
+// redactionFromEnv combines the redaction section of the config file with
+// the environment.
+//
+//	MCP_REDACT           – additional field patterns to mask everywhere, comma-separated
+//	MCP_REDACT_HASH_KEY  – key of the hash action (default: random per start,
+//	                       so hashes are only comparable within one run)
+func redactionFromEnv(cfg *serverConfig) (*redactor, error) {
+	var rc redactionConfig
+	if cfg != nil && cfg.Redaction != nil {
+		rc = *cfg.Redaction
+		rc.HashKey = os.ExpandEnv(rc.HashKey)
+	}
+	if fields := splitList(os.Getenv("MCP_REDACT")); len(fields) > 0 {
+		rc.Rules = append(rc.Rules, redactionRule{Fields: fields})
+	}
+	if s := os.Getenv("MCP_REDACT_HASH_KEY"); s != "" {
+		rc.HashKey = s
+	}
+	r, err := newRedactor(rc)
+	if err != nil {
+		return nil, fmt.Errorf("redaction: %w", err)
+	}
+	return r, nil
+}
+
+// redactor applies redaction rules to function results, table rows and
+// tool arguments. It remembers the values it hid, so that they can also be
+// scrubbed from free text such as log lines and error messages. A nil
+// *redactor hides nothing.
+type redactor struct {
+	rules []compiledRedaction
+	key   []byte
+
+	mu    sync.Mutex
+	seen  map[string]string // hidden value -> replacement
+	order []string          // seen, oldest first
+}
+
+type compiledRedaction struct {
+	scope  string
+	fields [][]string // patterns split into segments
+	action string
+}
+
+// redactorMemory bounds the number of hidden values remembered for scrub;
+// values shorter than redactorMinScrub are not remembered, as they would
+// mangle unrelated text.
+const (
+	redactorMemory   = 1024
+	redactorMinScrub = 4
+)
+
+func newRedactor(cfg redactionConfig) (*redactor, error) {
+	r := &redactor{key: []byte(cfg.HashKey), seen: map[string]string{}}
+	if len(r.key) == 0 {
+		r.key = make([]byte, 32)
+		if _, err := rand.Read(r.key); err != nil {
+			return nil, err
+		}
+	}
+	for i, rule := range append([]redactionRule{builtinRedaction}, cfg.Rules...) {
+		c := compiledRedaction{scope: strings.ToUpper(rule.Scope), action: rule.Action}
+		switch c.action {
+		case "":
+			c.action = redactMask
+		case redactMask, redactHash:
+		default:
+			return nil, fmt.Errorf("rule %d: action must be %q or %q, got %q", i, redactMask, redactHash, rule.Action)
+		}
+		if len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("rule %d: fields are required", i)
+		}
+		for _, f := range rule.Fields {
+			f = strings.ToUpper(strings.TrimSpace(f))
+			if f == "" {
+				return nil, fmt.Errorf("rule %d: empty field pattern", i)
+			}
+			c.fields = append(c.fields, strings.Split(f, "."))
+		}
+		r.rules = append(r.rules, c)
+	}
+	return r, nil
+}
+
+// pathSegment is one step of the path to a value: a parameter or field name
+// and, for structures and tables, the DDIC type name.
+type pathSegment struct{ name, typeName string }
+
+// match returns the action of the first rule for scope matching path.
+func (r *redactor) match(scope string, path []pathSegment) (string, bool) {
+	for _, rule := range r.rules {
+		if rule.scope != "" && !wildcardMatch(rule.scope, scope) {
+			continue
+		}
+		for _, pat := range rule.fields {
+			if len(pat) > len(path) {
+				continue
+			}
+			tail := path[len(path)-len(pat):]
+			ok := true
+			for i, p := range pat {
+				if !wildcardMatch(p, tail[i].name) && (tail[i].typeName == "" || !wildcardMatch(p, tail[i].typeName)) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				return rule.action, true
+			}
+		}
+	}
+	return "", false
+}
+
+// hide returns the replacement of val and remembers the hidden strings.
+func (r *redactor) hide(val interface{}, action string) interface{} {
+	var plain string
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case string:
+		plain = v
+	default:
+		b, _ := json.Marshal(v)
+		plain = string(b)
+	}
+	out := redactedValue
+	if action == redactHash {
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(strings.TrimSpace(plain)))
+		out = "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	r.remember(val, out)
+	return out
+}
+
+// remember records the strings in val for scrub.
+func (r *redactor) remember(val interface{}, replacement string) {
+	switch v := val.(type) {
+	case string:
+		s := strings.TrimSpace(v)
+		if len(s) < redactorMinScrub {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.seen[s]; !ok {
+			r.order = append(r.order, s)
+			if len(r.order) > redactorMemory {
+				delete(r.seen, r.order[0])
+				r.order = r.order[1:]
+			}
+		}
+		r.seen[s] = replacement
+	case map[string]interface{}:
+		for _, x := range v {
+			r.remember(x, replacement)
+		}
+	case []interface{}:
+		for _, x := range v {
+			r.remember(x, replacement)
+		}
+	}
+}
+
+// result returns a copy of the result of funcName with the values matching
+// the rules hidden.
+func (r *redactor) result(funcName string, desc gorfc.FunctionDescription, result map[string]interface{}) map[string]interface{} {
+	if r == nil {
+		return result
+	}
+	out := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		out[k] = v
+	}
+	for _, p := range desc.Parameters {
+		if v, ok := out[p.Name]; ok {
+			out[p.Name] = r.value(funcName, []pathSegment{{p.Name, p.TypeDesc.Name}}, v, p.ParameterType, p.TypeDesc)
+		}
+	}
+	return out
+}
+
+func (r *redactor) value(scope string, path []pathSegment, val interface{}, rfcType string, td gorfc.TypeDescription) interface{} {
+	if action, ok := r.match(scope, path); ok {
+		return r.hide(val, action)
+	}
+	switch rfcType {
+	case "RFCTYPE_STRUCTURE":
+		row, ok := val.(map[string]interface{})
+		if !ok {
+			return val
+		}
+		out := make(map[string]interface{}, len(row))
+		for k, v := range row {
+			out[k] = v
+		}
+		for _, fd := range td.Fields {
+			if v, ok := out[fd.Name]; ok {
+				out[fd.Name] = r.value(scope, append(path[:len(path):len(path)], pathSegment{fd.Name, fd.TypeDesc.Name}), v, fd.FieldType, fd.TypeDesc)
+			}
+		}
+		return out
+	case "RFCTYPE_TABLE":
+		rows, ok := val.([]interface{})
+		if !ok {
+			return val
+		}
+		out := make([]interface{}, len(rows))
+		for i, row := range rows {
+			out[i] = r.value(scope, path, row, "RFCTYPE_STRUCTURE", td)
+		}
+		return out
+	}
+	return val
+}
+
+// input remembers the values of the parameters of funcName that match the
+// rules, so that they are scrubbed from the log entries, spans and error
+// messages of the call. It runs before the call, whether or not it is
+// audited.
+func (r *redactor) input(funcName string, desc gorfc.FunctionDescription, params map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	r.result(funcName, desc, upperKeys(params).(map[string]interface{}))
+}
+
+// upperKeys returns a copy of v with the keys of all objects uppercased,
+// matching the names of a function description.
+func upperKeys(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			out[strings.ToUpper(k)] = upperKeys(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = upperKeys(val)
+		}
+		return out
+	}
+	return v
+}
+
+// hides reports whether the rules hide field in read_table rows of table.
+func (r *redactor) hides(table, field string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.match(table, []pathSegment{{name: table}, {name: field}})
+	return ok
+}
+
+// rows hides the values of table rows read by read_table, in place.
+func (r *redactor) rows(table string, rows []map[string]interface{}, fields []ddicField) {
+	if r == nil {
+		return
+	}
+	for _, fd := range fields {
+		action, ok := r.match(table, []pathSegment{{name: table}, {name: fd.Name}})
+		if !ok {
+			continue
+		}
+		for _, row := range rows {
+			if v, ok := row[fd.Name]; ok {
+				row[fd.Name] = r.hide(v, action)
+			}
+		}
+	}
+}
+
+// arguments returns the tool arguments raw with the values matching the
+// rules hidden. Paths are formed from the argument names, so rfc_call's
+// parameters appear as PARAMETERS.PARAM.FIELD; scope is the function called.
+func (r *redactor) arguments(scope string, raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	if r == nil {
+		return raw
+	}
+	var walk func(path []pathSegment, v interface{}) interface{}
+	walk = func(path []pathSegment, v interface{}) interface{} {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			for k, val := range x {
+				p := append(path[:len(path):len(path)], pathSegment{name: strings.ToUpper(k)})
+				if action, ok := r.match(scope, p); ok {
+					x[k] = r.hide(val, action)
+				} else {
+					x[k] = walk(p, val)
+				}
+			}
+		case []interface{}:
+			for i, val := range x {
+				x[i] = walk(path, val)
+			}
+		}
+		return v
+	}
+	out, err := json.Marshal(walk(nil, v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// scrub replaces the values hidden so far in s, longest first.
+func (r *redactor) scrub(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hits []string
+	for v := range r.seen {
+		if strings.Contains(s, v) {
+			hits = append(hits, v)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return len(hits[i]) > len(hits[j]) })
+	for _, v := range hits {
+		s = strings.ReplaceAll(s, v, r.seen[v])
+	}
+	return s
+}
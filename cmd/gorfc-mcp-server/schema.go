@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── JSON Schema ──────────────────────────────────────────────────────────────
+
+// functionSchemas is the interface of a function module as JSON Schemas.
+// Input covers IMPORT, CHANGING and TABLES parameters in the form rfc_call
+// accepts them (see coerceValue); Output covers EXPORT, CHANGING and TABLES
+// parameters as rendered in results by an output format, which differs for
+// dates, times, decimals, 8-byte integers and flattened tables.
+type functionSchemas struct {
+	Input  *jsonschema.Schema `json:"input_schema"`
+	Output *jsonschema.Schema `json:"output_schema"`
+}
+
+// describeResult is the rfc_describe result: the function description with
+// its schemas.
+type describeResult struct {
+	gorfc.FunctionDescription
+	functionSchemas
+}
+
+// schemasForFunction translates desc, with the output schema describing
+// results rendered in format. Property names are upper case, as SAP names
+// them; validateInput upper-cases the arguments before comparing.
+func schemasForFunction(desc gorfc.FunctionDescription, format outputFormat) functionSchemas {
+	in := &jsonschema.Schema{Type: "object", Properties: map[string]*jsonschema.Schema{}}
+	out := &jsonschema.Schema{Type: "object", Properties: map[string]*jsonschema.Schema{}}
+	for _, p := range desc.Parameters {
+		schema := func(output bool) *jsonschema.Schema {
+			var f *outputFormat
+			if output {
+				f = &format
+			}
+			s := typeSchema(p.ParameterType, p.NucLength, p.Decimals, p.TypeDesc, f)
+			if text := parameterText(p); text != "" {
+				s.Description = text
+			}
+			return s
+		}
+		switch p.Direction {
+		case "RFC_IMPORT":
+			in.Properties[p.Name] = schema(false)
+			if !p.Optional {
+				in.Required = append(in.Required, p.Name)
+			}
+		case "RFC_EXPORT":
+			out.Properties[p.Name] = schema(true)
+		case "RFC_CHANGING":
+			in.Properties[p.Name] = schema(false)
+			out.Properties[p.Name] = schema(true)
+			if !p.Optional {
+				in.Required = append(in.Required, p.Name)
+			}
+		case "RFC_TABLES":
+			// SAP treats a table that is not passed as empty, so tables are
+			// never required.
+			in.Properties[p.Name] = schema(false)
+			out.Properties[p.Name] = schema(true)
+		}
+	}
+	in.AdditionalProperties = &jsonschema.Schema{Not: &jsonschema.Schema{}}
+	return functionSchemas{Input: in, Output: out}
+}
+
+func parameterText(p gorfc.ParameterDescription) string {
+	text := strings.TrimSpace(p.ParameterText)
+	if def := strings.TrimSpace(p.DefaultValue); def != "" {
+		if text != "" {
+			text += " "
+		}
+		text += "(ABAP default: " + def + ")"
+	}
+	return text
+}
+
+// typeSchema returns the schema of one parameter or field, as input or, if
+// out is not nil, as output rendered in *out (see formatValue). length and
+// decimals are the nuc (non-Unicode) length and the decimals of the
+// description, which for character types is the number of characters. Input
+// schemas accept every value coerceValue converts, which includes null for
+// any parameter or field.
+func typeSchema(rfcType string, length, decimals uint, td gorfc.TypeDescription, out *outputFormat) *jsonschema.Schema {
+	if out != nil {
+		return rfcTypeSchema(rfcType, length, decimals, td, out)
+	}
+	return nullable(rfcTypeSchema(rfcType, length, decimals, td, nil))
+}
+
+func rfcTypeSchema(rfcType string, length, decimals uint, td gorfc.TypeDescription, out *outputFormat) *jsonschema.Schema {
+	if out != nil {
+		iso := out.on(out.ISODates)
+		switch {
+		case rfcType == "RFCTYPE_DATE" && iso:
+			return &jsonschema.Schema{Types: []string{"string", "null"}, Pattern: `^[0-9]{4}-[0-9]{2}-[0-9]{2}$`, Description: "Date as YYYY-MM-DD; null if initial"}
+		case rfcType == "RFCTYPE_DATE":
+			return &jsonschema.Schema{Types: []string{"string", "null"}, Format: "date-time", Description: "Date as an RFC 3339 timestamp; null if initial"}
+		case rfcType == "RFCTYPE_TIME" && iso:
+			return &jsonschema.Schema{Type: "string", Pattern: `^[0-9]{2}:[0-9]{2}:[0-9]{2}$`, Description: "Time as HH:MM:SS"}
+		case rfcType == "RFCTYPE_TIME":
+			return &jsonschema.Schema{Type: "string", Format: "date-time", Description: "Time as an RFC 3339 timestamp on 0000-01-01"}
+		case rfcType == "RFCTYPE_BCD", rfcType == "RFCTYPE_DECF16", rfcType == "RFCTYPE_DECF34":
+			if out.Decimals == decimalsNumber {
+				return &jsonschema.Schema{Type: "number", Description: "Decimal number with every digit"}
+			}
+			return &jsonschema.Schema{Type: "string", Description: "Decimal number as an exact decimal string"}
+		case rfcType == "RFCTYPE_INT8":
+			if out.Decimals == decimalsNumber {
+				return &jsonschema.Schema{Type: "integer", Description: "8-byte integer"}
+			}
+			return &jsonschema.Schema{Type: "string", Pattern: `^-?[0-9]+$`, Description: "8-byte integer as a decimal string"}
+		}
+	}
+	switch rfcType {
+	case "RFCTYPE_CHAR", "RFCTYPE_STRING":
+		// Numbers are passed on in their decimal form.
+		return &jsonschema.Schema{Types: []string{"string", "number"}, MaxLength: maxLen(length)}
+	case "RFCTYPE_NUM":
+		return &jsonschema.Schema{Types: []string{"string", "number"}, MaxLength: maxLen(length), Pattern: `^[0-9]*$`}
+	case "RFCTYPE_UTCLONG":
+		return &jsonschema.Schema{Type: "string", Description: "UTC timestamp, e.g. 2024-03-01T13:45:00.0000000"}
+	case "RFCTYPE_DATE":
+		return &jsonschema.Schema{Type: "string", Pattern: `^[0-9]{8}$`, Description: "Date as YYYYMMDD"}
+	case "RFCTYPE_TIME":
+		return &jsonschema.Schema{Type: "string", Pattern: `^[0-9]{6}$`, Description: "Time as HHMMSS"}
+	case "RFCTYPE_INT":
+		return intSchema(math.MinInt32, math.MaxInt32)
+	case "RFCTYPE_INT1":
+		return intSchema(0, math.MaxUint8)
+	case "RFCTYPE_INT2":
+		return intSchema(math.MinInt16, math.MaxInt16)
+	case "RFCTYPE_INT8":
+		s := intSchema(math.MinInt32, math.MaxInt32)
+		s.Description = "8-byte integer, limited to the 4-byte range because gorfc passes INT8 values as 4-byte integers"
+		return s
+	case "RFCTYPE_FLOAT":
+		return &jsonschema.Schema{Type: "number"}
+	case "RFCTYPE_BCD":
+		// A packed number of n bytes holds 2n-1 digits.
+		digits := int(2*length) - 1
+		return decimalSchema(digits-int(decimals), int(decimals))
+	case "RFCTYPE_DECF16":
+		return decimalSchema(16, -1)
+	case "RFCTYPE_DECF34":
+		return decimalSchema(34, -1)
+	case "RFCTYPE_BYTE", "RFCTYPE_XSTRING":
+		s := &jsonschema.Schema{Type: "string", ContentEncoding: "base64"}
+		if length > 0 {
+			s.MaxLength = jsonschema.Ptr(int(length+2) / 3 * 4)
+		}
+		return s
+	case "RFCTYPE_STRUCTURE":
+		return structureSchema(td, out)
+	case "RFCTYPE_TABLE":
+		items := structureSchema(td, out)
+		if out == nil {
+			items = nullable(items)
+		}
+		table := &jsonschema.Schema{Type: "array", Items: items}
+		if out != nil && out.on(out.Flatten) {
+			// A table with exactly one row is returned as that row.
+			return &jsonschema.Schema{AnyOf: []*jsonschema.Schema{table, structureSchema(td, out)}}
+		}
+		return table
+	}
+	// Unknown type: any value is passed through unchanged.
+	return &jsonschema.Schema{}
+}
+
+func maxLen(length uint) *int {
+	if length == 0 {
+		return nil
+	}
+	return jsonschema.Ptr(int(length))
+}
+
+// intSchema accepts an integer within [min, max] or a string of digits; the
+// range of strings is checked by coerceValue.
+func intSchema(min, max float64) *jsonschema.Schema {
+	return &jsonschema.Schema{Types: []string{"integer", "string"}, Minimum: &min, Maximum: &max, Pattern: `^\s*[+-]?[0-9]+\s*$`}
+}
+
+// nullable makes s also accept null, which leaves the value initial.
+func nullable(s *jsonschema.Schema) *jsonschema.Schema {
+	switch {
+	case s.Type != "":
+		s.Types = []string{s.Type, "null"}
+		s.Type = ""
+	case len(s.Types) > 0:
+		s.Types = append(s.Types, "null")
+	}
+	return s
+}
+
+// decimalSchema accepts a number or, to keep every digit, a decimal string
+// with up to intDigits integer digits and decimals fraction digits
+// (decimals < 0: any number of either, and an exponent). The sign of a
+// string may lead or, as ABAP writes it, trail; see exactDecimal.
+func decimalSchema(intDigits, decimals int) *jsonschema.Schema {
+	digits := `[0-9]*(\.[0-9]*)?([eE][+-]?[0-9]+)?`
+	if decimals >= 0 && intDigits > 0 {
+		digits = fmt.Sprintf(`[0-9]{0,%d}(\.[0-9]{0,%d})?`, intDigits, decimals)
+	}
+	pattern := `^\s*([+-]?` + digits + `|` + digits + `\s*-)\s*$`
+	return &jsonschema.Schema{Types: []string{"number", "string"}, Pattern: pattern}
+}
+
+func structureSchema(td gorfc.TypeDescription, out *outputFormat) *jsonschema.Schema {
+	s := &jsonschema.Schema{
+		Type:                 "object",
+		Title:                td.Name,
+		Properties:           make(map[string]*jsonschema.Schema, len(td.Fields)),
+		AdditionalProperties: &jsonschema.Schema{Not: &jsonschema.Schema{}},
+	}
+	for _, f := range td.Fields {
+		s.Properties[f.Name] = typeSchema(f.FieldType, f.NucLength, f.Decimals, f.TypeDesc, out)
+	}
+	return s
+}
+
+// validateInput checks rfc_call parameters against the input schema of desc
+// before they are coerced, so that wrong names, types and lengths are
+// reported without a round-trip to SAP.
+func validateInput(params map[string]interface{}, desc gorfc.FunctionDescription) error {
+	rs, err := schemasForFunction(desc, outputFormat{}).Input.Resolve(nil)
+	if err != nil {
+		return fmt.Errorf("input schema of %s: %w", desc.Name, err)
+	}
+	if err := rs.Validate(validationValue(params)); err != nil {
+		return fmt.Errorf("parameters do not match the interface of %s: %w", desc.Name, err)
+	}
+	return nil
+}
+
+// validationValue returns v with the keys of all nested objects upper-cased
+// and json.Number values converted to float64, which the validator takes for
+// numbers. Decimals with more digits than float64 holds are still passed on
+// exactly; see coerceValue.
+func validationValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			out[strings.ToUpper(k)] = validationValue(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = validationValue(val)
+		}
+		return out
+	case json.Number:
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
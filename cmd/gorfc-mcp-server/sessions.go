@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Stateful sessions ────────────────────────────────────────────────────────
+
+// sessionConfig bounds the number and idle time of stateful sessions.
+type sessionConfig struct {
+	IdleTimeout time.Duration // idle sessions are rolled back and closed after this; 0 disables
+	MaxSessions int           // upper bound of open sessions; 0 means unlimited
+}
+
+// sessionRollbackTimeout bounds the rollback when a session ends, so that a
+// hung SAP session does not hold up the reaper, the disconnect of its client
+// or the server's shutdown.
+var sessionRollbackTimeout = 10 * time.Second
+
+func defaultSessionConfig() sessionConfig {
+	return sessionConfig{IdleTimeout: 5 * time.Minute, MaxSessions: 10}
+}
+
+// sessionConfigFromEnv reads session settings, falling back to
+// defaultSessionConfig for unset values.
+//
+//	MCP_SESSION_IDLE_TIMEOUT  – roll back and close idle sessions after this duration (default 5m, 0 disables)
+//	MCP_SESSION_MAX           – maximum open sessions (default 10, 0 for no limit)
+func sessionConfigFromEnv() (sessionConfig, error) {
+	cfg := defaultSessionConfig()
+	if s := os.Getenv("MCP_SESSION_IDLE_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("MCP_SESSION_IDLE_TIMEOUT: invalid duration %q", s)
+		}
+		cfg.IdleTimeout = d
+	}
+	if s := os.Getenv("MCP_SESSION_MAX"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MCP_SESSION_MAX: invalid number %q", s)
+		}
+		cfg.MaxSessions = n
+	}
+	return cfg, nil
+}
+
+// rfcSession is a dedicated connection, outside the pool, on which the
+// calls of one logical unit of work run in order, so that
+// BAPI_TRANSACTION_COMMIT applies to the work of the earlier calls. It is
+// never reconnected: a lost connection loses the uncommitted work, and the
+// session ends.
+type rfcSession struct {
+	id      string
+	cm      *connManager
+	store   *sessionStore
+	owner   *mcp.ServerSession // the MCP session that began it
+	created time.Time
+
+	mu       sync.Mutex // held for the duration of a call
+	conn     rfcConn    // nil once the session ended
+	lastUsed time.Time
+	calls    int
+}
+
+// sessionStore keeps the open sessions of all systems. The sessions of an
+// MCP session are ended when it disconnects.
+type sessionStore struct {
+	cfg  sessionConfig
+	done chan struct{}
+
+	mu       sync.Mutex
+	sessions map[string]*rfcSession
+	pending  int                         // sessions being begun, counted against MaxSessions
+	owners   map[*mcp.ServerSession]bool // MCP sessions watched for disconnects
+	closed   bool
+}
+
+func newSessionStore(cfg sessionConfig) *sessionStore {
+	st := &sessionStore{cfg: cfg, done: make(chan struct{}), sessions: map[string]*rfcSession{}, owners: map[*mcp.ServerSession]bool{}}
+	if cfg.IdleTimeout > 0 {
+		go st.reap()
+	}
+	return st
+}
+
+// begin opens a session on a new connection to cm's system. Its slot is
+// reserved before dialing, so that concurrent calls cannot exceed
+// MaxSessions.
+func (st *sessionStore) begin(cm *connManager, owner *mcp.ServerSession) (*rfcSession, error) {
+	st.mu.Lock()
+	switch {
+	case st.closed:
+		st.mu.Unlock()
+		return nil, errors.New("server is shutting down")
+	case st.cfg.MaxSessions > 0 && len(st.sessions)+st.pending >= st.cfg.MaxSessions:
+		st.mu.Unlock()
+		return nil, fmt.Errorf("too many open sessions (max %d); end or commit an existing one first", st.cfg.MaxSessions)
+	}
+	st.pending++
+	st.mu.Unlock()
+	s, err := st.open(cm, owner)
+	st.mu.Lock()
+	st.pending--
+	if err == nil && st.closed {
+		err = errors.New("server is shutting down")
+		s.conn.Close()
+	}
+	if err != nil {
+		st.mu.Unlock()
+		return nil, err
+	}
+	st.sessions[s.id] = s
+	watch := owner != nil && !st.owners[owner]
+	if watch {
+		st.owners[owner] = true
+	}
+	st.mu.Unlock()
+	if watch {
+		go st.watch(owner)
+	}
+	logger.Info("session begun", "session", s.id, "system", cm.system)
+	return s, nil
+}
+
+// open dials the connection of a new session.
+func (st *sessionStore) open(cm *connManager, owner *mcp.ServerSession) (*rfcSession, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("generate session ID: %w", err)
+	}
+	conn, err := cm.connect()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &rfcSession{id: hex.EncodeToString(b[:]), cm: cm, store: st, owner: owner, created: now, conn: conn, lastUsed: now}, nil
+}
+
+// watch ends the sessions of owner once it disconnects.
+func (st *sessionStore) watch(owner *mcp.ServerSession) {
+	owner.Wait()
+	st.mu.Lock()
+	delete(st.owners, owner)
+	var open []*rfcSession
+	for _, s := range st.sessions {
+		if s.owner == owner {
+			open = append(open, s)
+		}
+	}
+	st.mu.Unlock()
+	for _, s := range open {
+		st.end(s, "client disconnected, uncommitted work rolled back")
+	}
+}
+
+// get returns the open session id. Sessions can only be used from the MCP
+// session that began them.
+func (st *sessionStore) get(id string, owner *mcp.ServerSession) (*rfcSession, error) {
+	st.mu.Lock()
+	s, ok := st.sessions[id]
+	st.mu.Unlock()
+	if !ok || s.owner != owner {
+		return nil, fmt.Errorf("unknown session %q (it may have ended or timed out; use begin_session)", id)
+	}
+	return s, nil
+}
+
+// end rolls back the uncommitted work of s, waiting at most
+// sessionRollbackTimeout, and closes it whether or not the rollback
+// succeeded.
+func (st *sessionStore) end(s *rfcSession, reason string) {
+	st.drop(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sessionRollbackTimeout)
+	defer cancel()
+	if _, err := s.run(ctx, "BAPI_TRANSACTION_ROLLBACK", map[string]interface{}{}); err != nil {
+		logger.Warn("rollback on session end failed", "session", s.id, "err", err)
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	logger.Info("session ended", "session", s.id, "reason", reason)
+}
+
+// drop forgets s after its connection was lost or aborted.
+func (st *sessionStore) drop(s *rfcSession) {
+	st.mu.Lock()
+	delete(st.sessions, s.id)
+	st.mu.Unlock()
+}
+
+func (st *sessionStore) reap() {
+	interval := st.cfg.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-st.done:
+			return
+		case <-ticker.C:
+			st.reapOnce(time.Now())
+		}
+	}
+}
+
+// reapOnce ends the sessions idle for longer than IdleTimeout. Sessions in
+// the middle of a call are not idle.
+func (st *sessionStore) reapOnce(now time.Time) {
+	st.mu.Lock()
+	var idle []*rfcSession
+	for _, s := range st.sessions {
+		if !s.mu.TryLock() {
+			continue
+		}
+		if now.Sub(s.lastUsed) >= st.cfg.IdleTimeout {
+			idle = append(idle, s)
+		}
+		s.mu.Unlock()
+	}
+	st.mu.Unlock()
+	for _, s := range idle {
+		st.end(s, fmt.Sprintf("idle for more than %v, uncommitted work rolled back", st.cfg.IdleTimeout))
+	}
+}
+
+// close ends all sessions and stops the reaper.
+func (st *sessionStore) close() {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return
+	}
+	st.closed = true
+	open := make([]*rfcSession, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		open = append(open, s)
+	}
+	st.mu.Unlock()
+	close(st.done)
+	for _, s := range open {
+		st.end(s, "server shutdown, uncommitted work rolled back")
+	}
+}
+
+func (st *sessionStore) stats() map[string]interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return map[string]interface{}{
+		"open":            len(st.sessions),
+		"max":             st.cfg.MaxSessions,
+		"idle_timeout_ms": st.cfg.IdleTimeout.Milliseconds(),
+	}
+}
+
+// call runs funcName on the session's connection after checking the call
+// policy.
+func (s *rfcSession) call(ctx context.Context, funcName string, params map[string]interface{}) (map[string]interface{}, error) {
+	auditRFC(ctx, s.cm, funcName)
+	ctx, sp := startSpan(ctx, "rfc.call "+funcName)
+	defer sp.end()
+	sp.set("rfc.function", funcName)
+	sp.set("rfc.session", s.id)
+	traceSAP(sp, s.cm)
+	if err := s.cm.policy.check(funcName); err != nil {
+		sp.failOn(err)
+		return nil, err
+	}
+	out, err := s.exec(ctx, funcName, params)
+	sp.failOn(err)
+	sp.set("rfc.rows", resultRows(out))
+	return out, err
+}
+
+// rollback discards the uncommitted work of s. It is always permitted, as it
+// cannot change data.
+func (s *rfcSession) rollback(ctx context.Context) error {
+	auditRFC(ctx, s.cm, "BAPI_TRANSACTION_ROLLBACK")
+	_, err := s.exec(ctx, "BAPI_TRANSACTION_ROLLBACK", map[string]interface{}{})
+	return err
+}
+
+// exec runs funcName on the session's connection, after the calls before it.
+func (s *rfcSession) exec(ctx context.Context, funcName string, params map[string]interface{}) (map[string]interface{}, error) {
+	_, sp := startSpan(ctx, "rfc.session_lock")
+	s.mu.Lock()
+	sp.end()
+	defer s.mu.Unlock()
+	_, sp = startSpan(ctx, "rfc.execute")
+	sp.setKind(spanKindClient)
+	defer sp.end()
+	return s.run(ctx, funcName, params)
+}
+
+// run executes funcName on the session's connection; s.mu must be held. If
+// ctx ends first or the connection fails, the session is dropped, since its
+// uncommitted work is lost. An RFC abandoned because ctx ended is left to
+// finish in the background and its connection is closed once it returns, as
+// the SDK does not allow closing a handle while a call on it is in progress.
+func (s *rfcSession) run(ctx context.Context, funcName string, params map[string]interface{}) (map[string]interface{}, error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("session %s has ended", s.id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, ctxErr(ctx)
+	}
+	conn := s.conn
+	type result struct {
+		out map[string]interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := conn.Call(funcName, params)
+		done <- result{out, err}
+	}()
+	var lost error
+	select {
+	case r := <-done:
+		s.lastUsed = time.Now()
+		s.calls++
+		if !isConnErr(r.err) {
+			return r.out, r.err
+		}
+		lost = r.err
+		conn.Close()
+	case <-ctx.Done():
+		lost = ctxErr(ctx)
+		logger.WarnContext(ctx, "abandoning in-flight RFC", "session", s.id, "err", lost)
+		go func() {
+			<-done
+			conn.Close()
+		}()
+	}
+	s.conn = nil
+	s.store.drop(s)
+	return nil, fmt.Errorf("session %s ended, its uncommitted work is lost: %w", s.id, lost)
+}
+
+// info describes s for tool results.
+func (s *rfcSession) info() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"session_id": s.id,
+		"system":     s.cm.system,
+		"created":    s.created.UTC().Format(time.RFC3339),
+		"calls":      s.calls,
+	}
+}
+
+// target resolves the connManager a call goes to: the system of session
+// sessionID if one is given, otherwise the named system. A system that
+// contradicts the session's is an error.
+func (r *systemRegistry) target(ctx context.Context, system, sessionID string, owner *mcp.ServerSession) (*connManager, *rfcSession, error) {
+	if sessionID == "" {
+		cm, err := r.get(ctx, system)
+		return cm, nil, err
+	}
+	sess, err := r.sessions.get(sessionID, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	if system != "" && !strings.EqualFold(system, sess.cm.system) {
+		return nil, nil, fmt.Errorf("session %s belongs to system %s, not %s", sessionID, sess.cm.system, system)
+	}
+	return sess.cm, sess, nil
+}
+
+// sessionIDProp is the JSON Schema of the optional "session_id" argument of
+// rfc_call and the function tools.
+const sessionIDProp = `"session_id":{"type":"string","description":"Run the call in this session (see begin_session), so that a later commit covers its changes. Defaults to a stateless call on a pooled connection."}`
+
+// registerSessionTools registers begin_session, commit, rollback and
+// end_session.
+func registerSessionTools(addTool func(*mcp.Tool, mcp.ToolHandler), systems *systemRegistry, m *metrics) {
+	sessions := systems.sessions
+
+	// session resolves the session_id argument shared by the tools below.
+	session := func(req *mcp.CallToolRequest) (*rfcSession, error) {
+		var args struct {
+			SessionID string `json:"session_id"`
+		}
+		if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		if args.SessionID == "" {
+			return nil, fmt.Errorf("session_id is required")
+		}
+		return sessions.get(args.SessionID, req.Session)
+	}
+	const sessionSchema = `{"type":"object","properties":{"session_id":{"type":"string","description":"ID returned by begin_session"}},"required":["session_id"]}`
+
+	// ── begin_session ─────────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "begin_session",
+		Description: "Open a stateful session on a dedicated SAP connection and return its session_id. Calls passing the session_id (rfc_call, function tools) run in order on that connection, so that commit saves the work of BAPIs called before it, as BAPI_TRANSACTION_COMMIT requires. Sessions left idle are rolled back and closed automatically.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` + systemProp + `}}`),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var args struct {
+			System string `json:"system"`
+		}
+		if len(req.Params.Arguments) > 0 {
+			if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+				return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+			}
+		}
+		cm, err := systems.get(ctx, args.System)
+		if err != nil {
+			return errResult(err), nil
+		}
+		t0 := time.Now()
+		sess, err := sessions.begin(cm, req.Session)
+		m.record(cm.system, "begin_session", time.Since(t0), err)
+		if err != nil {
+			return errResult(err), nil
+		}
+		info := sess.info()
+		if sessions.cfg.IdleTimeout > 0 {
+			info["idle_timeout"] = sessions.cfg.IdleTimeout.String()
+		}
+		return jsonResult(info), nil
+	})
+
+	// ── commit ────────────────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "commit",
+		Description: "Commit the work of a session by calling BAPI_TRANSACTION_COMMIT on its connection. The session stays open for further calls.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"session_id":{"type":"string","description":"ID returned by begin_session"},"wait":{"type":"boolean","description":"Wait until the update task has written the changes (default: true)"}},"required":["session_id"]}`),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var args struct {
+			Wait *bool `json:"wait"`
+		}
+		if err := json.Unmarshal(req.Params.Arguments, &args); err != nil {
+			return errResult(fmt.Errorf("invalid arguments: %w", err)), nil
+		}
+		sess, err := session(req)
+		if err != nil {
+			return errResult(err), nil
+		}
+		params := map[string]interface{}{}
+		if args.Wait == nil || *args.Wait {
+			params["WAIT"] = "X"
+		}
+		desc, err := sess.cm.describe(ctx, "BAPI_TRANSACTION_COMMIT")
+		if err != nil {
+			return errResult(fmt.Errorf("describe BAPI_TRANSACTION_COMMIT: %w", err)), nil
+		}
+		t0 := time.Now()
+		result, err := sess.call(ctx, "BAPI_TRANSACTION_COMMIT", params)
+		if err != nil {
+			m.record(sess.cm.system, "BAPI_TRANSACTION_COMMIT", time.Since(t0), err)
+			return errResult(err), nil
+		}
+		msgs := bapiMessages(desc, result)
+		m.record(sess.cm.system, "BAPI_TRANSACTION_COMMIT", time.Since(t0), bapiFailure("BAPI_TRANSACTION_COMMIT", msgs))
+		m.recordBAPI(msgs)
+		return bapiResult(result, msgs), nil
+	})
+
+	// ── rollback ──────────────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "rollback",
+		Description: "Discard the uncommitted work of a session by calling BAPI_TRANSACTION_ROLLBACK on its connection. The session stays open for further calls.",
+		InputSchema: json.RawMessage(sessionSchema),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sess, err := session(req)
+		if err != nil {
+			return errResult(err), nil
+		}
+		t0 := time.Now()
+		err = sess.rollback(ctx)
+		m.record(sess.cm.system, "BAPI_TRANSACTION_ROLLBACK", time.Since(t0), err)
+		if err != nil {
+			return errResult(err), nil
+		}
+		return textResult("Rolled back the uncommitted work of session " + sess.id + "."), nil
+	})
+
+	// ── end_session ───────────────────────────────────────────────────────────
+	addTool(&mcp.Tool{
+		Name:        "end_session",
+		Description: "Close a session. Work that was not committed is rolled back.",
+		InputSchema: json.RawMessage(sessionSchema),
+	}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sess, err := session(req)
+		if err != nil {
+			return errResult(err), nil
+		}
+		sessions.end(sess, "ended by the client")
+		return textResult("Session " + sess.id + " ended; uncommitted work was rolled back."), nil
+	})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Metadata snapshot ────────────────────────────────────────────────────────
+
+// snapshotVersion is the format version of snapshot files. Files of another
+// version are ignored.
+const snapshotVersion = 1
+
+// snapshotStore persists fetched metadata below dir so that it survives a
+// restart. Entries younger than maxAge are used instead of asking SAP again;
+// while SAP is unreachable, entries of any age are served. Entries are stored
+// per SAP system ID and client:
+//
+//	<dir>/<SID>-<CLIENT>/function/<NAME>.json
+//	<dir>/<SID>-<CLIENT>/table/<TABLE>@<LANG>.json
+//	<dir>/index.json  – configured system name → "<SID>-<CLIENT>"
+//
+// A nil *snapshotStore stores nothing.
+type snapshotStore struct {
+	dir    string
+	maxAge time.Duration // entries older than this are fetched again; 0 never reuses them
+
+	mu    sync.Mutex
+	index map[string]string
+}
+
+// snapshotFile is the on-disk form of one snapshot entry.
+type snapshotFile struct {
+	Version  int             `json:"version"`
+	System   string          `json:"system"`
+	SystemID string          `json:"system_id"`
+	Client   string          `json:"client"`
+	Kind     string          `json:"kind"`
+	Name     string          `json:"name"`
+	SavedAt  time.Time       `json:"saved_at"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// snapshotInfo describes the snapshot entry a response was served from.
+type snapshotInfo struct {
+	SavedAt  time.Time
+	SystemID string
+	Client   string
+	Err      error // why SAP could not be asked
+}
+
+// snapshotStoreFromEnv returns nil when MCP_SNAPSHOT_DIR is unset.
+//
+//	MCP_SNAPSHOT_DIR      – directory for persisted metadata (enables snapshots)
+//	MCP_SNAPSHOT_MAX_AGE  – age up to which entries are used instead of
+//	                        asking SAP (default 24h, 0 only serves them while
+//	                        SAP is unreachable)
+func snapshotStoreFromEnv() (*snapshotStore, error) {
+	dir := os.Getenv("MCP_SNAPSHOT_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	st := &snapshotStore{dir: dir, maxAge: 24 * time.Hour, index: map[string]string{}}
+	if s := os.Getenv("MCP_SNAPSHOT_MAX_AGE"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_SNAPSHOT_MAX_AGE: %w", err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("MCP_SNAPSHOT_MAX_AGE must not be negative")
+		}
+		st.maxAge = d
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("MCP_SNAPSHOT_DIR: %w", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("MCP_SNAPSHOT_DIR: %w", err)
+	default:
+		if err := json.Unmarshal(b, &st.index); err != nil {
+			return nil, fmt.Errorf("MCP_SNAPSHOT_DIR: parse index.json: %w", err)
+		}
+	}
+	return st, nil
+}
+
+// sapIdentity is the system ID, client and user a connection is logged on
+// to. Snapshots are kept per system ID and client.
+type sapIdentity struct {
+	SystemID string
+	Client   string
+	User     string
+}
+
+func (id sapIdentity) namespace() string {
+	if id.SystemID == "" || id.Client == "" {
+		return ""
+	}
+	return strings.ToUpper(id.SystemID) + "-" + id.Client
+}
+
+// namespace returns the directory of system's entries. The identity of a
+// live connection wins; otherwise the one last recorded in the index is used.
+func (st *snapshotStore) namespace(system string, id sapIdentity) string {
+	if ns := id.namespace(); ns != "" {
+		return ns
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.index[strings.ToUpper(system)]
+}
+
+func (st *snapshotStore) path(ns, kind, name string) string {
+	return filepath.Join(st.dir, ns, kind, url.PathEscape(strings.ToUpper(name))+".json")
+}
+
+// save writes v as the entry kind/name of system. Failures are logged, as
+// the snapshot is only an optimization.
+func (st *snapshotStore) save(system string, id sapIdentity, kind, name string, v interface{}) {
+	if st == nil || id.namespace() == "" {
+		return
+	}
+	if err := st.write(system, id, kind, name, v); err != nil {
+		logger.Warn("snapshot write failed", "system", system, "kind", kind, "name", name, "err", err)
+	}
+}
+
+func (st *snapshotStore) write(system string, id sapIdentity, kind, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(snapshotFile{
+		Version:  snapshotVersion,
+		System:   system,
+		SystemID: id.SystemID,
+		Client:   id.Client,
+		Kind:     kind,
+		Name:     strings.ToUpper(name),
+		SavedAt:  time.Now().UTC(),
+		Data:     data,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	ns := id.namespace()
+	if err := writeFileAtomic(st.path(ns, kind, name), b); err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	key := strings.ToUpper(system)
+	if st.index[key] == ns {
+		return nil
+	}
+	st.index[key] = ns
+	idx, err := json.MarshalIndent(st.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(st.dir, "index.json"), idx)
+}
+
+// writeFileAtomic replaces path so that readers never see a partial file.
+func writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load decodes the entry kind/name of system into dst.
+func (st *snapshotStore) load(system string, id sapIdentity, kind, name string, dst interface{}) (*snapshotInfo, bool) {
+	if st == nil {
+		return nil, false
+	}
+	ns := st.namespace(system, id)
+	if ns == "" {
+		return nil, false
+	}
+	b, err := os.ReadFile(st.path(ns, kind, name))
+	if err != nil {
+		return nil, false
+	}
+	var f snapshotFile
+	if err := json.Unmarshal(b, &f); err != nil || f.Version != snapshotVersion {
+		return nil, false
+	}
+	if err := json.Unmarshal(f.Data, dst); err != nil {
+		return nil, false
+	}
+	return &snapshotInfo{SavedAt: f.SavedAt, SystemID: f.SystemID, Client: f.Client}, true
+}
+
+// current decodes the entry kind/name saved for the system ID and client of
+// id into dst if it is younger than the store's maximum age.
+func (st *snapshotStore) current(system string, id sapIdentity, kind, name string, dst interface{}) bool {
+	if st == nil || st.maxAge <= 0 || id.namespace() == "" {
+		return false
+	}
+	var v json.RawMessage
+	info, ok := st.load(system, id, kind, name, &v)
+	if !ok || !strings.EqualFold(info.SystemID, id.SystemID) || info.Client != id.Client || time.Since(info.SavedAt) > st.maxAge {
+		return false
+	}
+	return json.Unmarshal(v, dst) == nil
+}
+
+// invalidate deletes system's entries matching kind and the name pattern,
+// with the same semantics as metadataCache.invalidate.
+func (st *snapshotStore) invalidate(system string, id sapIdentity, kind, pattern string) int {
+	if st == nil {
+		return 0
+	}
+	ns := st.namespace(system, id)
+	if ns == "" {
+		return 0
+	}
+	pattern = strings.ToUpper(pattern)
+	n := 0
+	for _, k := range []string{cacheFunction, cacheTable} {
+		if kind != "" && kind != k {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(st.dir, ns, k, "*.json"))
+		for _, file := range files {
+			name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(file), ".json"))
+			if err != nil {
+				continue
+			}
+			name, _, _ = strings.Cut(name, "@")
+			if pattern != "" && !wildcardMatch(pattern, name) {
+				continue
+			}
+			if os.Remove(file) == nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// sapUnreachable reports whether err means SAP could not be asked at all, as
+// opposed to SAP answering with an error.
+func sapUnreachable(err error) bool {
+	return isConnErr(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// describe returns the interface of funcName on s, falling back to the
+// snapshot when SAP is unreachable. A non-nil *snapshotInfo marks a
+// snapshot response.
+func (r *systemRegistry) describe(ctx context.Context, s *sapSystem, funcName string) (gorfc.FunctionDescription, *snapshotInfo, error) {
+	var desc gorfc.FunctionDescription
+	cm, err := s.manager(ctx, r)
+	if err == nil {
+		desc, err = cm.describe(ctx, funcName)
+		if err == nil || !sapUnreachable(err) {
+			return desc, nil, err
+		}
+	}
+	info, ok := r.snapshots.load(s.cfg.Name, s.identity(), cacheFunction, funcName, &desc)
+	if !ok {
+		return desc, nil, err
+	}
+	info.Err = err
+	return desc, info, nil
+}
+
+// fieldInfo is describe for DDIF_FIELDINFO_GET results.
+func (r *systemRegistry) fieldInfo(ctx context.Context, s *sapSystem, table, lang string) (map[string]interface{}, *snapshotInfo, error) {
+	cm, err := s.manager(ctx, r)
+	if err == nil {
+		var result map[string]interface{}
+		result, err = cm.fieldInfo(ctx, table, lang)
+		if err == nil || !sapUnreachable(err) {
+			return result, nil, err
+		}
+	}
+	var result map[string]interface{}
+	info, ok := r.snapshots.load(s.cfg.Name, s.identity(), cacheTable, table+"@"+lang, &result)
+	if !ok {
+		return nil, nil, err
+	}
+	info.Err = err
+	return result, info, nil
+}
+
+// snapshotResult is jsonResult for data served from a snapshot, followed by a
+// note naming the snapshot's age.
+func snapshotResult(v interface{}, info *snapshotInfo) *mcp.CallToolResult {
+	res := jsonResult(v)
+	res.Content = append(res.Content, &mcp.TextContent{Text: fmt.Sprintf(
+		"Served from the metadata snapshot of %s (system %s, client %s) because SAP is unreachable: %v",
+		info.SavedAt.Format(time.RFC3339), info.SystemID, info.Client, info.Err)})
+	return res
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── SAP systems ──────────────────────────────────────────────────────────────
+
+// systemConfig describes one named SAP system. Exactly one of Dest
+// (a sapnwrfc.ini destination) or Params (explicit connection parameters)
+// must be set. Param values may reference environment variables as ${VAR}
+// so that passwords need not be stored in the config file. Policy, if set,
+// narrows the server-wide policy for this system; TableReader replaces the
+// server-wide setting.
+type systemConfig struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Dest        string            `json:"dest,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Policy      *callPolicy       `json:"policy,omitempty"`
+	TableReader string            `json:"table_reader,omitempty"`
+}
+
+// serverConfig is the JSON document referenced by MCP_CONFIG.
+type serverConfig struct {
+	DefaultSystem string               `json:"default_system,omitempty"`
+	Systems       []systemConfig       `json:"systems"`
+	Policy        *callPolicy          `json:"policy,omitempty"`
+	TableReader   string               `json:"table_reader,omitempty"`
+	FunctionTools []functionToolConfig `json:"function_tools,omitempty"`
+	Output        *outputFormat        `json:"output,omitempty"`
+	Redaction     *redactionConfig     `json:"redaction,omitempty"`
+}
+
+// serverConfigFromEnv loads the file named by MCP_CONFIG, or returns nil if
+// the variable is unset.
+func serverConfigFromEnv() (*serverConfig, error) {
+	path := os.Getenv("MCP_CONFIG")
+	if path == "" {
+		return nil, nil
+	}
+	cfg, err := loadServerConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("MCP_CONFIG: %w", err)
+	}
+	return cfg, nil
+}
+
+func loadServerConfig(path string) (*serverConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg serverConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (sc systemConfig) connParams() (gorfc.ConnectionParameters, error) {
+	switch {
+	case sc.Dest != "" && len(sc.Params) > 0:
+		return nil, fmt.Errorf("system %q: set either dest or params, not both", sc.Name)
+	case sc.Dest != "":
+		return gorfc.ConnectionParameters{"dest": sc.Dest}, nil
+	case len(sc.Params) > 0:
+		params := make(gorfc.ConnectionParameters, len(sc.Params))
+		for k, v := range sc.Params {
+			params[strings.ToLower(k)] = os.ExpandEnv(v)
+		}
+		return params, nil
+	}
+	return nil, fmt.Errorf("system %q: dest or params required", sc.Name)
+}
+
+// systemsFromEnv determines the configured systems, in order of precedence:
+//
+//	cfg                    – the MCP_CONFIG file's "systems" list
+//	SAP_DEST / arguments   – one or more sapnwrfc.ini destinations, SAP_DEST
+//	                         comma-separated or one per CLI argument
+//	SAP_ASHOST / SAP_MSHOST – a single system from connParamsFromEnv, named
+//	                         after SAP_SYSID (or "default")
+//
+// The returned default is the system used when a tool call names none.
+func systemsFromEnv(cfg *serverConfig, args []string) (systems []systemConfig, def string, err error) {
+	if cfg != nil && len(cfg.Systems) > 0 {
+		return cfg.Systems, cfg.DefaultSystem, nil
+	}
+
+	var dests []string
+	if s := os.Getenv("SAP_DEST"); s != "" {
+		for _, d := range strings.Split(s, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				dests = append(dests, d)
+			}
+		}
+	} else {
+		dests = args
+	}
+	if len(dests) > 0 {
+		for _, d := range dests {
+			systems = append(systems, systemConfig{Name: d, Dest: d})
+		}
+		return systems, dests[0], nil
+	}
+
+	params, err := connParamsFromEnv()
+	if err != nil {
+		return nil, "", err
+	}
+	if params == nil {
+		return nil, "", nil
+	}
+	name := params["sysid"]
+	if name == "" {
+		name = "default"
+	}
+	return []systemConfig{{Name: name, Params: params}}, name, nil
+}
+
+// sapSystem is one entry of a systemRegistry. Its connManager is created at
+// startup; if that fails, the system is served degraded and creation is
+// retried on the next tool call.
+type sapSystem struct {
+	cfg    systemConfig
+	params gorfc.ConnectionParameters
+	policy *callPolicy
+	reader string
+
+	mu      sync.Mutex
+	cm      *connManager
+	err     error
+	opening chan struct{} // closed when the running open finishes; nil if none runs
+	closed  bool          // the registry was closed; a finished open is discarded
+}
+
+// manager returns the connManager of s, opening it if needed. Opening dials
+// the pool's minimum connections, which the NW RFC SDK cannot cancel, so it
+// runs without s.mu held, once for all callers, and a caller whose ctx ends
+// stops waiting while the open goes on for the next one.
+func (s *sapSystem) manager(ctx context.Context, r *systemRegistry) (*connManager, error) {
+	s.mu.Lock()
+	if s.cm != nil {
+		s.mu.Unlock()
+		return s.cm, nil
+	}
+	done := s.opening
+	if done == nil {
+		done = make(chan struct{})
+		s.opening = done
+		go s.open(r, done)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("system %s: %w", s.cfg.Name, ctx.Err())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cm == nil {
+		return nil, fmt.Errorf("system %s: %w", s.cfg.Name, s.err)
+	}
+	return s.cm, nil
+}
+
+// open creates the connManager of s and closes done.
+func (s *sapSystem) open(r *systemRegistry, done chan struct{}) {
+	defer close(done)
+	cm, err := newConnManagerWithDial(s.params, r.pool, r.cassette.wrap(s.cfg.Name, r.dial))
+	if err == nil {
+		cm.system = s.cfg.Name
+		cm.policy = s.policy
+		cm.readers.configured = s.reader
+		cm.cache = r.cache
+		cm.snapshots = r.snapshots
+		cm.redact = r.redact
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed && cm != nil {
+		cm.close()
+		cm, err = nil, fmt.Errorf("registry closed")
+	}
+	s.cm, s.err, s.opening = cm, err, nil
+}
+
+// identity returns the system ID and client of the connected system, or the
+// zero value if it has not been connected.
+func (s *sapSystem) identity() sapIdentity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cm == nil {
+		return sapIdentity{}
+	}
+	return s.cm.identity()
+}
+
+// systemRegistry keeps one connManager per named SAP system. Names are
+// matched case-insensitively.
+type systemRegistry struct {
+	pool      poolConfig
+	dial      dialFunc
+	cache     *metadataCache
+	snapshots *snapshotStore
+	cassette  *cassette
+	sessions  *sessionStore
+	output    outputFormat // default rendering of function results
+	redact    *redactor
+	def       string
+	order     []string
+	systems   map[string]*sapSystem
+}
+
+// registryOptions are the settings shared by all systems of a registry.
+// Policy applies to every system, narrowed by the system's own; TableReader
+// applies to every system that does not define its own.
+type registryOptions struct {
+	Pool        poolConfig
+	Policy      *callPolicy
+	TableReader string
+	Cache       *metadataCache
+	Snapshots   *snapshotStore
+	Dial        dialFunc  // nil uses the NW RFC SDK
+	Cassette    *cassette // records or replays the traffic of all systems
+	Sessions    sessionConfig
+	Output      outputFormat
+	Redact      *redactor
+}
+
+// newSystemRegistry validates configs.
+func newSystemRegistry(configs []systemConfig, def string, opts registryOptions) (*systemRegistry, error) {
+	r := &systemRegistry{
+		pool:      opts.Pool,
+		dial:      opts.Dial,
+		cache:     opts.Cache,
+		snapshots: opts.Snapshots,
+		cassette:  opts.Cassette,
+		output:    opts.Output,
+		redact:    opts.Redact,
+		systems:   make(map[string]*sapSystem, len(configs)),
+	}
+	if r.dial == nil {
+		r.dial = dialGorfc
+	}
+	for _, sc := range configs {
+		key := strings.ToUpper(sc.Name)
+		if key == "" {
+			return nil, fmt.Errorf("system name is required")
+		}
+		if _, dup := r.systems[key]; dup {
+			return nil, fmt.Errorf("duplicate system %q", sc.Name)
+		}
+		params, err := sc.connParams()
+		if err != nil {
+			return nil, err
+		}
+		pol := sc.Policy.within(opts.Policy)
+		rd := opts.TableReader
+		if sc.TableReader != "" {
+			rd = sc.TableReader
+		}
+		r.systems[key] = &sapSystem{cfg: sc, params: params, policy: pol, reader: rd}
+		r.order = append(r.order, key)
+	}
+	if len(r.order) == 0 {
+		return nil, fmt.Errorf("no SAP systems configured")
+	}
+	r.def = strings.ToUpper(def)
+	if r.def == "" {
+		r.def = r.order[0]
+	}
+	if _, ok := r.systems[r.def]; !ok {
+		return nil, fmt.Errorf("default system %q is not configured", def)
+	}
+	r.sessions = newSessionStore(opts.Sessions)
+	return r, nil
+}
+
+// connectAll opens the pools of all systems. Failures, including the default
+// system's, are logged and retried on first use; until then metadata is
+// served from snapshots.
+func (r *systemRegistry) connectAll() {
+	for _, key := range r.order {
+		s := r.systems[key]
+		logger.Info("connecting to SAP system", "system", s.cfg.Name, "target", describeTarget(s.params))
+		if _, err := s.manager(context.Background(), r); err != nil {
+			logger.Warn("connection failed, will retry on first use", "err", err)
+		}
+	}
+}
+
+// get returns the connManager for name, or for the default system when name
+// is empty, waiting for it to be opened until ctx is done.
+func (r *systemRegistry) get(ctx context.Context, name string) (*connManager, error) {
+	s, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.manager(ctx, r)
+}
+
+// lookup resolves name like get without connecting.
+func (r *systemRegistry) lookup(name string) (*sapSystem, error) {
+	key := strings.ToUpper(name)
+	if key == "" {
+		key = r.def
+	}
+	s, ok := r.systems[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown system %q (available: %s)", name, strings.Join(r.names(), ", "))
+	}
+	return s, nil
+}
+
+func (r *systemRegistry) names() []string {
+	out := make([]string, len(r.order))
+	for i, key := range r.order {
+		out[i] = r.systems[key].cfg.Name
+	}
+	return out
+}
+
+// list describes every configured system without exposing credentials.
+func (r *systemRegistry) list() []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(r.order))
+	for _, key := range r.order {
+		s := r.systems[key]
+		s.mu.Lock()
+		entry := map[string]interface{}{
+			"name":      s.cfg.Name,
+			"default":   key == r.def,
+			"target":    describeTarget(s.params),
+			"connected": s.cm != nil,
+			"read_only": s.policy.readOnly(),
+		}
+		if s.cfg.Description != "" {
+			entry["description"] = s.cfg.Description
+		}
+		if s.cm != nil {
+			if name := s.cm.resolvedTableReader(); name != "" {
+				entry["table_reader"] = name
+			}
+		}
+		for _, k := range []string{"dest", "client", "user", "lang", "sysid"} {
+			if v := s.params[k]; v != "" {
+				entry[k] = v
+			}
+		}
+		if s.err != nil {
+			entry["error"] = s.err.Error()
+		}
+		s.mu.Unlock()
+		out = append(out, entry)
+	}
+	return out
+}
+
+// poolStats returns the connection pool statistics of every connected system.
+func (r *systemRegistry) poolStats() map[string]interface{} {
+	out := make(map[string]interface{}, len(r.order))
+	for _, key := range r.order {
+		s := r.systems[key]
+		s.mu.Lock()
+		if s.cm != nil {
+			out[s.cfg.Name] = s.cm.pool.stats()
+		}
+		s.mu.Unlock()
+	}
+	return out
+}
+
+// close ends all sessions, rolling back their uncommitted work, and closes
+// the pools.
+func (r *systemRegistry) close() {
+	r.sessions.close()
+	for _, s := range r.systems {
+		s.mu.Lock()
+		s.closed = true
+		if s.cm != nil {
+			s.cm.close()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// describeTarget summarizes connection parameters for logs, omitting secrets.
+func describeTarget(params gorfc.ConnectionParameters) string {
+	if dest := params["dest"]; dest != "" {
+		return "dest=" + dest
+	}
+	var parts []string
+	for _, k := range []string{"ashost", "mshost", "sysnr", "client", "user"} {
+		if v := params[k]; v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
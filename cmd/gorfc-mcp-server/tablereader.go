@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// ─── Table readers ────────────────────────────────────────────────────────────
+
+// tableQuery is one generic table read: the fields to return, the WHERE
+// clause as OPTIONS rows, and the row window.
+type tableQuery struct {
+	Table   string
+	Fields  []string
+	Options []interface{}
+	Skip    int
+	Count   int
+}
+
+// tableReader reads table rows through one particular function module.
+type tableReader interface {
+	// name is the function module used.
+	name() string
+	// rowWidth is the maximum number of characters per returned row.
+	rowWidth() int
+	read(ctx context.Context, cm *connManager, q tableQuery) ([]map[string]string, error)
+}
+
+const (
+	readerAuto = "auto"
+	readerBODS = "/BODS/RFC_READ_TABLE2"
+)
+
+// tableReaderCandidates are probed in order when the reader is "auto".
+// /BODS/RFC_READ_TABLE2 returns rows of up to 30000 characters and
+// BBP_RFC_READ_TABLE formats floating point fields without truncation, so
+// both are preferred over RFC_READ_TABLE.
+var tableReaderCandidates = []string{readerBODS, "BBP_RFC_READ_TABLE", "RFC_READ_TABLE"}
+
+// rfcReadTable is a reader for RFC_READ_TABLE and modules sharing its
+// interface (QUERY_TABLE, OPTIONS, FIELDS, DATA), such as BBP_RFC_READ_TABLE
+// and most custom Z copies.
+type rfcReadTable struct {
+	function string
+	width    int
+}
+
+func (r rfcReadTable) name() string  { return r.function }
+func (r rfcReadTable) rowWidth() int { return r.width }
+
+func (r rfcReadTable) read(ctx context.Context, cm *connManager, q tableQuery) ([]map[string]string, error) {
+	result, err := cm.call(ctx, r.function, readTableParams(q))
+	if err != nil {
+		return nil, err
+	}
+	return parseReadTableResult(result)
+}
+
+// bodsReadTable reads via /BODS/RFC_READ_TABLE2, which picks the narrowest of
+// its TBLOUT128 … TBLOUT30000 tables that fits a row and names it in
+// OUT_TABLE.
+type bodsReadTable struct{}
+
+func (bodsReadTable) name() string  { return readerBODS }
+func (bodsReadTable) rowWidth() int { return 30000 }
+
+func (bodsReadTable) read(ctx context.Context, cm *connManager, q tableQuery) ([]map[string]string, error) {
+	result, err := cm.call(ctx, readerBODS, readTableParams(q))
+	if err != nil {
+		return nil, err
+	}
+	out, _ := result["OUT_TABLE"].(string)
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return nil, nil
+	}
+	return parseReadTableResult(map[string]interface{}{
+		"FIELDS": result["FIELDS"],
+		"DATA":   result[out],
+	})
+}
+
+func readTableParams(q tableQuery) map[string]interface{} {
+	fields := make([]interface{}, len(q.Fields))
+	for i, f := range q.Fields {
+		fields[i] = map[string]interface{}{"FIELDNAME": f}
+	}
+	params := map[string]interface{}{
+		"QUERY_TABLE": q.Table,
+		"ROWCOUNT":    q.Count,
+		"OPTIONS":     q.Options,
+		"FIELDS":      fields,
+	}
+	if q.Skip > 0 {
+		params["ROWSKIPS"] = q.Skip
+	}
+	return params
+}
+
+// tableReaderFromEnv returns the configured reader: MCP_TABLE_READER, else
+// the config file's table_reader, else "auto".
+//
+//	MCP_TABLE_READER – "auto", RFC_READ_TABLE, BBP_RFC_READ_TABLE,
+//	                   /BODS/RFC_READ_TABLE2 or an RFC_READ_TABLE-compatible
+//	                   custom function module
+func tableReaderFromEnv(cfg *serverConfig) string {
+	if s := strings.TrimSpace(os.Getenv("MCP_TABLE_READER")); s != "" {
+		return s
+	}
+	if cfg != nil && cfg.TableReader != "" {
+		return cfg.TableReader
+	}
+	return readerAuto
+}
+
+// readerSelector resolves the table reader of one system on first use and
+// remembers it.
+type readerSelector struct {
+	configured string // "auto" or a function module name
+
+	mu     sync.Mutex
+	reader tableReader
+}
+
+// tableReader returns the system's table reader, probing for it on first use.
+func (cm *connManager) tableReader(ctx context.Context) (tableReader, error) {
+	s := &cm.readers
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader != nil {
+		return s.reader, nil
+	}
+
+	candidates := tableReaderCandidates
+	if s.configured != "" && !strings.EqualFold(s.configured, readerAuto) {
+		candidates = []string{strings.ToUpper(s.configured)}
+	}
+	var reasons []string
+	for _, fn := range candidates {
+		if err := cm.policy.check(fn); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		desc, err := cm.describe(ctx, fn)
+		if err != nil {
+			if ctx.Err() != nil || isConnErr(err) {
+				return nil, err
+			}
+			reasons = append(reasons, fmt.Sprintf("%s: %v", fn, err))
+			continue
+		}
+		if fn == readerBODS {
+			s.reader = bodsReadTable{}
+		} else {
+			s.reader = rfcReadTable{function: fn, width: dataRowWidth(desc.Parameters)}
+		}
+		logger.InfoContext(ctx, "table reader selected", "system", cm.system, "function", fn)
+		return s.reader, nil
+	}
+	return nil, errors.New("no table reader available: " + strings.Join(reasons, "; "))
+}
+
+// resolvedTableReader returns the reader's name once it has been determined.
+func (cm *connManager) resolvedTableReader() string {
+	cm.readers.mu.Lock()
+	defer cm.readers.mu.Unlock()
+	if cm.readers.reader == nil {
+		return ""
+	}
+	return cm.readers.reader.name()
+}
+
+// dataRowWidth is the line length of an RFC_READ_TABLE-compatible module's
+// DATA table, falling back to RFC_READ_TABLE's 512 characters.
+func dataRowWidth(params []gorfc.ParameterDescription) int {
+	for _, p := range params {
+		if p.Name == "DATA" && p.TypeDesc.NucLength > 0 {
+			return int(p.TypeDesc.NucLength)
+		}
+	}
+	return readTableRowWidth
+}
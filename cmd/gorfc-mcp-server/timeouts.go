@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Tool timeouts ────────────────────────────────────────────────────────────
+
+// toolTimeouts holds the deadline applied to each tool call. A zero duration
+// means the call only ends when the client cancels it.
+type toolTimeouts struct {
+	def     time.Duration
+	perTool map[string]time.Duration
+}
+
+// toolTimeoutsFromEnv reads tool deadlines from the environment.
+//
+//	MCP_TOOL_TIMEOUT   – default deadline for every tool (default 60s, 0 disables)
+//	MCP_TOOL_TIMEOUTS  – per-tool overrides, e.g. "rfc_call=5m,rfc_ping=10s"
+func toolTimeoutsFromEnv() (toolTimeouts, error) {
+	t := toolTimeouts{def: 60 * time.Second, perTool: map[string]time.Duration{}}
+	if s := os.Getenv("MCP_TOOL_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return t, fmt.Errorf("MCP_TOOL_TIMEOUT: %w", err)
+		}
+		t.def = d
+	}
+	if s := os.Getenv("MCP_TOOL_TIMEOUTS"); s != "" {
+		for _, entry := range strings.Split(s, ",") {
+			name, val, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || name == "" {
+				return t, fmt.Errorf("MCP_TOOL_TIMEOUTS: expected tool=duration, got %q", entry)
+			}
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return t, fmt.Errorf("MCP_TOOL_TIMEOUTS: %s: %w", name, err)
+			}
+			t.perTool[name] = d
+		}
+	}
+	return t, nil
+}
+
+func (t toolTimeouts) forTool(name string) time.Duration {
+	if d, ok := t.perTool[name]; ok {
+		return d
+	}
+	return t.def
+}
+
+// wrap applies the tool's deadline to the context passed to h.
+func (t toolTimeouts) wrap(name string, h mcp.ToolHandler) mcp.ToolHandler {
+	d := t.forTool(name)
+	if d <= 0 {
+		return h
+	}
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, cancel := context.WithTimeoutCause(ctx, d, fmt.Errorf("%s exceeded its %v timeout", name, d))
+		defer cancel()
+		return h(ctx, req)
+	}
+}
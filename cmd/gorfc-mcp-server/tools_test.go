@@ -0,0 +1,2430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	gorfc "github.com/thm-ma/gorfc/gorfc"
+)
+
+// newTestSession serves the tools, and a tool for each of funcTools, against
+// sap over an in-memory transport and returns the connected client session.
+func newTestSession(t *testing.T, sap *fakeSAP, opts registryOptions, funcTools ...functionToolConfig) (*mcp.ClientSession, *systemRegistry) {
+	t.Helper()
+	return newWrappedTestSession(t, sap, opts, nil, funcTools...)
+}
+
+// newWrappedTestSession is newTestSession with each tool handler passed
+// through wrap, as main wraps them; a nil wrap leaves them as they are.
+func newWrappedTestSession(t *testing.T, sap *fakeSAP, opts registryOptions, wrap func(string, mcp.ToolHandler) mcp.ToolHandler, funcTools ...functionToolConfig) (*mcp.ClientSession, *systemRegistry) {
+	t.Helper()
+	opts.Dial = sap.dial
+	if opts.Pool == (poolConfig{}) {
+		opts.Pool = defaultPoolConfig()
+	}
+	systems, err := newSystemRegistry([]systemConfig{{Name: "FAK", Dest: "FAK"}}, "", opts)
+	if err != nil {
+		t.Fatalf("newSystemRegistry: %v", err)
+	}
+	systems.connectAll()
+	t.Cleanup(systems.close)
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0"}, nil)
+	addTool := server.AddTool
+	if wrap != nil {
+		addTool = func(tool *mcp.Tool, h mcp.ToolHandler) { server.AddTool(tool, wrap(tool.Name, h)) }
+	}
+	m := newMetrics()
+	registerTools(addTool, systems, m)
+	registerFunctionTools(context.Background(), addTool, systems, m, funcTools, map[string]bool{"rfc_call": true})
+
+	ctx := context.Background()
+	st, ct := mcp.NewInMemoryTransports()
+	if _, err := server.Connect(ctx, st, nil); err != nil {
+		t.Fatalf("server connect: %v", err)
+	}
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "0"}, nil)
+	cs, err := client.Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatalf("client connect: %v", err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	return cs, systems
+}
+
+// callTool calls a tool and returns its first text content and error flag.
+func callTool(t *testing.T, cs *mcp.ClientSession, name string, args map[string]interface{}) (string, bool) {
+	t.Helper()
+	res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: name, Arguments: args})
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if len(res.Content) == 0 {
+		t.Fatalf("%s: empty result", name)
+	}
+	text, ok := res.Content[0].(*mcp.TextContent)
+	if !ok {
+		t.Fatalf("%s: content is %T, want text", name, res.Content[0])
+	}
+	return text.Text, res.IsError
+}
+
+// callToolJSON calls a tool that must succeed and decodes its JSON result.
+func callToolJSON(t *testing.T, cs *mcp.ClientSession, name string, args map[string]interface{}, v interface{}) {
+	t.Helper()
+	text, isErr := callTool(t, cs, name, args)
+	if isErr {
+		t.Fatalf("%s failed: %s", name, text)
+	}
+	if err := json.Unmarshal([]byte(text), v); err != nil {
+		t.Fatalf("%s: decode %q: %v", name, text, err)
+	}
+}
+
+// ── tool surface ──────────────────────────────────────────────────────────────
+
+func TestToolsList(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	res, err := cs.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools: %v", err)
+	}
+	var got []string
+	for _, tool := range res.Tools {
+		got = append(got, tool.Name)
+	}
+	sort.Strings(got)
+	want := []string{
+		"begin_session", "cache_invalidate", "commit", "end_session", "get_table_metadata",
+		"get_table_relations", "list_systems", "metrics_get", "read_table", "rfc_call",
+		"rfc_connection_info", "rfc_describe", "rfc_ping", "rollback", "search_sap_tables",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tools = %v, want %v", got, want)
+	}
+}
+
+func TestToolPing(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	if text, isErr := callTool(t, cs, "rfc_ping", nil); isErr || !strings.Contains(text, "PONG") {
+		t.Errorf("rfc_ping = %q (error %t)", text, isErr)
+	}
+	if text, isErr := callTool(t, cs, "rfc_ping", map[string]interface{}{"system": "NOPE"}); !isErr || !strings.Contains(text, "unknown system") {
+		t.Errorf("rfc_ping on unknown system = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolConnectionInfo(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	var info struct {
+		System     string            `json:"system"`
+		Connection map[string]string `json:"connection"`
+	}
+	callToolJSON(t, cs, "rfc_connection_info", nil, &info)
+	if info.System != "FAK" || info.Connection["sysId"] != "FAK" || info.Connection["client"] != "100" {
+		t.Errorf("rfc_connection_info = %+v", info)
+	}
+}
+
+func TestToolDescribe(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	var desc struct {
+		gorfc.FunctionDescription
+		Input  map[string]interface{} `json:"input_schema"`
+		Output map[string]interface{} `json:"output_schema"`
+	}
+	callToolJSON(t, cs, "rfc_describe", map[string]interface{}{"function_name": "stfc_connection"}, &desc)
+	if desc.Name != "STFC_CONNECTION" || len(desc.Parameters) != 3 {
+		t.Errorf("rfc_describe = %+v", desc)
+	}
+	in, _ := desc.Input["properties"].(map[string]interface{})
+	out, _ := desc.Output["properties"].(map[string]interface{})
+	if len(in) != 1 || in["REQUTEXT"] == nil || len(out) != 2 || out["ECHOTEXT"] == nil {
+		t.Errorf("input_schema = %v, output_schema = %v", desc.Input, desc.Output)
+	}
+	if text, isErr := callTool(t, cs, "rfc_describe", map[string]interface{}{"function_name": "Z_MISSING"}); !isErr || !strings.Contains(text, "FU_NOT_FOUND") {
+		t.Errorf("rfc_describe Z_MISSING = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolCall(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	var out map[string]interface{}
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "STFC_CONNECTION",
+		"parameters":    map[string]interface{}{"requtext": "hello"},
+	}, &out)
+	if out["ECHOTEXT"] != "hello" {
+		t.Errorf("ECHOTEXT = %v, want hello", out["ECHOTEXT"])
+	}
+
+	text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "STFC_CONNECTION",
+		"parameters":    map[string]interface{}{"NOPE": "x"},
+	})
+	if !isErr || !strings.Contains(text, "unknown parameter") {
+		t.Errorf("rfc_call with unknown parameter = %q (error %t)", text, isErr)
+	}
+
+	text, isErr = callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "STFC_CONNECTION",
+		"parameters":    map[string]interface{}{"REQUTEXT": strings.Repeat("x", 256)},
+	})
+	if !isErr || !strings.Contains(text, "maxLength") {
+		t.Errorf("rfc_call with overlong parameter = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolCallErrorContent(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "get_table_metadata", Arguments: map[string]interface{}{"table_name": "ZNOPE"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(res.StructuredContent)
+	var info rfcErrorInfo
+	json.Unmarshal(b, &info)
+	if !res.IsError || info.Class != errABAPException || info.Code != "RFC_ABAP_EXCEPTION" || info.Key != "NOT_FOUND" || info.Group != "ABAP_APPLICATION_FAILURE" {
+		t.Errorf("structured content = %s (error %t)", b, res.IsError)
+	}
+}
+
+func TestToolCallPolicy(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_DELETE_ALL"}, nil)
+	cs, _ := newTestSession(t, sap, registryOptions{Policy: &callPolicy{Deny: []string{"Z_DELETE*"}}})
+
+	text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{"function_name": "Z_DELETE_ALL"})
+	if !isErr || !strings.Contains(text, "rejected by policy") {
+		t.Errorf("rfc_call Z_DELETE_ALL = %q (error %t)", text, isErr)
+	}
+	for _, fn := range sap.called() {
+		if fn == "Z_DELETE_ALL" {
+			t.Error("rejected function module reached SAP")
+		}
+	}
+	var snap struct {
+		Rejected struct {
+			Total int `json:"total"`
+		} `json:"rejected"`
+	}
+	callToolJSON(t, cs, "metrics_get", nil, &snap)
+	if snap.Rejected.Total != 1 {
+		t.Errorf("rejected.total = %d, want 1", snap.Rejected.Total)
+	}
+}
+
+func TestToolCallBAPIReturn(t *testing.T) {
+	sap := newFakeSAP()
+	ret := tableParam("RETURN", "BAPIRET2", 0, "TYPE", "ID", "NUMBER", "MESSAGE")
+	sap.addFunction(gorfc.FunctionDescription{Name: "BAPI_MATERIAL_SAVEDATA", Parameters: []gorfc.ParameterDescription{
+		charParam("MATERIAL", "RFC_IMPORT", 18), ret,
+	}}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		if params["MATERIAL"] == "OK" {
+			return map[string]interface{}{"RETURN": []interface{}{
+				map[string]interface{}{"TYPE": "S", "ID": "MM", "NUMBER": "356", "MESSAGE": "Material OK changed"},
+			}}, nil
+		}
+		return map[string]interface{}{"RETURN": []interface{}{
+			map[string]interface{}{"TYPE": "W", "ID": "MM", "NUMBER": "001", "MESSAGE": "Plant data incomplete"},
+			map[string]interface{}{"TYPE": "E", "ID": "M3", "NUMBER": "305", "MESSAGE": "Material X does not exist"},
+		}}, nil
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+
+	call := func(material string) *mcp.CallToolResult {
+		res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "rfc_call", Arguments: map[string]interface{}{
+			"function_name": "BAPI_MATERIAL_SAVEDATA",
+			"parameters":    map[string]interface{}{"MATERIAL": material},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res := call("X")
+	if !res.IsError || len(res.Content) != 2 {
+		t.Fatalf("rfc_call with an E message: error %t, %d contents, want an error with result and summary", res.IsError, len(res.Content))
+	}
+	summary := res.Content[1].(*mcp.TextContent).Text
+	want := "BAPI messages: 1 error, 1 warning\nE M3/305: Material X does not exist\nW MM/001: Plant data incomplete"
+	if summary != want {
+		t.Errorf("summary = %q, want %q", summary, want)
+	}
+	if res = call("OK"); res.IsError || len(res.Content) != 2 {
+		t.Errorf("rfc_call with an S message: error %t, %d contents, want success with summary", res.IsError, len(res.Content))
+	}
+
+	var snap struct {
+		Failure int            `json:"failure"`
+		BAPI    map[string]int `json:"bapi_messages"`
+	}
+	callToolJSON(t, cs, "metrics_get", nil, &snap)
+	if snap.Failure != 1 || !reflect.DeepEqual(snap.BAPI, map[string]int{"E": 1, "W": 1, "S": 1}) {
+		t.Errorf("metrics failure = %d, bapi_messages = %v", snap.Failure, snap.BAPI)
+	}
+}
+
+func TestToolCallDecimals(t *testing.T) {
+	sap := newFakeSAP()
+	var got interface{}
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_AMOUNT", Parameters: []gorfc.ParameterDescription{
+		{Name: "AMOUNT", ParameterType: "RFCTYPE_BCD", Direction: "RFC_IMPORT", NucLength: 8, Decimals: 2},
+		{Name: "TOTAL", ParameterType: "RFCTYPE_BCD", Direction: "RFC_EXPORT", NucLength: 8, Decimals: 2},
+		{Name: "COUNT", ParameterType: "RFCTYPE_INT8", Direction: "RFC_EXPORT", NucLength: 8},
+	}}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		got = params["AMOUNT"]
+		return map[string]interface{}{"TOTAL": "12345678901.23", "COUNT": int64(9007199254740993)}, nil
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+
+	// Numbers are passed on with every digit, padded to the declared decimals.
+	text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "Z_AMOUNT",
+		"parameters":    map[string]interface{}{"AMOUNT": json.Number("12345678901.2")},
+	})
+	if isErr || got != "12345678901.20" {
+		t.Fatalf("AMOUNT = %#v, result %q (error %t)", got, text, isErr)
+	}
+	if want := `"TOTAL": "12345678901.23"`; !strings.Contains(text, want) || !strings.Contains(text, `"COUNT": "9007199254740993"`) {
+		t.Errorf("default output = %s", text)
+	}
+	text, _ = callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "Z_AMOUNT",
+		"parameters":    map[string]interface{}{"AMOUNT": "1"},
+		"output":        map[string]interface{}{"decimals": "number"},
+	})
+	if !strings.Contains(text, `"TOTAL": 12345678901.23`) || !strings.Contains(text, `"COUNT": 9007199254740993`) {
+		t.Errorf("output with decimals=number = %s", text)
+	}
+
+	text, isErr = callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "Z_AMOUNT",
+		"parameters":    map[string]interface{}{"AMOUNT": json.Number("1.005")},
+	})
+	if !isErr || !strings.Contains(text, "more than 2 decimal places") {
+		t.Errorf("rfc_call with 3 decimals = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolCallOutput(t *testing.T) {
+	sap := newFakeSAP()
+	address := gorfc.TypeDescription{Name: "ZADDR", Fields: []gorfc.FieldDescription{
+		{Name: "STREET", FieldType: "RFCTYPE_CHAR"},
+		{Name: "CITY", FieldType: "RFCTYPE_CHAR"},
+		{Name: "PSTLZ", FieldType: "RFCTYPE_CHAR"},
+	}}
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_CUSTOMER", Parameters: []gorfc.ParameterDescription{
+		charParam("KUNNR", "RFC_EXPORT", 10),
+		charParam("NAME", "RFC_EXPORT", 35),
+		{Name: "POSNR", ParameterType: "RFCTYPE_NUM", Direction: "RFC_EXPORT", NucLength: 6},
+		{Name: "COUNT", ParameterType: "RFCTYPE_INT", Direction: "RFC_EXPORT", NucLength: 4},
+		{Name: "FLAG", ParameterType: "RFCTYPE_INT1", Direction: "RFC_EXPORT", NucLength: 1},
+		{Name: "PRIO", ParameterType: "RFCTYPE_INT2", Direction: "RFC_EXPORT", NucLength: 2},
+		{Name: "ERDAT", ParameterType: "RFCTYPE_DATE", Direction: "RFC_EXPORT", NucLength: 8},
+		{Name: "ERZET", ParameterType: "RFCTYPE_TIME", Direction: "RFC_EXPORT", NucLength: 6},
+		{Name: "LAEDA", ParameterType: "RFCTYPE_DATE", Direction: "RFC_EXPORT", NucLength: 8},
+		{Name: "ADDRESS", ParameterType: "RFCTYPE_STRUCTURE", Direction: "RFC_EXPORT", TypeDesc: address},
+		{Name: "ADDRESSES", ParameterType: "RFCTYPE_TABLE", Direction: "RFC_TABLES", TypeDesc: address},
+		{Name: "BLOCKS", ParameterType: "RFCTYPE_TABLE", Direction: "RFC_TABLES", TypeDesc: address},
+	}}, func(map[string]interface{}) (map[string]interface{}, error) {
+		addr := map[string]interface{}{"STREET": "", "CITY": " Dresden", "PSTLZ": "01067"}
+		return map[string]interface{}{
+			"KUNNR":     "0000004711",
+			"NAME":      "  Miller",
+			"POSNR":     "000010",
+			"COUNT":     int32(0), // gorfc's types for INT, INT1 and INT2
+			"FLAG":      uint8(0),
+			"PRIO":      int16(3),
+			"ERDAT":     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			"ERZET":     time.Date(0, 1, 1, 13, 5, 9, 0, time.UTC),
+			"LAEDA":     nil,
+			"ADDRESS":   addr,
+			"ADDRESSES": []interface{}{addr},
+			"BLOCKS":    []interface{}{},
+		}, nil
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+	// Every rendering matches the output_schema rfc_describe gives for it.
+	checkSchema := func(output map[string]interface{}, out map[string]interface{}) *jsonschema.Schema {
+		t.Helper()
+		var desc struct {
+			Output *jsonschema.Schema `json:"output_schema"`
+		}
+		callToolJSON(t, cs, "rfc_describe", map[string]interface{}{"function_name": "Z_CUSTOMER", "output": output}, &desc)
+		rs, err := desc.Output.Resolve(nil)
+		if err != nil {
+			t.Fatalf("resolve output_schema: %v", err)
+		}
+		if err := rs.Validate(out); err != nil {
+			t.Errorf("output %v does not match its output_schema: %v", output, err)
+		}
+		return desc.Output
+	}
+
+	var out map[string]interface{}
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{"function_name": "Z_CUSTOMER"}, &out)
+	if out["KUNNR"] != "0000004711" || out["ERDAT"] != "2024-03-01T00:00:00Z" || len(out) != 12 {
+		t.Errorf("raw output = %v", out)
+	}
+	checkSchema(nil, out)
+
+	out = nil
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "Z_CUSTOMER",
+		"output":        map[string]interface{}{"profile": "compact", "alpha": true},
+	}, &out)
+	// alpha strips only NUMC values: a CHAR value of digits may be a postal
+	// code.
+	want := map[string]interface{}{
+		"KUNNR":     "0000004711",
+		"NAME":      "Miller",
+		"POSNR":     "10",
+		"PRIO":      3.0,
+		"ERDAT":     "2024-03-01",
+		"ERZET":     "13:05:09",
+		"ADDRESS":   map[string]interface{}{"CITY": "Dresden", "PSTLZ": "01067"},
+		"ADDRESSES": map[string]interface{}{"CITY": "Dresden", "PSTLZ": "01067"},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("compact output = %v, want %v", out, want)
+	}
+	// The validator does not check formats, so the date schema is checked
+	// itself.
+	if erdat := checkSchema(map[string]interface{}{"profile": "compact", "alpha": true}, out).Properties["ERDAT"]; erdat.Format != "" || erdat.Pattern == "" {
+		t.Errorf("compact ERDAT schema = %+v, want a YYYY-MM-DD pattern", erdat)
+	}
+
+	out = nil
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "Z_CUSTOMER",
+		"output":        map[string]interface{}{"profile": "compact", "flatten": false},
+	}, &out)
+	if out["KUNNR"] != "0000004711" || reflect.TypeOf(out["ADDRESSES"]) != reflect.TypeOf([]interface{}{}) {
+		t.Errorf("compact output without flatten = %v", out)
+	}
+	checkSchema(map[string]interface{}{"profile": "compact", "flatten": false}, out)
+
+	text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "Z_CUSTOMER",
+		"output":        map[string]interface{}{"profile": "short"},
+	})
+	if !isErr || !strings.Contains(text, "profile must be") {
+		t.Errorf("rfc_call with unknown profile = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolCallResultView(t *testing.T) {
+	sap := newFakeSAP()
+	items := gorfc.TypeDescription{Name: "ZITEM", Fields: []gorfc.FieldDescription{
+		{Name: "MATNR", FieldType: "RFCTYPE_CHAR"},
+		{Name: "MENGE", FieldType: "RFCTYPE_BCD", Decimals: 3},
+		{Name: "ERDAT", FieldType: "RFCTYPE_DATE"},
+	}}
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_ORDER", Parameters: []gorfc.ParameterDescription{
+		charParam("VBELN", "RFC_EXPORT", 10),
+		{Name: "HEADER", ParameterType: "RFCTYPE_STRUCTURE", Direction: "RFC_EXPORT", TypeDesc: items},
+		{Name: "ITEMS", ParameterType: "RFCTYPE_TABLE", Direction: "RFC_TABLES", TypeDesc: items},
+		tableParam("RETURN", "BAPIRET2", 0, "TYPE", "MESSAGE"),
+	}}, func(map[string]interface{}) (map[string]interface{}, error) {
+		item := func(matnr, menge string, day int) interface{} {
+			return map[string]interface{}{"MATNR": matnr, "MENGE": menge, "ERDAT": time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC)}
+		}
+		return map[string]interface{}{
+			"VBELN":  "4711",
+			"HEADER": item("M-01", "1.000", 1),
+			"ITEMS":  []interface{}{item("M-01", "10.000", 1), item("M-02", "2.500", 2), item("X-03", "7.000", 3), item("M-04", "12.000", 4)},
+			"RETURN": []interface{}{map[string]interface{}{"TYPE": "W", "MESSAGE": "Credit limit reached"}},
+		}, nil
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+
+	res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "rfc_call", Arguments: map[string]interface{}{
+		"function_name": "Z_ORDER",
+		"fields":        []string{"header.matnr", "ITEMS.MATNR", "ITEMS.MENGE"},
+		"filters": []map[string]interface{}{
+			{"table": "ITEMS", "field": "MATNR", "op": "like", "value": "M-%"},
+			{"table": "ITEMS", "field": "MENGE", "op": "ge", "value": json.Number("2.5")},
+			{"table": "ITEMS", "field": "ERDAT", "op": "between", "value": []string{"2024-03-01", "20240304"}},
+		},
+		"max_rows": 2,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError || len(res.Content) != 3 {
+		t.Fatalf("rfc_call: error %t, %d contents, want success with BAPI summary and note", res.IsError, len(res.Content))
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(res.Content[0].(*mcp.TextContent).Text), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"HEADER": map[string]interface{}{"MATNR": "M-01"},
+		"ITEMS": []interface{}{
+			map[string]interface{}{"MATNR": "M-01", "MENGE": "10.000"},
+			map[string]interface{}{"MATNR": "M-02", "MENGE": "2.500"},
+		},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("result = %v, want %v", out, want)
+	}
+	note := res.Content[2].(*mcp.TextContent).Text
+	wantNote := "Omitted from the result:\nparameters not in fields: RETURN, VBELN\nITEMS: 2 of 4 rows returned (1 not matching the filters, 1 beyond max_rows)"
+	if note != wantNote {
+		t.Errorf("note = %q, want %q", note, wantNote)
+	}
+	if summary := res.Content[1].(*mcp.TextContent).Text; !strings.Contains(summary, "Credit limit reached") {
+		t.Errorf("BAPI summary of an omitted RETURN = %q", summary)
+	}
+
+	for _, tt := range []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"fields": []string{"ITEMS.NOPE"}}, `ITEMS has no field "NOPE"`},
+		{map[string]interface{}{"fields": []string{"VBELN.X"}}, "VBELN is not a structure or table"},
+		{map[string]interface{}{"filters": []map[string]interface{}{{"table": "HEADER", "field": "MATNR", "op": "eq", "value": "x"}}}, `no table parameter "HEADER"`},
+		{map[string]interface{}{"filters": []map[string]interface{}{{"table": "ITEMS", "field": "MATNR", "op": "in", "value": "x"}}}, "IN needs an array value"},
+	} {
+		tt.args["function_name"] = "Z_ORDER"
+		if text, isErr := callTool(t, cs, "rfc_call", tt.args); !isErr || !strings.Contains(text, tt.want) {
+			t.Errorf("rfc_call with %v = %q (error %t), want %q", tt.args, text, isErr, tt.want)
+		}
+	}
+}
+
+func TestToolDescribeSnapshot(t *testing.T) {
+	sap := newFakeSAP()
+	opts := registryOptions{Snapshots: &snapshotStore{dir: t.TempDir(), index: map[string]string{}}}
+	describe := func(cs *mcp.ClientSession) (params int, note string) {
+		t.Helper()
+		res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "rfc_describe", Arguments: map[string]interface{}{"function_name": "STFC_CONNECTION"}})
+		if err != nil || res.IsError {
+			t.Fatalf("rfc_describe = %+v, %v", res, err)
+		}
+		var desc gorfc.FunctionDescription
+		if err := json.Unmarshal([]byte(res.Content[0].(*mcp.TextContent).Text), &desc); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Content) > 1 {
+			note = res.Content[1].(*mcp.TextContent).Text
+		}
+		return len(desc.Parameters), note
+	}
+	cs, _ := newTestSession(t, sap, opts)
+	if n, note := describe(cs); n != 3 || note != "" {
+		t.Fatalf("first rfc_describe: %d parameters, note %q", n, note)
+	}
+
+	// Without a maximum age, a reachable system is asked even though a
+	// snapshot exists.
+	desc := sap.functions["STFC_CONNECTION"].desc
+	desc.Parameters = append(desc.Parameters[:len(desc.Parameters):len(desc.Parameters)], charParam("NEWPARAM", "RFC_IMPORT", 10))
+	sap.addFunction(desc, nil)
+	cs, _ = newTestSession(t, sap, opts)
+	if n, note := describe(cs); n != 4 || note != "" {
+		t.Errorf("rfc_describe with SAP reachable: %d parameters, note %q; want the live interface", n, note)
+	}
+
+	// A system unreachable at startup is served from the snapshot.
+	sap.failNext(1000)
+	cs, _ = newTestSession(t, sap, opts)
+	if n, note := describe(cs); n != 4 || !strings.Contains(note, "snapshot") {
+		t.Errorf("rfc_describe with SAP unreachable: %d parameters, note %q; want the snapshot", n, note)
+	}
+	sap.failNext(0)
+	if text, isErr := callTool(t, cs, "rfc_ping", nil); isErr {
+		t.Errorf("rfc_ping after SAP came back = %q", text)
+	}
+}
+
+func TestSnapshotReusedAfterRestart(t *testing.T) {
+	st := &snapshotStore{dir: t.TempDir(), maxAge: time.Hour, index: map[string]string{}}
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{Snapshots: st})
+	var desc gorfc.FunctionDescription
+	callToolJSON(t, cs, "rfc_describe", map[string]interface{}{"function_name": "STFC_CONNECTION"}, &desc)
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "T000", "limit": 1}, &readTableResult{})
+
+	// After a restart, the system answers no describe or DDIC requests.
+	mute := func(client string) *fakeSAP {
+		sap := newFakeSAP()
+		sap.attrs["client"] = client
+		delete(sap.functions, "STFC_CONNECTION")
+		delete(sap.functions, "DDIF_FIELDINFO_GET")
+		return sap
+	}
+	sap := mute("100")
+	cs, _ = newTestSession(t, sap, registryOptions{Snapshots: st})
+	res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "rfc_describe", Arguments: map[string]interface{}{"function_name": "STFC_CONNECTION"}})
+	if err != nil || res.IsError || len(res.Content) != 1 {
+		t.Fatalf("rfc_describe after restart = %+v, %v; want the snapshot without a note", res, err)
+	}
+	if !strings.Contains(res.Content[0].(*mcp.TextContent).Text, "REQUTEXT") {
+		t.Errorf("rfc_describe after restart = %s", res.Content[0].(*mcp.TextContent).Text)
+	}
+	table := &readTableResult{}
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "T000", "fields": []interface{}{"MANDT"}}, table)
+	if table.RowCount != 3 {
+		t.Errorf("read_table after restart = %d rows, want 3", table.RowCount)
+	}
+	for _, fn := range sap.called() {
+		if fn == "DDIF_FIELDINFO_GET" {
+			t.Error("DDIF_FIELDINFO_GET called although a current snapshot exists")
+		}
+	}
+
+	// cache_invalidate drops the snapshot, so SAP is asked again.
+	callTool(t, cs, "cache_invalidate", map[string]interface{}{"kind": "function", "name": "STFC_CONNECTION"})
+	if text, isErr := callTool(t, cs, "rfc_describe", map[string]interface{}{"function_name": "STFC_CONNECTION"}); !isErr || !strings.Contains(text, "FU_NOT_FOUND") {
+		t.Errorf("rfc_describe after cache_invalidate = %q (error %t), want SAP asked", text, isErr)
+	}
+
+	// Snapshots of another client or beyond the maximum age are not used.
+	for name, opts := range map[string]struct {
+		client string
+		maxAge time.Duration
+	}{"other client": {"200", time.Hour}, "expired": {"100", time.Nanosecond}} {
+		st2 := &snapshotStore{dir: st.dir, maxAge: opts.maxAge, index: map[string]string{}}
+		cs, _ = newTestSession(t, mute(opts.client), registryOptions{Snapshots: st2})
+		if text, isErr := callTool(t, cs, "read_table", map[string]interface{}{"table_name": "T000"}); !isErr || !strings.Contains(text, "DDIF_FIELDINFO_GET not found") {
+			t.Errorf("%s: read_table = %s (error %t), want SAP asked", name, text, isErr)
+		}
+	}
+}
+
+func TestToolCallRetriesCommunicationFailure(t *testing.T) {
+	sap := newFakeSAP()
+	cs, _ := newTestSession(t, sap, registryOptions{})
+	sap.failNext(1)
+
+	var out map[string]interface{}
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "STFC_CONNECTION",
+		"parameters":    map[string]interface{}{"REQUTEXT": "again"},
+	}, &out)
+	if out["ECHOTEXT"] != "again" {
+		t.Errorf("ECHOTEXT = %v, want again", out["ECHOTEXT"])
+	}
+
+	sap.failNext(100)
+	text, isErr := callTool(t, cs, "rfc_ping", nil)
+	if !isErr || !strings.Contains(text, "RFC_COMMUNICATION_FAILURE") {
+		t.Errorf("rfc_ping with a dead system = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolTimeouts(t *testing.T) {
+	t.Setenv("MCP_TOOL_TIMEOUT", "1h")
+	t.Setenv("MCP_TOOL_TIMEOUTS", "rfc_call=50ms, rfc_ping=0")
+	timeouts, err := toolTimeoutsFromEnv()
+	if err != nil {
+		t.Fatalf("toolTimeoutsFromEnv: %v", err)
+	}
+	for tool, want := range map[string]time.Duration{"rfc_call": 50 * time.Millisecond, "rfc_ping": 0, "list_systems": time.Hour} {
+		if got := timeouts.forTool(tool); got != want {
+			t.Errorf("forTool(%s) = %v, want %v", tool, got, want)
+		}
+	}
+	for env, val := range map[string]string{"MCP_TOOL_TIMEOUT": "soon", "MCP_TOOL_TIMEOUTS": "rfc_call"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, val)
+			if _, err := toolTimeoutsFromEnv(); err == nil || !strings.Contains(err.Error(), env) {
+				t.Errorf("%s=%q: err = %v, want it reported", env, val, err)
+			}
+		})
+	}
+
+	sap := newFakeSAP()
+	release := make(chan struct{})
+	defer close(release)
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_SLOW"}, func(map[string]interface{}) (map[string]interface{}, error) {
+		<-release
+		return map[string]interface{}{}, nil
+	})
+	cs, _ := newWrappedTestSession(t, sap, registryOptions{}, timeouts.wrap)
+
+	start := time.Now()
+	res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "rfc_call", Arguments: map[string]interface{}{"function_name": "Z_SLOW"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rfc_call returned after %v, want its 50ms timeout", elapsed)
+	}
+	b, _ := json.Marshal(res.StructuredContent)
+	var info rfcErrorInfo
+	json.Unmarshal(b, &info)
+	if !res.IsError || info.Class != errCancelled {
+		t.Errorf("structured content = %s (error %t), want class %s", b, res.IsError, errCancelled)
+	}
+	if text := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "timed out") || !strings.Contains(text, "rfc_call exceeded its 50ms timeout") {
+		t.Errorf("text = %q, want the timeout and its cause", text)
+	}
+
+	// rfc_ping has no deadline and the default does not apply to it.
+	if text, isErr := callTool(t, cs, "rfc_ping", nil); isErr {
+		t.Errorf("rfc_ping = %q, want success", text)
+	}
+}
+
+func TestToolTableMetadata(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{Cache: newMetadataCache(cacheConfig{TTL: time.Minute, MaxSize: 10})})
+	var out struct {
+		DFIES []map[string]interface{} `json:"DFIES_TAB"`
+	}
+	for i := 0; i < 2; i++ {
+		callToolJSON(t, cs, "get_table_metadata", map[string]interface{}{"table_name": "t000"}, &out)
+	}
+	if len(out.DFIES) != 3 || out.DFIES[0]["FIELDNAME"] != "MANDT" {
+		t.Errorf("DFIES_TAB = %v", out.DFIES)
+	}
+
+	var snap struct {
+		Cache struct {
+			Hits   int `json:"hits"`
+			Misses int `json:"misses"`
+		} `json:"cache"`
+	}
+	callToolJSON(t, cs, "metrics_get", nil, &snap)
+	if snap.Cache.Hits != 1 || snap.Cache.Misses != 1 {
+		t.Errorf("cache hits/misses = %d/%d, want 1/1", snap.Cache.Hits, snap.Cache.Misses)
+	}
+
+	var inv struct {
+		Removed int `json:"removed"`
+	}
+	callToolJSON(t, cs, "cache_invalidate", map[string]interface{}{"kind": "table", "name": "T0*"}, &inv)
+	if inv.Removed != 1 {
+		t.Errorf("cache_invalidate removed %d, want 1", inv.Removed)
+	}
+}
+
+func TestToolTableRelations(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addFunction(gorfc.FunctionDescription{Name: "FAPI_GET_FOREIGN_KEY_RELATIONS"}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"TABNAME": params["TABNAME"], "RELATIONS": []interface{}{}}, nil
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+	var out map[string]interface{}
+	callToolJSON(t, cs, "get_table_relations", map[string]interface{}{"table_name": "sflight"}, &out)
+	if out["TABNAME"] != "SFLIGHT" {
+		t.Errorf("TABNAME = %v, want SFLIGHT", out["TABNAME"])
+	}
+}
+
+func TestToolSearchTables(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	var rows []map[string]string
+	callToolJSON(t, cs, "search_sap_tables", map[string]interface{}{"search_term": "%Material%", "language": "E"}, &rows)
+	var got []string
+	for _, r := range rows {
+		got = append(got, r["TABNAME"])
+	}
+	if !reflect.DeepEqual(got, []string{"MARA", "MAKT"}) {
+		t.Errorf("tables = %v, want [MARA MAKT]", got)
+	}
+}
+
+func TestToolReadTable(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	var res readTableResult
+	callToolJSON(t, cs, "read_table", map[string]interface{}{
+		"table_name": "T000",
+		"fields":     []string{"MANDT", "MTEXT"},
+		"filters":    []map[string]interface{}{{"field": "MANDT", "op": "in", "value": []string{"000", "100"}}},
+		"order_by":   []string{"MANDT DESC"},
+		"limit":      1,
+	}, &res)
+	if res.Reader != "RFC_READ_TABLE" {
+		t.Errorf("reader = %q, want RFC_READ_TABLE", res.Reader)
+	}
+	if res.RowCount != 1 || !res.HasMore || res.NextSkip != 1 {
+		t.Fatalf("row_count/has_more/next_skip = %d/%t/%d, want 1/true/1", res.RowCount, res.HasMore, res.NextSkip)
+	}
+	if res.Rows[0]["MANDT"] != "000" || res.Rows[0]["MTEXT"] != "SAP AG" {
+		t.Errorf("row = %v", res.Rows[0])
+	}
+
+	text, isErr := callTool(t, cs, "read_table", map[string]interface{}{
+		"table_name": "T000",
+		"filters":    []map[string]interface{}{{"field": "NOPE", "op": "eq", "value": "x"}},
+	})
+	if !isErr || !strings.Contains(text, "unknown field") {
+		t.Errorf("read_table with unknown filter field = %q (error %t)", text, isErr)
+	}
+}
+
+func TestToolReadTableSplitsWideRows(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addTable("ZWIDE", []fakeField{
+		{name: "ID", intType: "N", length: 10, key: true},
+		{name: "LONG1", intType: "C", length: 300},
+		{name: "LONG2", intType: "C", length: 300},
+		{name: "AMOUNT", intType: "P", length: 13, outputLen: 17, decimals: 2},
+	}, [][]string{
+		{"0000000001", "first", "one", "12.50-"},
+		{"0000000002", "second", "two", "3.00"},
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+
+	var res readTableResult
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "ZWIDE"}, &res)
+	if res.ColumnChunks != 2 {
+		t.Errorf("column_chunks = %d, want 2", res.ColumnChunks)
+	}
+	if res.RowCount != 2 {
+		t.Fatalf("row_count = %d, want 2", res.RowCount)
+	}
+	row := res.Rows[0]
+	if row["ID"] != "0000000001" || row["LONG1"] != "first" || row["LONG2"] != "one" || row["AMOUNT"] != "-12.50" {
+		t.Errorf("row = %v", row)
+	}
+
+	// The reader has no ORDER BY: after the first chunk, the table returns
+	// its rows in another order. The further chunk is read by key, so the
+	// page still joins.
+	read := sap.functions["RFC_READ_TABLE"]
+	orig := read.call
+	changeAfterFirst := func(change func(*fakeTable)) {
+		calls := 0
+		read.call = func(params map[string]interface{}) (map[string]interface{}, error) {
+			res, err := orig(params)
+			if calls++; calls == 1 {
+				sap.mu.Lock()
+				change(sap.tables["ZWIDE"])
+				sap.mu.Unlock()
+			}
+			return res, err
+		}
+	}
+	changeAfterFirst(func(tb *fakeTable) { tb.rows[0], tb.rows[1] = tb.rows[1], tb.rows[0] })
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "ZWIDE", "fields": []string{"LONG1", "LONG2"}, "skip": 1}, &res)
+	if len(res.Rows) != 1 || res.Rows[0]["LONG1"] != "second" || res.Rows[0]["LONG2"] != "two" {
+		t.Errorf("rows = %v, want the second row joined", res.Rows)
+	}
+
+	// A row deleted between the chunks fails the read.
+	changeAfterFirst(func(tb *fakeTable) { tb.rows = tb.rows[1:] })
+	if text, isErr := callTool(t, cs, "read_table", map[string]interface{}{"table_name": "ZWIDE"}); !isErr || !strings.Contains(text, "changed while its column chunks were read") {
+		t.Errorf("read_table = %s (error %t), want the changed table reported", text, isErr)
+	}
+}
+
+func TestToolReadTableBODS(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addBODSReader()
+	sap.addTable("ZWIDE", []fakeField{
+		{name: "ID", intType: "N", length: 10, key: true},
+		{name: "LONG1", intType: "C", length: 300},
+		{name: "LONG2", intType: "C", length: 300},
+		{name: "AMOUNT", intType: "P", length: 17, decimals: 2},
+	}, [][]string{
+		{"0000000001", "first", "one", "12.50-"},
+		{"0000000002", "second", "two", "3.00"},
+	})
+	var outTables []interface{}
+	bods := sap.functions[readerBODS]
+	read := bods.call
+	bods.call = func(params map[string]interface{}) (map[string]interface{}, error) {
+		res, err := read(params)
+		outTables = append(outTables, res["OUT_TABLE"])
+		return res, err
+	}
+	cs, _ := newTestSession(t, sap, registryOptions{})
+
+	// The 627-character rows fit into TBLOUT2048 without column chunks.
+	var res readTableResult
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "ZWIDE", "order_by": []interface{}{"ID DESC"}}, &res)
+	if res.Reader != readerBODS || res.ColumnChunks != 0 || res.RowCount != 2 {
+		t.Fatalf("reader/column_chunks/row_count = %s/%d/%d, want %s/0/2", res.Reader, res.ColumnChunks, res.RowCount, readerBODS)
+	}
+	want := map[string]interface{}{"ID": "0000000002", "LONG1": "second", "LONG2": "two", "AMOUNT": "3.00"}
+	if !reflect.DeepEqual(res.Rows[0], want) {
+		t.Errorf("row = %v, want %v", res.Rows[0], want)
+	}
+	if res.Rows[1]["AMOUNT"] != "-12.50" {
+		t.Errorf("AMOUNT = %v, want -12.50", res.Rows[1]["AMOUNT"])
+	}
+
+	// Narrow rows come in TBLOUT128.
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "T000", "fields": []interface{}{"MANDT", "ORT01"}, "skip": 2}, &res)
+	if res.RowCount != 1 || res.Rows[0]["MANDT"] != "100" || res.Rows[0]["ORT01"] != "Berlin" {
+		t.Errorf("rows = %v", res.Rows)
+	}
+	if want := []interface{}{"TBLOUT2048", "TBLOUT128"}; !reflect.DeepEqual(outTables, want) {
+		t.Errorf("OUT_TABLE = %v, want %v", outTables, want)
+	}
+	for _, fn := range sap.called() {
+		if fn == "RFC_READ_TABLE" {
+			t.Error("RFC_READ_TABLE called although /BODS/RFC_READ_TABLE2 exists")
+		}
+	}
+}
+
+func TestToolReadTableOutput(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addTable("KNA1", []fakeField{
+		{name: "KUNNR", intType: "C", length: 10, key: true, convExit: "ALPHA"},
+		{name: "PSTLZ", intType: "C", length: 10},
+		{name: "LOEVM", intType: "C", length: 1},
+		{name: "UMSAT", intType: "P", length: 20, decimals: 2},
+		{name: "VISITS", intType: "8", length: 20},
+	}, [][]string{
+		{"0000004711", "01067", "", "1234567890123456.78", "9007199254740993"},
+	})
+	out := &readTableResult{}
+	cs, _ := newTestSession(t, sap, registryOptions{Output: outputFormat{Profile: profileCompact}})
+	callToolJSON(t, cs, "read_table", map[string]interface{}{
+		"table_name": "KNA1",
+		"output":     map[string]interface{}{"alpha": true},
+	}, out)
+	// Packed decimals and 8-byte integers are exact strings by default, as
+	// in rfc_call results.
+	want := map[string]interface{}{"KUNNR": "4711", "PSTLZ": "01067", "UMSAT": "1234567890123456.78", "VISITS": "9007199254740993"}
+	if len(out.Rows) != 1 || !reflect.DeepEqual(out.Rows[0], want) {
+		t.Errorf("rows = %v, want [%v]", out.Rows, want)
+	}
+
+	text, isErr := callTool(t, cs, "read_table", map[string]interface{}{
+		"table_name": "KNA1",
+		"fields":     []interface{}{"UMSAT", "VISITS"},
+		"output":     map[string]interface{}{"decimals": "number"},
+	})
+	if isErr || !strings.Contains(text, `"UMSAT": 1234567890123456.78`) || !strings.Contains(text, `"VISITS": 9007199254740993`) {
+		t.Errorf("read_table with decimals number = %s (error %t), want numbers with every digit", text, isErr)
+	}
+}
+
+func TestToolReadTableOrderBy(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addTable("ZORDERS", []fakeField{
+		{name: "ID", intType: "C", length: 3, key: true},
+		{name: "AMOUNT", intType: "P", length: 10, decimals: 2},
+		{name: "ERDAT", intType: "D", length: 8},
+		{name: "NAME", intType: "C", length: 10},
+	}, [][]string{
+		{"001", "10.00", "20240301", "beta"},
+		{"002", "9.50", "20231231", "alpha"},
+		{"003", "100.00", "00000000", "gamma"},
+		{"004", "9.50", "20240115", "delta"},
+	})
+	cs, _ := newTestSession(t, sap, registryOptions{})
+	for _, tc := range []struct {
+		orderBy []interface{}
+		want    []string
+	}{
+		// Numbers compare by value, not as text; ties keep the table order.
+		{[]interface{}{"AMOUNT"}, []string{"002", "004", "001", "003"}},
+		{[]interface{}{"amount desc", "NAME ASC"}, []string{"003", "001", "002", "004"}},
+		{[]interface{}{"AMOUNT ASCENDING", "NAME DESCENDING"}, []string{"004", "002", "001", "003"}},
+		// The initial date is null and sorts first.
+		{[]interface{}{"ERDAT"}, []string{"003", "002", "004", "001"}},
+		{[]interface{}{"ERDAT DESC"}, []string{"001", "004", "002", "003"}},
+		{[]interface{}{"NAME DESC"}, []string{"003", "004", "001", "002"}},
+	} {
+		var res readTableResult
+		callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "ZORDERS", "order_by": tc.orderBy}, &res)
+		var got []string
+		for _, row := range res.Rows {
+			got = append(got, fmt.Sprint(row["ID"]))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("order_by %v: IDs = %v, want %v", tc.orderBy, got, tc.want)
+		}
+	}
+
+	for orderBy, want := range map[string]string{
+		"AMOUNT DOWN":     `invalid order_by direction "DOWN"`,
+		"AMOUNT ASC NAME": "invalid order_by entry",
+		"NOPE":            `unknown field "NOPE"`,
+	} {
+		text, isErr := callTool(t, cs, "read_table", map[string]interface{}{"table_name": "ZORDERS", "order_by": []interface{}{orderBy}})
+		if !isErr || !strings.Contains(text, want) {
+			t.Errorf("order_by %q = %q (error %t), want %s", orderBy, text, isErr, want)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	sap := newFakeSAP()
+	bank := gorfc.TypeDescription{Name: "ZBANK", Fields: []gorfc.FieldDescription{
+		{Name: "BANKL", FieldType: "RFCTYPE_CHAR"},
+		{Name: "BANKN", FieldType: "RFCTYPE_CHAR"},
+	}}
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_EMPLOYEE", Parameters: []gorfc.ParameterDescription{
+		charParam("PERNR", "RFC_IMPORT", 8),
+		{Name: "PASSWORD", ParameterType: "RFCTYPE_CHAR", Direction: "RFC_IMPORT", NucLength: 40, Optional: true},
+		charParam("NACHN", "RFC_EXPORT", 40),
+		charParam("INIT_PASSWORD", "RFC_EXPORT", 40),
+		{Name: "BANKS", ParameterType: "RFCTYPE_TABLE", Direction: "RFC_TABLES", TypeDesc: bank},
+	}}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"NACHN":         "Mustermann",
+			"INIT_PASSWORD": "Start1234",
+			"BANKS": []interface{}{
+				map[string]interface{}{"BANKL": "10020030", "BANKN": "1234567890"},
+				map[string]interface{}{"BANKL": "10020030", "BANKN": "1234567890"},
+			},
+		}, nil
+	})
+	sap.addTable("PA0002", []fakeField{
+		{name: "PERNR", intType: "N", length: 8, key: true},
+		{name: "GBDAT", intType: "D", length: 8},
+	}, [][]string{
+		{"00001234", "19800101"},
+	})
+	red, err := newRedactor(redactionConfig{HashKey: "k", Rules: []redactionRule{
+		{Scope: "Z_EMP*", Fields: []string{"nachn"}},
+		{Fields: []string{"ZBANK.BANKN"}, Action: redactHash},
+		{Scope: "PA0002", Fields: []string{"PA0002.*"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, _ := newTestSession(t, sap, registryOptions{Redact: red})
+
+	var out map[string]interface{}
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{"function_name": "Z_EMPLOYEE", "parameters": map[string]interface{}{"PERNR": "1234"}}, &out)
+	banks, _ := out["BANKS"].([]interface{})
+	if out["NACHN"] != redactedValue || out["INIT_PASSWORD"] != redactedValue || len(banks) != 2 {
+		t.Fatalf("result = %v", out)
+	}
+	b0, b1 := banks[0].(map[string]interface{}), banks[1].(map[string]interface{})
+	if b0["BANKL"] != "10020030" || !strings.HasPrefix(b0["BANKN"].(string), "hash:") || b0["BANKN"] != b1["BANKN"] {
+		t.Errorf("BANKS = %v, want BANKN hashed alike", banks)
+	}
+
+	table := &readTableResult{}
+	callToolJSON(t, cs, "read_table", map[string]interface{}{"table_name": "pa0002"}, table)
+	if len(table.Rows) != 1 || table.Rows[0]["PERNR"] != redactedValue || table.Rows[0]["GBDAT"] != redactedValue {
+		t.Errorf("rows = %v", table.Rows)
+	}
+	// Filters and sorting on hidden fields would reveal them by bisection.
+	for _, args := range []map[string]interface{}{
+		{"table_name": "PA0002", "filters": []interface{}{map[string]interface{}{"field": "gbdat", "op": "lt", "value": "19800102"}}},
+		{"table_name": "PA0002", "order_by": []interface{}{"GBDAT DESC"}},
+	} {
+		if text, isErr := callTool(t, cs, "read_table", args); !isErr || !strings.Contains(text, "GBDAT of table PA0002 is redacted") {
+			t.Errorf("read_table %v = %s (error %t), want it rejected", args, text, isErr)
+		}
+	}
+
+	// A table reader called directly returns packed rows the rules cannot
+	// see into, so it is refused for tables with hidden fields.
+	for _, table := range []string{"PA0002", "pa0002"} {
+		text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{"function_name": "RFC_READ_TABLE", "parameters": map[string]interface{}{"query_table": table}})
+		if !isErr || !strings.Contains(text, "rejected by policy") || !strings.Contains(text, "PERNR of table PA0002 is redacted") {
+			t.Errorf("rfc_call RFC_READ_TABLE %s = %s (error %t), want it rejected", table, text, isErr)
+		}
+	}
+	if text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{"function_name": "RFC_READ_TABLE", "parameters": map[string]interface{}{"QUERY_TABLE": "T000", "DELIMITER": "", "NO_DATA": "", "ROWSKIPS": 0, "ROWCOUNT": 1}}); isErr {
+		t.Errorf("rfc_call RFC_READ_TABLE T000 = %s, want it allowed", text)
+	}
+
+	// Arguments are remembered for scrubbing when the call enters, without
+	// an audit log.
+	callTool(t, cs, "rfc_call", map[string]interface{}{"function_name": "Z_EMPLOYEE", "parameters": map[string]interface{}{"pernr": "1234", "password": "Geheim99"}})
+	if got := red.scrub("logon with Geheim99"); got != "logon with ***" {
+		t.Errorf("scrub = %q, want the password argument hidden", got)
+	}
+
+	if got := red.scrub("employee Mustermann, account 1234567890"); got != "employee ***, account "+b0["BANKN"].(string) {
+		t.Errorf("scrub = %q", got)
+	}
+	args := red.arguments("Z_EMPLOYEE", json.RawMessage(`{"function_name":"Z_EMPLOYEE","parameters":{"NACHN":"Muster","BANKS":[{"BANKN":"1"}]}}`))
+	if want := `{"function_name":"Z_EMPLOYEE","parameters":{"BANKS":[{"BANKN":"1"}],"NACHN":"***"}}`; string(args) != want {
+		t.Errorf("arguments = %s, want %s", args, want)
+	}
+
+	if _, err := newRedactor(redactionConfig{Rules: []redactionRule{{Fields: []string{"X"}, Action: "drop"}}}); err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+func TestToolListSystems(t *testing.T) {
+	cs, _ := newTestSession(t, newFakeSAP(), registryOptions{})
+	var systems []map[string]interface{}
+	callToolJSON(t, cs, "list_systems", nil, &systems)
+	if len(systems) != 1 || systems[0]["name"] != "FAK" || systems[0]["default"] != true {
+		t.Errorf("list_systems = %v", systems)
+	}
+}
+
+func TestSystemOpensWithoutLock(t *testing.T) {
+	sap := newFakeSAP()
+	release := make(chan struct{})
+	var dials sync.WaitGroup
+	dials.Add(1)
+	var mu sync.Mutex
+	opens := 0
+	systems, err := newSystemRegistry([]systemConfig{{Name: "FAK", Dest: "FAK"}}, "", registryOptions{
+		Pool: defaultPoolConfig(),
+		Dial: func(p gorfc.ConnectionParameters) (rfcConn, error) {
+			mu.Lock()
+			if opens++; opens == 1 {
+				dials.Done()
+			}
+			mu.Unlock()
+			<-release
+			return sap.dial(p)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer systems.close()
+
+	// A caller gives up when its context ends; the dial goes on.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := systems.get(ctx, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("get = %v, want the deadline", err)
+	}
+	dials.Wait()
+
+	// Listing and metrics do not wait for the dial.
+	listed := make(chan struct{})
+	go func() {
+		systems.list()
+		systems.poolStats()
+		newMetrics().writePrometheus(io.Discard, systems)
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(time.Second):
+		t.Fatal("list_systems and metrics blocked by a dial")
+	}
+
+	// Waiting callers share the one open.
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := systems.get(context.Background(), "")
+			results <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("get: %v", err)
+		}
+	}
+	if opens != defaultPoolConfig().MinSize {
+		t.Errorf("%d dials, want %d", opens, defaultPoolConfig().MinSize)
+	}
+}
+
+func TestFunctionTools(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addFunction(gorfc.FunctionDescription{
+		Name: "BAPI_MATERIAL_GET_DETAIL",
+		Parameters: []gorfc.ParameterDescription{
+			charParam("MATERIAL", "RFC_IMPORT", 18),
+			charParam("PLANT", "RFC_IMPORT", 4),
+			charParam("MATERIAL_DESC", "RFC_EXPORT", 40),
+		},
+	}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"MATERIAL_DESC": params["MATERIAL"].(string) + " in " + params["PLANT"].(string)}, nil
+	})
+	sap.addFunction(gorfc.FunctionDescription{Name: "RFC_FUNCTION_SEARCH"}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"FUNCTIONS": []interface{}{
+			map[string]interface{}{"FUNCNAME": params["FUNCNAME"], "STEXT": "Material: Display Details"},
+		}}, nil
+	})
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_DELETE_ALL"}, nil)
+	cs, _ := newTestSession(t, sap, registryOptions{Policy: &callPolicy{Deny: []string{"Z_DELETE*"}}},
+		functionToolConfig{Function: "BAPI_MATERIAL_GET_DETAIL", Name: "get_material", Defaults: map[string]interface{}{"plant": "1000"}},
+		functionToolConfig{Function: "Z_DELETE_ALL", Name: "delete_all"},
+		functionToolConfig{Function: "Z_MISSING", Name: "missing"},
+		functionToolConfig{Function: "STFC_CONNECTION", Name: "rfc_call"},
+	)
+
+	res, err := cs.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools: %v", err)
+	}
+	var tool *mcp.Tool
+	for _, tl := range res.Tools {
+		switch tl.Name {
+		case "get_material":
+			tool = tl
+		case "delete_all", "missing":
+			t.Errorf("tool %s registered, want it skipped", tl.Name)
+		}
+	}
+	if tool == nil {
+		t.Fatal("get_material not registered")
+	}
+	if !strings.HasPrefix(tool.Description, "Material: Display Details") {
+		t.Errorf("description = %q", tool.Description)
+	}
+	schema, _ := json.Marshal(tool.InputSchema)
+	var in struct {
+		Required   []string                          `json:"required"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	json.Unmarshal(schema, &in)
+	if !reflect.DeepEqual(in.Required, []string{"MATERIAL"}) || in.Properties["PLANT"]["default"] != "1000" {
+		t.Errorf("input schema = %s", schema)
+	}
+
+	var out map[string]interface{}
+	callToolJSON(t, cs, "get_material", map[string]interface{}{"material": "M-01"}, &out)
+	if out["MATERIAL_DESC"] != "M-01 in 1000" {
+		t.Errorf("MATERIAL_DESC = %v, want M-01 in 1000", out["MATERIAL_DESC"])
+	}
+	callToolJSON(t, cs, "get_material", map[string]interface{}{"MATERIAL": "M-01", "PLANT": "2000"}, &out)
+	if out["MATERIAL_DESC"] != "M-01 in 2000" {
+		t.Errorf("MATERIAL_DESC = %v, want M-01 in 2000", out["MATERIAL_DESC"])
+	}
+}
+
+func TestFunctionToolName(t *testing.T) {
+	for fn, want := range map[string]string{
+		"BAPI_MATERIAL_GET_DETAIL": "bapi_material_get_detail",
+		"/BODS/RFC_READ_TABLE2":    "bods_rfc_read_table2",
+	} {
+		if got := functionToolName(fn); got != want {
+			t.Errorf("functionToolName(%q) = %q, want %q", fn, got, want)
+		}
+	}
+}
+
+// ── sessions ──────────────────────────────────────────────────────────────────
+
+func TestSessions(t *testing.T) {
+	sap := newFakeSAP()
+	var commitWait interface{}
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_CREATE", Parameters: []gorfc.ParameterDescription{
+		charParam("NAME", "RFC_IMPORT", 10),
+	}}, nil)
+	sap.addFunction(gorfc.FunctionDescription{Name: "BAPI_TRANSACTION_COMMIT"}, func(params map[string]interface{}) (map[string]interface{}, error) {
+		commitWait = params["WAIT"]
+		return map[string]interface{}{}, nil
+	})
+	sap.addFunction(gorfc.FunctionDescription{Name: "BAPI_TRANSACTION_ROLLBACK"}, nil)
+	cs, systems := newTestSession(t, sap, registryOptions{})
+	dials := sap.dials
+
+	var sess struct {
+		SessionID string `json:"session_id"`
+		System    string `json:"system"`
+	}
+	callToolJSON(t, cs, "begin_session", nil, &sess)
+	if sess.SessionID == "" || sess.System != "FAK" {
+		t.Fatalf("begin_session = %+v", sess)
+	}
+	if sap.dials != dials+1 {
+		t.Errorf("dials = %d, want a dedicated connection (%d)", sap.dials, dials+1)
+	}
+	call := map[string]interface{}{"session_id": sess.SessionID, "function_name": "Z_CREATE", "parameters": map[string]interface{}{"NAME": "X"}}
+	if text, isErr := callTool(t, cs, "rfc_call", call); isErr {
+		t.Fatalf("rfc_call in session: %s", text)
+	}
+	if text, isErr := callTool(t, cs, "commit", map[string]interface{}{"session_id": sess.SessionID}); isErr {
+		t.Fatalf("commit: %s", text)
+	}
+	if commitWait != "X" {
+		t.Errorf("BAPI_TRANSACTION_COMMIT WAIT = %v, want X", commitWait)
+	}
+	if text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{"session_id": sess.SessionID, "system": "OTHER", "function_name": "Z_CREATE"}); !isErr || !strings.Contains(text, "belongs to system FAK") {
+		t.Errorf("rfc_call with conflicting system = %q (error %t)", text, isErr)
+	}
+
+	// Idle sessions are rolled back and closed.
+	systems.sessions.cfg.IdleTimeout = time.Minute
+	systems.sessions.reapOnce(time.Now().Add(2 * time.Minute))
+	got := sap.called()
+	if want := []string{"Z_CREATE", "BAPI_TRANSACTION_COMMIT", "BAPI_TRANSACTION_ROLLBACK"}; !reflect.DeepEqual(got[len(got)-3:], want) {
+		t.Errorf("calls = %v, want to end with %v", got, want)
+	}
+	if text, isErr := callTool(t, cs, "rfc_call", call); !isErr || !strings.Contains(text, "unknown session") {
+		t.Errorf("rfc_call in reaped session = %q (error %t)", text, isErr)
+	}
+
+	callToolJSON(t, cs, "begin_session", map[string]interface{}{"system": "fak"}, &sess)
+	if text, isErr := callTool(t, cs, "end_session", map[string]interface{}{"session_id": sess.SessionID}); isErr {
+		t.Fatalf("end_session: %s", text)
+	}
+	if text, isErr := callTool(t, cs, "rollback", map[string]interface{}{"session_id": sess.SessionID}); !isErr || !strings.Contains(text, "unknown session") {
+		t.Errorf("rollback after end_session = %q (error %t)", text, isErr)
+	}
+
+	// The sessions of a client are rolled back when it disconnects.
+	callToolJSON(t, cs, "begin_session", nil, &sess)
+	call["session_id"] = sess.SessionID
+	if text, isErr := callTool(t, cs, "rfc_call", call); isErr {
+		t.Fatalf("rfc_call in session: %s", text)
+	}
+	cs.Close()
+	for deadline := time.Now().Add(time.Second); systems.sessions.stats()["open"] != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if open := systems.sessions.stats()["open"]; open != 0 {
+		t.Errorf("%v sessions open after the client disconnected, want 0", open)
+	}
+	if got := sap.called(); got[len(got)-1] != "BAPI_TRANSACTION_ROLLBACK" {
+		t.Errorf("calls = %v, want to end with BAPI_TRANSACTION_ROLLBACK", got)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	sap := newFakeSAP()
+	cm, err := newConnManagerWithDial(nil, defaultPoolConfig(), sap.dial)
+	if err != nil {
+		t.Fatalf("newConnManagerWithDial: %v", err)
+	}
+	defer cm.close()
+	st := newSessionStore(sessionConfig{MaxSessions: 2})
+	defer st.close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	begun := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := st.begin(cm, nil); err == nil {
+				mu.Lock()
+				begun++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if begun != 2 {
+		t.Errorf("%d sessions begun concurrently, want the limit of 2", begun)
+	}
+}
+
+// ── record and replay ─────────────────────────────────────────────────────────
+
+func TestSessionEndHungRollback(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addFunction(gorfc.FunctionDescription{Name: "BAPI_TRANSACTION_ROLLBACK"}, nil)
+	cm, err := newConnManagerWithDial(nil, defaultPoolConfig(), sap.dial)
+	if err != nil {
+		t.Fatalf("newConnManagerWithDial: %v", err)
+	}
+	defer cm.close()
+	st := newSessionStore(sessionConfig{})
+	s, err := st.begin(cm, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	conn := s.conn.(*fakeConn)
+
+	defer func(d time.Duration) { sessionRollbackTimeout = d }(sessionRollbackTimeout)
+	sessionRollbackTimeout = 20 * time.Millisecond
+	sap.block = make(chan struct{})
+	ended := make(chan struct{})
+	go func() {
+		st.close()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waits for a hung rollback")
+	}
+	if _, err := s.exec(context.Background(), "BAPI_TRANSACTION_ROLLBACK", nil); err == nil || !strings.Contains(err.Error(), "has ended") {
+		t.Errorf("call after the session ended = %v, want it refused", err)
+	}
+
+	// The handle is closed once the abandoned rollback returns.
+	close(sap.block)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		conn.mu.Lock()
+		closed := conn.closed
+		conn.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not closed after the rollback returned")
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := t.TempDir() + "/session.jsonl"
+	calls := []struct {
+		tool string
+		args map[string]interface{}
+	}{
+		{"rfc_call", map[string]interface{}{"function_name": "STFC_CONNECTION", "parameters": map[string]interface{}{"REQUTEXT": "hello"}}},
+		{"read_table", map[string]interface{}{"table_name": "T000", "fields": []string{"MANDT", "MTEXT"}}},
+		{"rfc_describe", map[string]interface{}{"function_name": "Z_MISSING"}},
+		{"rfc_connection_info", nil},
+	}
+	type outcome struct {
+		text  string
+		isErr bool
+	}
+
+	var recorded []outcome
+	t.Run("record", func(t *testing.T) {
+		tape, err := recordCassette(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tape.close()
+		cs, _ := newTestSession(t, newFakeSAP(), registryOptions{Cassette: tape})
+		for _, c := range calls {
+			text, isErr := callTool(t, cs, c.tool, c.args)
+			recorded = append(recorded, outcome{text, isErr})
+		}
+	})
+
+	tape, err := replayCassette(path)
+	if err != nil {
+		t.Fatalf("replayCassette: %v", err)
+	}
+	sap := newFakeSAP()
+	cs, _ := newTestSession(t, sap, registryOptions{Cassette: tape})
+	for i, c := range calls {
+		text, isErr := callTool(t, cs, c.tool, c.args)
+		if (outcome{text, isErr}) != recorded[i] {
+			t.Errorf("replayed %s = %q (error %t), recorded %q (error %t)", c.tool, text, isErr, recorded[i].text, recorded[i].isErr)
+		}
+	}
+	// Parameter names and trailing blanks do not affect matching.
+	var out map[string]interface{}
+	callToolJSON(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "stfc_connection",
+		"parameters":    map[string]interface{}{"requtext": "hello  "},
+	}, &out)
+	if out["ECHOTEXT"] != "hello" {
+		t.Errorf("ECHOTEXT = %v, want hello", out["ECHOTEXT"])
+	}
+	text, isErr := callTool(t, cs, "rfc_call", map[string]interface{}{
+		"function_name": "STFC_CONNECTION",
+		"parameters":    map[string]interface{}{"REQUTEXT": "unrecorded"},
+	})
+	if !isErr || !strings.Contains(text, "no call of STFC_CONNECTION") {
+		t.Errorf("unrecorded call = %q (error %t)", text, isErr)
+	}
+	if sap.dials != 0 {
+		t.Errorf("replay dialed SAP %d times", sap.dials)
+	}
+}
+
+func TestRecordReplayTypes(t *testing.T) {
+	// gorfc's types: int32 for INT, uint8 for INT1, int16 for INT2, int64
+	// for INT8, time.Time for DATE and TIME, []byte for RAW.
+	result := map[string]interface{}{
+		"COUNT": int32(-7),
+		"FLAG":  uint8(1),
+		"PRIO":  int16(3),
+		"BIG":   int64(1) << 40,
+		"RATE":  1.5,
+		"NAME":  "Miller",
+		"ERDAT": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"RAW":   []byte{0, 1, 0xff},
+		"NONE":  nil,
+		"ITEMS": []interface{}{
+			map[string]interface{}{"POSNR": "000010", "QTY": int32(2), "ERZET": time.Date(0, 1, 1, 13, 5, 9, 0, time.UTC)},
+		},
+	}
+	sap := newFakeSAP()
+	sap.addFunction(gorfc.FunctionDescription{Name: "Z_TYPES"}, func(map[string]interface{}) (map[string]interface{}, error) {
+		return result, nil
+	})
+	path := t.TempDir() + "/types.jsonl"
+	tape, err := recordCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tape.wrap("FAK", sap.dial)(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Call("Z_TYPES", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	tape.close()
+
+	if tape, err = replayCassette(path); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err = tape.wrap("FAK", nil)(nil); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := conn.Call("Z_TYPES", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, result) {
+		t.Errorf("replayed %#v\nrecorded %#v", replayed, result)
+	}
+}
+
+// ── audit log ─────────────────────────────────────────────────────────────────
+
+func TestAuditLog(t *testing.T) {
+	_, systems := newTestSession(t, newFakeSAP(), registryOptions{})
+	cfg := auditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl"), MaxSize: 1000, Key: []byte("audit-key")}
+	red, err := newRedactor(redactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := openAuditLog(cfg, red)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.wrap("rfc_call", func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		cm, err := systems.get(ctx, "")
+		if err != nil {
+			return errResult(err), nil
+		}
+		return callFunction(ctx, cm, nil, newMetrics(), "STFC_CONNECTION", map[string]interface{}{"REQUTEXT": "hi"}, nil, outputFormat{}), nil
+	})
+	call := func(h mcp.ToolHandler) {
+		args := json.RawMessage(`{"function_name":"STFC_CONNECTION","parameters":{"REQUTEXT":"hi","USER_PASSWORD":"geheim"}}`)
+		if _, err := h(context.Background(), &mcp.CallToolRequest{Params: &mcp.CallToolParamsRaw{Name: "rfc_call", Arguments: args}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		call(h)
+	}
+	call(a.wrap("rfc_ping", func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return errResult(&policyError{Function: "RFC_PING", Reason: "denied"}), nil
+	}))
+	a.close()
+
+	last, err := lastAuditRecord(cfg.Path, cfg.Key)
+	if err != nil || last.Seq != 6 || last.Outcome != auditError || last.ErrorClass != errAuthorization {
+		t.Fatalf("last record = %+v, %v", last, err)
+	}
+	files, _ := auditFiles(cfg.Path)
+	first, err := lastAuditRecord(files[0], cfg.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.System != "FAK" || first.SAPUser != "TESTER" || !reflect.DeepEqual(first.Functions, []string{"STFC_CONNECTION"}) || first.Outcome != auditSuccess || first.ResultBytes == 0 {
+		t.Errorf("record = %+v", first)
+	}
+	if params := string(first.Params); !strings.Contains(params, `"USER_PASSWORD":"***"`) || strings.Contains(params, "geheim") {
+		t.Errorf("params = %s", params)
+	}
+
+	// A reopened log continues the chain.
+	if a, err = openAuditLog(cfg, red); err != nil {
+		t.Fatal(err)
+	}
+	call(a.wrap("rfc_call", h))
+	a.close()
+	rep, err := verifyAuditLog(cfg.Path, cfg.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Records != 7 || rep.First != 1 || rep.Files < 2 || len(rep.Problems) > 0 {
+		t.Fatalf("report = %+v", rep)
+	}
+	// A chain rebuilt without the key does not verify.
+	if rep, _ := verifyAuditLog(cfg.Path, nil); len(rep.Problems) != 7 {
+		t.Errorf("unkeyed verification: %d problems, want 7", len(rep.Problems))
+	}
+
+	// A record that cannot be written withholds the result, unless the log
+	// fails open.
+	ok := func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return textResult("ok"), nil
+	}
+	req := &mcp.CallToolRequest{Params: &mcp.CallToolParamsRaw{Name: "list_systems"}}
+	res, err := a.wrap("list_systems", ok)(context.Background(), req)
+	if err != nil || !res.IsError || !strings.Contains(res.Content[0].(*mcp.TextContent).Text, "audit record could not be written") {
+		t.Errorf("closed log: result = %+v, %v; want an error result", res, err)
+	}
+	a.cfg.FailOpen = true
+	if res, err = a.wrap("list_systems", ok)(context.Background(), req); err != nil || res.IsError {
+		t.Errorf("closed log failing open: result = %+v, %v", res, err)
+	}
+
+	// Edits and removed records break the chain.
+	b, _ := os.ReadFile(files[0])
+	lines := strings.SplitAfter(string(b), "\n")
+	edited := strings.Replace(lines[0], `"REQUTEXT":"hi"`, `"REQUTEXT":"ho"`, 1) + strings.Join(lines[2:], "")
+	os.WriteFile(files[0], []byte(edited), 0o600)
+	rep, _ = verifyAuditLog(cfg.Path, cfg.Key)
+	if len(rep.Problems) != 2 || !strings.Contains(rep.Problems[0], "hash mismatch") || !strings.Contains(rep.Problems[1], "follows record") {
+		t.Errorf("problems = %q", rep.Problems)
+	}
+}
+
+// ── Prometheus metrics ────────────────────────────────────────────────────────
+
+func TestPrometheusMetrics(t *testing.T) {
+	_, systems := newTestSession(t, newFakeSAP(), registryOptions{})
+	m := newMetrics()
+	h := m.wrap("rfc_call", func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		cm, err := systems.get(ctx, "")
+		if err != nil {
+			return errResult(err), nil
+		}
+		return callFunction(ctx, cm, nil, m, "STFC_CONNECTION", map[string]interface{}{"REQUTEXT": "hi"}, nil, outputFormat{}), nil
+	})
+	rejected := m.wrap("rfc_ping", func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return errResult(&policyError{Function: "RFC_PING", Reason: "denied"}), nil
+	})
+	for _, h := range []mcp.ToolHandler{h, h, rejected} {
+		if _, err := h(context.Background(), &mcp.CallToolRequest{Params: &mcp.CallToolParamsRaw{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	if err := m.writePrometheus(&out, systems); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE gorfc_mcp_tool_duration_seconds histogram\n",
+		`gorfc_mcp_tool_duration_seconds_count{tool="rfc_call",system="FAK"} 2` + "\n",
+		`gorfc_mcp_tool_errors_total{tool="rfc_ping",system="",class="authorization"} 1` + "\n",
+		`gorfc_mcp_rfc_duration_seconds_bucket{system="FAK",function="STFC_CONNECTION",le="+Inf"} 2` + "\n",
+		`gorfc_mcp_system_connected{system="FAK"} 1` + "\n",
+		`gorfc_mcp_reconnects_total{system="FAK"} 0` + "\n",
+		`gorfc_mcp_pool_connections{system="FAK",state="in_use"} 0` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `gorfc_mcp_tool_result_bytes_total{tool="rfc_call",system="FAK"} 0`) {
+		t.Error("result bytes not counted")
+	}
+}
+
+// ── tracing ───────────────────────────────────────────────────────────────────
+
+func TestTracing(t *testing.T) {
+	type otlpSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Attributes   []struct {
+			Key   string                 `json:"key"`
+			Value map[string]interface{} `json:"value"`
+		} `json:"attributes"`
+		Status struct {
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	var mu sync.Mutex
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("export: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	_, systems := newTestSession(t, newFakeSAP(), registryOptions{})
+	red, err := newRedactor(redactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	red.hide("s3cr3t-value", redactMask)
+	tr := newTracer(tracingConfig{Endpoint: collector.URL, Service: "test"}, red)
+	h := tr.wrap("rfc_call", func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		cm, err := systems.get(ctx, "")
+		if err != nil {
+			return errResult(err), nil
+		}
+		return callFunction(ctx, cm, nil, newMetrics(), "STFC_CONNECTION", map[string]interface{}{"REQUTEXT": "hi"}, nil, outputFormat{}), nil
+	})
+	call := func(traceparent string) {
+		req := &mcp.CallToolRequest{Params: &mcp.CallToolParamsRaw{Name: "rfc_call", Meta: mcp.Meta{"traceparent": traceparent}}}
+		if _, err := h(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	call("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	call("00-1af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00") // not sampled
+	failing := tr.wrap("rfc_ping", func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return nil, errors.New("logon with s3cr3t-value failed")
+	})
+	req := &mcp.CallToolRequest{Params: &mcp.CallToolParamsRaw{Name: "rfc_ping", Meta: mcp.Meta{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}}
+	failing(context.Background(), req)
+	tr.close()
+	// A call still running at shutdown ends its span after the exporter is
+	// gone.
+	failing(context.Background(), req)
+
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		if s.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("span %s in trace %s", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	root, ok := byName["tools/call rfc_call"]
+	if !ok || root.ParentSpanID != "b7ad6b7169203331" {
+		t.Fatalf("tool span = %+v, want child of the traceparent", root)
+	}
+	for _, name := range []string{"rfc.describe", "rfc.coerce", "rfc.call STFC_CONNECTION", "mcp.serialize"} {
+		if s, ok := byName[name]; !ok || s.ParentSpanID != root.SpanID {
+			t.Errorf("span %q = %+v, want child of the tool span", name, s)
+		}
+	}
+	rfc := byName["rfc.call STFC_CONNECTION"]
+	for _, name := range []string{"rfc.pool_wait", "rfc.execute"} {
+		if s, ok := byName[name]; !ok || s.ParentSpanID != rfc.SpanID && s.ParentSpanID != byName["rfc.describe"].SpanID {
+			t.Errorf("span %q = %+v, want child of an RFC span", name, s)
+		}
+	}
+	attrs := map[string]interface{}{}
+	for _, a := range rfc.Attributes {
+		attrs[a.Key] = a.Value["stringValue"]
+	}
+	if attrs["rfc.function"] != "STFC_CONNECTION" || attrs["sap.system"] != "FAK" || attrs["sap.system_id"] != "FAK" {
+		t.Errorf("rfc.call attributes = %v", attrs)
+	}
+	if msg := byName["tools/call rfc_ping"].Status.Message; msg != "logon with *** failed" {
+		t.Errorf("status message = %q, want the hidden value scrubbed", msg)
+	}
+
+	for _, v := range []string{"", "00-00000000000000000000000000000000-b7ad6b7169203331-01", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"} {
+		if _, _, _, ok := parseTraceparent(v); ok {
+			t.Errorf("traceparent %q accepted", v)
+		}
+	}
+}
+
+// ── logging ───────────────────────────────────────────────────────────────────
+
+func TestLogging(t *testing.T) {
+	red, err := newRedactor(redactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	red.hide("s3cr3t-value", redactMask)
+	var out strings.Builder
+	cfg := logConfig{Level: slog.LevelInfo, Format: logJSON, Forward: true}
+	saved := logger
+	logger = newLogger(cfg, &out, red)
+	defer func() { logger = saved }()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0"}, nil)
+	server.AddTool(&mcp.Tool{Name: "noisy", InputSchema: json.RawMessage(`{"type":"object"}`)}, cfg.wrap("noisy", func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		logger.DebugContext(ctx, "details")
+		logger.WarnContext(ctx, "reconnecting", "err", errors.New("logon with s3cr3t-value failed"))
+		return textResult("done"), nil
+	}))
+	forwarded := make(chan string, 4)
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "0"}, &mcp.ClientOptions{
+		LoggingMessageHandler: func(_ context.Context, req *mcp.LoggingMessageRequest) {
+			b, _ := json.Marshal(req.Params)
+			forwarded <- string(b)
+		},
+	})
+	ctx := context.Background()
+	st, ct := mcp.NewInMemoryTransports()
+	if _, err := server.Connect(ctx, st, nil); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := client.Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	if err := cs.SetLoggingLevel(ctx, &mcp.SetLoggingLevelParams{Level: "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if text, isErr := callTool(t, cs, "noisy", nil); isErr {
+		t.Fatal(text)
+	}
+
+	var entry map[string]interface{}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("stderr = %q, want the warning only", out.String())
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := entry["request_id"].(string)
+	if entry["level"] != "WARN" || entry["msg"] != "reconnecting" || entry["tool"] != "noisy" || len(id) != 16 || entry["err"] != "logon with *** failed" {
+		t.Errorf("entry = %v", entry)
+	}
+
+	// The client asked for debug entries, including those below the
+	// server's level.
+	var got []string
+	for len(got) < 4 {
+		select {
+		case msg := <-forwarded:
+			got = append(got, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("forwarded %q", got)
+		}
+	}
+	all := strings.Join(got, "\n")
+	for _, want := range []string{`"msg":"tool call started"`, `"msg":"details"`, `"level":"warning"`, `"request_id":"` + id + `"`} {
+		if !strings.Contains(all, want) {
+			t.Errorf("forwarded entries lack %s:\n%s", want, all)
+		}
+	}
+	if strings.Contains(all, "s3cr3t") {
+		t.Errorf("forwarded entries leak a redacted value:\n%s", all)
+	}
+}
+
+// ── HTTP transport ────────────────────────────────────────────────────────────
+
+func TestHTTPConfig(t *testing.T) {
+	t.Setenv("MCP_HTTP_ADDR", ":0")
+	t.Setenv("MCP_AUTH_TOKENS", "ci:nightly:s3cret, plain")
+	c, err := httpConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"s3cret": "ci:nightly", "plain": "token-2"}; !reflect.DeepEqual(c.Tokens, want) {
+		t.Errorf("tokens = %v, want %v", c.Tokens, want)
+	}
+	for _, tokens := range []string{"ci:", ":s3cret", "a:s3cret,b:s3cret"} {
+		t.Setenv("MCP_AUTH_TOKENS", tokens)
+		if _, err := httpConfigFromEnv(); err == nil {
+			t.Errorf("MCP_AUTH_TOKENS=%q accepted", tokens)
+		}
+	}
+}
+
+// headerTransport sets a header on every request.
+type headerTransport struct{ name, value string }
+
+func (h headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(h.name, h.value)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// connectHTTP connects an MCP client to the streamable HTTP endpoint of url,
+// sending header with every request.
+func connectHTTP(t *testing.T, url string, header headerTransport) (*mcp.ClientSession, error) {
+	t.Helper()
+	tr := &mcp.StreamableClientTransport{Endpoint: url + "/mcp", HTTPClient: &http.Client{Transport: header}, MaxRetries: -1}
+	return mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "0"}, nil).Connect(context.Background(), tr, nil)
+}
+
+func TestHTTPAuth(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0"}, nil)
+	server.AddTool(&mcp.Tool{Name: "whoami", InputSchema: json.RawMessage(`{"type":"object"}`)}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return textResult(req.Extra.TokenInfo.UserID), nil
+	})
+	c := &httpConfig{Tokens: map[string]string{"s3cret": "ci:nightly"}}
+	ts := httptest.NewServer(c.handler(server))
+	defer ts.Close()
+
+	for _, h := range []headerTransport{{"Authorization", "Bearer s3cret"}, {"X-API-Key", "s3cret"}} {
+		cs, err := connectHTTP(t, ts.URL, h)
+		if err != nil {
+			t.Fatalf("%s: %v", h.name, err)
+		}
+		if text, _ := callTool(t, cs, "whoami", nil); text != "ci:nightly" {
+			t.Errorf("%s: user = %q, want ci:nightly", h.name, text)
+		}
+		cs.Close()
+	}
+
+	for _, h := range []headerTransport{{"Authorization", "Bearer wrong"}, {"X-API-Key", "wrong"}, {"X-Other", "s3cret"}} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		resp, err := (&http.Client{Transport: h}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: %s, want 401", h.name, resp.Status)
+		}
+	}
+	if resp, err := http.Get(ts.URL + "/healthz"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz = %v, %v", resp, err)
+	}
+}
+
+func TestHTTPShutdownDrain(t *testing.T) {
+	calls := &inflightCalls{}
+	entered, release := make(chan struct{}), make(chan struct{})
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0"}, nil)
+	server.AddTool(&mcp.Tool{Name: "slow", InputSchema: json.RawMessage(`{"type":"object"}`)}, calls.wrap(func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		close(entered)
+		<-release
+		return textResult("done"), nil
+	}))
+	c := &httpConfig{Tokens: map[string]string{"s3cret": "ci"}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- c.serve(ctx, server, ln, calls, 5*time.Second) }()
+
+	cs, err := connectHTTP(t, url, headerTransport{"Authorization", "Bearer s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	result := make(chan string, 1)
+	go func() {
+		res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{Name: "slow"})
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		result <- res.Content[0].(*mcp.TextContent).Text
+	}()
+	<-entered
+	cancel()
+
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned with a call in flight: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := (&http.Client{Transport: &http.Transport{}}).Get(url + "/healthz"); err == nil {
+		t.Error("new connection accepted while draining")
+	}
+	close(release)
+	if got := <-result; got != "done" {
+		t.Errorf("in-flight call = %q, want its result", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+// ── metadata cache ────────────────────────────────────────────────────────────
+
+func TestMetadataCache(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newMetadataCache(cacheConfig{TTL: time.Minute, MaxSize: 2})
+	c.now = func() time.Time { return now }
+	has := func(name string) bool {
+		t.Helper()
+		v, ok := c.get(cacheFunction, "fak", name)
+		if ok && v != strings.ToUpper(name) {
+			t.Errorf("get(%s) = %v", name, v)
+		}
+		return ok
+	}
+
+	c.put(cacheFunction, "FAK", "A", "A")
+	c.put(cacheFunction, "FAK", "B", "B")
+	if !has("a") {
+		t.Fatal("A not cached")
+	}
+	// A was used last, so the third entry evicts B.
+	c.put(cacheFunction, "FAK", "C", "C")
+	if b, a, c := has("B"), has("A"), has("C"); b || !a || !c {
+		t.Errorf("after the third put: B %t, A %t, C %t; want B evicted", b, a, c)
+	}
+
+	// Entries expire a TTL after they were put; putting again renews them.
+	now = now.Add(30 * time.Second)
+	c.put(cacheFunction, "FAK", "C", "C")
+	now = now.Add(31 * time.Second)
+	if a, c := has("A"), has("C"); a || !c {
+		t.Errorf("after the TTL: A %t, C %t; want A expired", a, c)
+	}
+
+	st := c.stats()
+	if st["entries"] != 1 || st["hits"] != int64(4) || st["misses"] != int64(2) || st["evictions"] != int64(1) {
+		t.Errorf("stats = %v, want 1 entry, 4 hits, 2 misses, 1 eviction", st)
+	}
+}
+
+// ── connection pool ───────────────────────────────────────────────────────────
+
+// newTestPool opens a pool on sap with cfg and returns it with a function
+// reporting its created, retired and open counts.
+func newTestPool(t *testing.T, sap *fakeSAP, cfg poolConfig) (*connPool, func() (created, retired int64, open int)) {
+	t.Helper()
+	p, err := newConnPool(cfg, func() (rfcConn, error) { return sap.dial(nil) })
+	if err != nil {
+		t.Fatalf("newConnPool: %v", err)
+	}
+	t.Cleanup(p.close)
+	return p, func() (int64, int64, int) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.created, p.retired, p.open
+	}
+}
+
+func TestPoolReap(t *testing.T) {
+	p, counts := newTestPool(t, newFakeSAP(), poolConfig{MinSize: 1, MaxSize: 3, IdleTimeout: time.Minute, MaxLifetime: time.Hour})
+	var conns []*pooledConn
+	for i := 0; i < 3; i++ {
+		pc, err := p.get(context.Background(), false)
+		if err != nil {
+			t.Fatalf("get %d: %v", i, err)
+		}
+		conns = append(conns, pc)
+	}
+	for _, pc := range conns {
+		p.put(pc, true)
+	}
+	if created, retired, open := counts(); created != 3 || retired != 0 || open != 3 {
+		t.Fatalf("created/retired/open = %d/%d/%d, want 3/0/3", created, retired, open)
+	}
+
+	// Connections idle for longer than IdleTimeout are closed down to MinSize.
+	now := time.Now()
+	p.reapOnce(now.Add(30 * time.Second))
+	if created, retired, open := counts(); created != 3 || retired != 0 || open != 3 {
+		t.Errorf("before the idle timeout: created/retired/open = %d/%d/%d, want 3/0/3", created, retired, open)
+	}
+	p.reapOnce(now.Add(2 * time.Minute))
+	if created, retired, open := counts(); created != 3 || retired != 2 || open != 1 {
+		t.Errorf("after the idle timeout: created/retired/open = %d/%d/%d, want 3/2/1", created, retired, open)
+	}
+
+	// The last connection outlives MaxLifetime and is replaced to keep
+	// MinSize open.
+	p.reapOnce(now.Add(2 * time.Hour))
+	if created, retired, open := counts(); created != 4 || retired != 3 || open != 1 {
+		t.Errorf("after the max lifetime: created/retired/open = %d/%d/%d, want 4/3/1", created, retired, open)
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	p, counts := newTestPool(t, newFakeSAP(), poolConfig{MaxSize: 1})
+	pc, err := p.get(context.Background(), false)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	// A caller waits for a slot until its context ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	if _, err := p.get(ctx, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("get with the pool exhausted = %v, want DeadlineExceeded", err)
+	}
+	if waited := time.Since(t0); waited < 20*time.Millisecond {
+		t.Errorf("get returned after %v, want it to wait for the deadline", waited)
+	}
+	if waits := p.stats()["waits"]; waits != int64(1) {
+		t.Errorf("waits = %v, want 1", waits)
+	}
+
+	// A waiting caller gets the connection once it is returned.
+	got := make(chan *pooledConn)
+	go func() {
+		next, err := p.get(context.Background(), false)
+		if err != nil {
+			t.Errorf("waiting get: %v", err)
+		}
+		got <- next
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.put(pc, true)
+	select {
+	case next := <-got:
+		if next != pc {
+			t.Error("waiting get dialed a new connection instead of reusing the returned one")
+		}
+		p.put(next, true)
+	case <-time.After(time.Second):
+		t.Fatal("waiting get did not return after put")
+	}
+	if created, _, open := counts(); created != 1 || open != 1 {
+		t.Errorf("created/open = %d/%d, want 1/1", created, open)
+	}
+}
+
+// ── connManager ───────────────────────────────────────────────────────────────
+
+func TestCallCancelledWhileRunning(t *testing.T) {
+	sap := newFakeSAP()
+	cm, err := newConnManagerWithDial(nil, defaultPoolConfig(), sap.dial)
+	if err != nil {
+		t.Fatalf("newConnManagerWithDial: %v", err)
+	}
+	defer cm.close()
+
+	sap.block = make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = cm.call(ctx, "STFC_CONNECTION", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+
+	// The handle is busy until the RFC returns and only retired then.
+	cm.pool.mu.Lock()
+	inUse, retired := cm.pool.inUse, cm.pool.retired
+	cm.pool.mu.Unlock()
+	if inUse != 1 || retired != 0 {
+		t.Errorf("while the RFC runs: in use %d, retired %d; want 1, 0", inUse, retired)
+	}
+	close(sap.block)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		cm.pool.mu.Lock()
+		inUse, retired = cm.pool.inUse, cm.pool.retired
+		cm.pool.mu.Unlock()
+		if inUse == 0 && retired == 1 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if inUse != 0 || retired != 1 {
+		t.Errorf("after the RFC returned: in use %d, retired %d; want 0, 1", inUse, retired)
+	}
+}
+
+func TestTableReaderProbe(t *testing.T) {
+	sap := newFakeSAP()
+	sap.addFunction(gorfc.FunctionDescription{
+		Name:       "BBP_RFC_READ_TABLE",
+		Parameters: []gorfc.ParameterDescription{tableParam("DATA", "TAB512", 512, "WA")},
+	}, nil)
+	cm, err := newConnManagerWithDial(nil, defaultPoolConfig(), sap.dial)
+	if err != nil {
+		t.Fatalf("newConnManagerWithDial: %v", err)
+	}
+	defer cm.close()
+
+	r, err := cm.tableReader(context.Background())
+	if err != nil || r.name() != "BBP_RFC_READ_TABLE" || r.rowWidth() != 512 {
+		t.Fatalf("tableReader = %v, %v; want BBP_RFC_READ_TABLE with width 512", r, err)
+	}
+
+	cm2, err := newConnManagerWithDial(nil, defaultPoolConfig(), sap.dial)
+	if err != nil {
+		t.Fatalf("newConnManagerWithDial: %v", err)
+	}
+	defer cm2.close()
+	cm2.policy = &callPolicy{Deny: []string{"BBP_*"}}
+	if r, err := cm2.tableReader(context.Background()); err != nil || r.name() != "RFC_READ_TABLE" {
+		t.Errorf("tableReader with BBP_* denied = %v, %v; want RFC_READ_TABLE", r, err)
+	}
+}
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+func TestCoerceValue(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	structDesc := gorfc.TypeDescription{Fields: []gorfc.FieldDescription{
+		{Name: "COUNT", FieldType: "RFCTYPE_INT"},
+		{Name: "DAY", FieldType: "RFCTYPE_DATE"},
+	}}
+	tests := []struct {
+		name    string
+		val     interface{}
+		rfcType string
+		td      gorfc.TypeDescription
+		want    interface{}
+		wantErr bool
+	}{
+		{"int from float", 42.0, "RFCTYPE_INT", gorfc.TypeDescription{}, 42, false},
+		{"int from string", "7", "RFCTYPE_INT8", gorfc.TypeDescription{}, 7, false},
+		{"int from bad string", "x", "RFCTYPE_INT", gorfc.TypeDescription{}, nil, true},
+		{"int from fraction", json.Number("1.5"), "RFCTYPE_INT", gorfc.TypeDescription{}, nil, true},
+		{"int1 out of range", json.Number("256"), "RFCTYPE_INT1", gorfc.TypeDescription{}, nil, true},
+		{"int8 beyond 4 bytes", json.Number("9007199254740993"), "RFCTYPE_INT8", gorfc.TypeDescription{}, nil, true},
+		{"char from number", 12.5, "RFCTYPE_CHAR", gorfc.TypeDescription{}, "12.5", false},
+		{"char from large number", json.Number("12345678"), "RFCTYPE_CHAR", gorfc.TypeDescription{}, "12345678", false},
+		{"bcd from string", "1.10", "RFCTYPE_BCD", gorfc.TypeDescription{}, "1.10", false},
+		{"decf keeps digits", json.Number("12345678901234567.89"), "RFCTYPE_DECF34", gorfc.TypeDescription{}, "12345678901234567.89", false},
+		{"date", "20240301", "RFCTYPE_DATE", gorfc.TypeDescription{}, date, false},
+		{"bad date", "2024-03-01", "RFCTYPE_DATE", gorfc.TypeDescription{}, nil, true},
+		{"bytes", "AQI=", "RFCTYPE_BYTE", gorfc.TypeDescription{}, []byte{1, 2}, false},
+		{"structure", map[string]interface{}{"count": 3.0, "day": "20240301"}, "RFCTYPE_STRUCTURE", structDesc,
+			map[string]interface{}{"COUNT": 3, "DAY": date}, false},
+		{"table", []interface{}{map[string]interface{}{"COUNT": 1.0}}, "RFCTYPE_TABLE", structDesc,
+			[]interface{}{map[string]interface{}{"COUNT": 1}}, false},
+		{"table from object", map[string]interface{}{}, "RFCTYPE_TABLE", structDesc, nil, true},
+		{"nil", nil, "RFCTYPE_INT", gorfc.TypeDescription{}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := coerceValue(tt.val, tt.rfcType, 2, tt.td)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	desc := gorfc.FunctionDescription{
+		Name: "Z_ORDER_CREATE",
+		Parameters: []gorfc.ParameterDescription{
+			charParam("ORDER_TYPE", "RFC_IMPORT", 4),
+			{Name: "DOC_DATE", ParameterType: "RFCTYPE_DATE", Direction: "RFC_IMPORT", Optional: true},
+			{Name: "AMOUNT", ParameterType: "RFCTYPE_BCD", Direction: "RFC_IMPORT", NucLength: 7, Decimals: 2, Optional: true},
+			{Name: "COUNT", ParameterType: "RFCTYPE_INT8", Direction: "RFC_IMPORT", Optional: true},
+			{Name: "HEADER", ParameterType: "RFCTYPE_STRUCTURE", Direction: "RFC_IMPORT", Optional: true, TypeDesc: gorfc.TypeDescription{
+				Name: "ZHEADER",
+				Fields: []gorfc.FieldDescription{
+					{Name: "QTY", FieldType: "RFCTYPE_INT"},
+					{Name: "MATNR", FieldType: "RFCTYPE_CHAR", NucLength: 18},
+					{Name: "POSNR", FieldType: "RFCTYPE_NUM", NucLength: 6},
+				},
+			}},
+			tableParam("ITEMS", "ZITEM", 10, "POSNR"),
+			charParam("ORDER_ID", "RFC_EXPORT", 10),
+		},
+	}
+	schemas := schemasForFunction(desc, outputFormat{})
+	if !reflect.DeepEqual(schemas.Input.Required, []string{"ORDER_TYPE"}) {
+		t.Errorf("required = %v, want [ORDER_TYPE]", schemas.Input.Required)
+	}
+	if _, ok := schemas.Output.Properties["ORDER_ID"]; !ok || schemas.Output.Properties["ITEMS"] == nil {
+		t.Errorf("output properties = %v", schemas.Output.Properties)
+	}
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		wantErr string
+	}{
+		{"minimal", map[string]interface{}{"order_type": "TA"}, ""},
+		{"full", map[string]interface{}{
+			"ORDER_TYPE": "TA", "DOC_DATE": "20240301", "AMOUNT": "12345678901.25",
+			"header": map[string]interface{}{"qty": 3.0, "matnr": "M-01"},
+			"ITEMS":  []interface{}{map[string]interface{}{"POSNR": "10"}},
+		}, ""},
+		{"missing required", map[string]interface{}{"DOC_DATE": "20240301"}, "required"},
+		{"too long", map[string]interface{}{"ORDER_TYPE": "TOOLONG"}, "maxLength"},
+		{"bad date", map[string]interface{}{"ORDER_TYPE": "TA", "DOC_DATE": "2024-03-01"}, "pattern"},
+		{"too many decimals", map[string]interface{}{"ORDER_TYPE": "TA", "AMOUNT": "1.255"}, "pattern"},
+		{"fractional int", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": map[string]interface{}{"QTY": 1.5}}, "type"},
+		{"unknown field", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": map[string]interface{}{"NOPE": "x"}}, "additional"},
+		{"table as object", map[string]interface{}{"ORDER_TYPE": "TA", "ITEMS": map[string]interface{}{}}, "type"},
+		// Values coerceValue converts must pass as well.
+		{"CHAR as number", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": map[string]interface{}{"MATNR": json.Number("123")}}, ""},
+		{"NUMC as number", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": map[string]interface{}{"POSNR": json.Number("10")}}, ""},
+		{"INT as string", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": map[string]interface{}{"QTY": "5"}}, ""},
+		{"INT as non-numeric string", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": map[string]interface{}{"QTY": "five"}}, "pattern"},
+		{"null", map[string]interface{}{"ORDER_TYPE": "TA", "DOC_DATE": nil, "AMOUNT": nil, "HEADER": map[string]interface{}{"QTY": nil}, "ITEMS": nil}, ""},
+		{"null structure", map[string]interface{}{"ORDER_TYPE": "TA", "HEADER": nil}, ""},
+		{"trailing minus", map[string]interface{}{"ORDER_TYPE": "TA", "AMOUNT": "12.50-"}, ""},
+		{"INT8 in 4-byte range", map[string]interface{}{"ORDER_TYPE": "TA", "COUNT": json.Number("2147483647")}, ""},
+		{"INT8 beyond 4-byte range", map[string]interface{}{"ORDER_TYPE": "TA", "COUNT": json.Number("2147483648")}, "maximum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInput(tt.params, desc)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+			if tt.wantErr == "" {
+				if _, err := coerceParams(tt.params, desc); err != nil {
+					t.Errorf("coerceParams: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseReadTableResult(t *testing.T) {
+	rows, err := parseReadTableResult(map[string]interface{}{
+		"FIELDS": []interface{}{
+			map[string]interface{}{"FIELDNAME": "MANDT", "OFFSET": "000000", "LENGTH": "000003"},
+			map[string]interface{}{"FIELDNAME": "MTEXT", "OFFSET": "000003", "LENGTH": "000010"},
+		},
+		"DATA": []interface{}{
+			map[string]interface{}{"WA": "000SAP AG    "},
+			map[string]interface{}{"WA": "100Te"},
+			map[string]interface{}{"WA": "1"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("parseReadTableResult: %v", err)
+	}
+	want := []map[string]string{
+		{"MANDT": "000", "MTEXT": "SAP AG"},
+		{"MANDT": "100", "MTEXT": "Te"},
+		{"MANDT": "1", "MTEXT": ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+
+	if _, err := parseReadTableResult(map[string]interface{}{"DATA": "nope"}); err == nil {
+		t.Error("expected an error for a malformed DATA table")
+	}
+}
+
+func TestOptionsLines(t *testing.T) {
+	known := map[string]ddicField{"BUKRS": {Name: "BUKRS"}, "BUTXT": {Name: "BUTXT"}}
+	tokens, err := whereTokens([]tableFilter{
+		{Field: "bukrs", Op: "in", Value: []interface{}{"1000", "2000", "3000", "4000", "5000", "6000", "7000", "8000"}},
+		{Field: "BUTXT", Op: "like", Value: "O'Neil%"},
+	}, known)
+	if err != nil {
+		t.Fatalf("whereTokens: %v", err)
+	}
+	lines, err := optionsLines(tokens)
+	if err != nil {
+		t.Fatalf("optionsLines: %v", err)
+	}
+	var all []string
+	for _, l := range lines {
+		text := l.(map[string]interface{})["TEXT"].(string)
+		if len(text) > optionsLineWidth {
+			t.Errorf("line %q exceeds %d characters", text, optionsLineWidth)
+		}
+		all = append(all, text)
+	}
+	if got := strings.Join(all, " "); !strings.Contains(got, "BUTXT LIKE 'O''Neil%'") {
+		t.Errorf("WHERE = %q, want an escaped literal", got)
+	}
+}
+
+func TestConvertDDICValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		typ  string
+		want interface{}
+	}{
+		{"42", "I", int64(42)},
+		{"12.50-", "P", json.Number("-12.50")},
+		{"", "P", json.Number("0")},
+		{"20240301", "D", "2024-03-01"},
+		{"00000000", "D", nil},
+		{"134500", "T", "13:45:00"},
+		{"abc", "C", "abc"},
+	}
+	for _, tt := range tests {
+		if got := convertDDICValue(tt.raw, ddicField{IntType: tt.typ}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertDDICValue(%q, %s) = %#v, want %#v", tt.raw, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := &callPolicy{ReadOnly: true, Deny: []string{"RFC_READ_TABLE"}, ReadOnlyFunctions: []string{"Z_REPORT_?"}}
+	for fn, allowed := range map[string]bool{
+		"BAPI_USER_GET_DETAIL": true,
+		"bapi_user_getlist":    true,
+		"RFC_READ_TABLE":       false,
+		"BAPI_USER_DELETE":     false,
+		"Z_REPORT_1":           true,
+		"Z_REPORT_10":          false,
+	} {
+		if err := p.check(fn); (err == nil) != allowed {
+			t.Errorf("check(%s) = %v, want allowed=%t", fn, err, allowed)
+		}
+	}
+}
+
+func TestPolicyWithin(t *testing.T) {
+	global := &callPolicy{ReadOnly: true, Allow: []string{"BAPI_*", "RFC_*"}, Deny: []string{"*_DELETE*"}}
+	r, err := newSystemRegistry([]systemConfig{
+		{Name: "DEV", Dest: "DEV"},
+		// Tries to lift the global deny list, allow-list and read-only mode.
+		{Name: "PRD", Dest: "PRD", Policy: &callPolicy{Allow: []string{"BAPI_*", "Z_*"}, ReadOnlyFunctions: []string{"BAPI_USER_DELETE"}}},
+	}, "", registryOptions{Policy: global})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		system, fn string
+		allowed    bool
+	}{
+		{"DEV", "RFC_PING", true},
+		{"DEV", "BAPI_USER_GETLIST", true},
+		{"PRD", "BAPI_USER_GETLIST", true},
+		{"PRD", "RFC_PING", false},         // not on PRD's allow-list
+		{"PRD", "Z_REPORT", false},         // not on the global allow-list
+		{"PRD", "BAPI_USER_DELETE", false}, // globally denied
+		{"PRD", "BAPI_USER_CHANGE", false}, // read-only
+	} {
+		if err := r.systems[tc.system].policy.check(tc.fn); (err == nil) != tc.allowed {
+			t.Errorf("%s: check(%s) = %v, want allowed=%t", tc.system, tc.fn, err, tc.allowed)
+		}
+	}
+	for _, entry := range r.list() {
+		if entry["read_only"] != true {
+			t.Errorf("list_systems entry %v is not read-only", entry)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	logon := rfcErr("RFC_LOGON_FAILURE", "", "logon failed")
+	logon.ErrorInfo.Message = "Name or password is incorrect"
+	dump := rfcErr("RFC_ABAP_RUNTIME_FAILURE", "CONVT_NO_NUMBER", "call failed")
+	for _, tc := range []struct {
+		err       error
+		class     string
+		key       string
+		retryable bool
+	}{
+		{rfcErr("RFC_COMMUNICATION_FAILURE", "", "partner not reached"), errCommunication, "", true},
+		{fmt.Errorf("describe: %w", rfcErr("RFC_INVALID_HANDLE", "", "handle gone")), errCommunication, "", true},
+		{logon, errLogon, "", false},
+		{rfcErr("RFC_ABAP_EXCEPTION", "NOT_FOUND", "NOT_FOUND"), errABAPException, "NOT_FOUND", false},
+		{dump, errABAPRuntime, "CONVT_NO_NUMBER", false},
+		{rfcErr("RFC_ABAP_MESSAGE", "", "message E"), errSystemFailure, "", false},
+		{rfcErr("RFC_AUTHORIZATION_FAILURE", "", "no S_RFC"), errAuthorization, "", false},
+		{rfcErr("RFC_ABAP_RUNTIME_FAILURE", "RFC_NO_AUTHORITY", "User TESTER has no RFC authorization for function module Z_X"), errAuthorization, "RFC_NO_AUTHORITY", false},
+		{rfcErr("RFC_ABAP_EXCEPTION", "NOT_AUTHORIZED", "NOT_AUTHORIZED"), errAuthorization, "NOT_AUTHORIZED", false},
+		{rfcErr("RFC_ABAP_MESSAGE", "", "HANDLE_MISMATCH: handle does not belong to the session"), errCommunication, "", true},
+		{&gorfc.GoRfcError{Description: "HANDLE_MISMATCH"}, errCommunication, "", true},
+		{&gorfc.GoRfcError{Description: gorfcNotConnected}, errCommunication, "", true},
+		{&gorfc.GoRfcError{Description: "Error parsing ABAP RFC_DATE field"}, errInvalidParameter, "", false},
+		{&invalidParameterError{errors.New("unknown parameter")}, errInvalidParameter, "", false},
+		{&policyError{Function: "Z_X", Reason: "denied"}, errAuthorization, "", false},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), errCancelled, "", false},
+		{errors.New("function_name is required"), errOther, "", false},
+	} {
+		info := classifyError(tc.err)
+		if info.Class != tc.class || info.Key != tc.key || info.Retryable != tc.retryable {
+			t.Errorf("classifyError(%v) = %+v, want class %s, key %q, retryable %t", tc.err, info, tc.class, tc.key, tc.retryable)
+		}
+	}
+	if got := classifyError(logon).Message; got != "Name or password is incorrect" {
+		t.Errorf("message = %q, want the SDK's message", got)
+	}
+}
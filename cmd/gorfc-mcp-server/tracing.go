@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ─── Tracing ──────────────────────────────────────────────────────────────────
+
+// tracingConfig configures the export of spans to an OpenTelemetry
+// collector over OTLP/HTTP with JSON encoding.
+type tracingConfig struct {
+	Endpoint string            // URL the spans are POSTed to
+	Headers  map[string]string // sent with every export, e.g. for authentication
+	Service  string            // service.name resource attribute
+}
+
+// tracingConfigFromEnv reads the standard OpenTelemetry variables. Tracing
+// is off when no endpoint is set.
+//
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT – traces URL, e.g. http://localhost:4318/v1/traces
+//	OTEL_EXPORTER_OTLP_ENDPOINT        – collector base URL; /v1/traces is appended
+//	OTEL_EXPORTER_OTLP_HEADERS         – extra headers, comma-separated key=value pairs
+//	OTEL_SERVICE_NAME                  – service name (default gorfc-mcp-server)
+func tracingConfigFromEnv() (tracingConfig, error) {
+	cfg := tracingConfig{
+		Endpoint: os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		Headers:  map[string]string{},
+		Service:  os.Getenv("OTEL_SERVICE_NAME"),
+	}
+	if cfg.Endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			cfg.Endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	if cfg.Service == "" {
+		cfg.Service = "gorfc-mcp-server"
+	}
+	for _, h := range splitList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+		k, v, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return cfg, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %q is not key=value", h)
+		}
+		cfg.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return cfg, nil
+}
+
+// Export batching: spans are sent when tracerBatchSize have ended or every
+// tracerFlushInterval. Spans ending while tracerQueueSize are waiting are
+// dropped.
+const (
+	tracerBatchSize     = 512
+	tracerFlushInterval = 2 * time.Second
+	tracerQueueSize     = 4096
+)
+
+// tracer records a span per tool call, with child spans started through
+// startSpan, and exports them in the background. Values hidden by redact are
+// scrubbed from span attributes and status messages before export. A nil
+// *tracer records nothing.
+type tracer struct {
+	cfg    tracingConfig
+	client *http.Client
+	redact *redactor
+	queue  chan *span
+	done   chan struct{}
+
+	mu      sync.Mutex
+	closed  bool  // queue is closed; spans ending later are dropped
+	dropped int64 // spans lost to a full queue since the last export
+}
+
+// newTracer starts the exporter of cfg, or returns nil if cfg has no
+// endpoint.
+func newTracer(cfg tracingConfig, redact *redactor) *tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	t := &tracer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		redact: redact,
+		queue:  make(chan *span, tracerQueueSize),
+		done:   make(chan struct{}),
+	}
+	go t.export()
+	return t
+}
+
+// close exports the spans still queued. Spans of tool calls still running
+// end unexported.
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	<-t.done
+}
+
+func (t *tracer) export() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracerFlushInterval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				t.send(batch)
+				return
+			}
+			if batch = append(batch, s); len(batch) >= tracerBatchSize {
+				t.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.send(batch)
+			batch = nil
+		}
+	}
+}
+
+// send POSTs batch to the collector. Failures are logged; the spans are lost.
+func (t *tracer) send(batch []*span) {
+	t.mu.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		logger.Warn("tracing: export queue full, spans dropped", "spans", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]interface{}, len(batch))
+	for i, s := range batch {
+		spans[i] = s.otlp()
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(map[string]interface{}{"service.name": t.cfg.Service}, nil)},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "gorfc-mcp-server"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		logger.Error("tracing export failed", "err", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("tracing export failed", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		logger.Error("tracing export failed", "spans", len(batch), "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logger.Error("tracing export failed", "spans", len(batch), "status", resp.Status)
+	}
+}
+
+// wrap records a span for every call of the tool name. The span continues
+// the trace of the W3C traceparent in the request's _meta or, over HTTP, in
+// its headers; a traceparent that is not sampled disables the span.
+func (t *tracer) wrap(name string, h mcp.ToolHandler) mcp.ToolHandler {
+	if t == nil {
+		return h
+	}
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var traceparent string
+		if req.Params != nil {
+			traceparent, _ = req.Params.Meta["traceparent"].(string)
+		}
+		if traceparent == "" && req.Extra != nil && req.Extra.Header != nil {
+			traceparent = req.Extra.Header.Get("traceparent")
+		}
+		s := &span{t: t, name: "tools/call " + name, kind: spanKindServer, start: time.Now()}
+		if traceID, parentID, sampled, ok := parseTraceparent(traceparent); ok {
+			if !sampled {
+				return h(ctx, req)
+			}
+			s.traceID, s.parentID = traceID, parentID
+		} else {
+			rand.Read(s.traceID[:])
+		}
+		rand.Read(s.spanID[:])
+		s.set("mcp.tool.name", name)
+		if id := requestID(ctx); id != "" {
+			s.set("mcp.request_id", id)
+		}
+		if req.Session != nil {
+			s.set("mcp.session.id", req.Session.ID())
+		}
+		res, err := h(context.WithValue(ctx, spanKey{}, s), req)
+		if class := toolErrorClass(res, err); class != "" {
+			s.set("error.type", class)
+			msg := class
+			if err != nil {
+				msg = err.Error()
+			} else if info, ok := res.StructuredContent.(rfcErrorInfo); ok {
+				msg = info.Message
+			}
+			s.fail(msg)
+		}
+		s.end()
+		return res, err
+	}
+}
+
+// parseTraceparent decodes a W3C traceparent header value.
+func parseTraceparent(v string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+// OTLP span kinds.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+type spanKey struct{}
+
+// span is one timed operation of a trace. A nil *span ignores all calls, so
+// code paths run without a tool span (startup, tests) need no checks.
+type span struct {
+	t                 *tracer
+	traceID           [16]byte
+	spanID, parentID  [8]byte
+	name              string
+	kind              int
+	start, finish     time.Time
+	mu                sync.Mutex
+	attrs             map[string]interface{}
+	failed            bool
+	statusDescription string
+}
+
+// startSpan starts a child of the span of ctx, if there is one, and returns
+// a context carrying it.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent, _ := ctx.Value(spanKey{}).(*span)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &span{t: parent.t, traceID: parent.traceID, parentID: parent.spanID, name: name, kind: spanKindInternal, start: time.Now()}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// spanFromContext returns the span of ctx, or nil.
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// set records an attribute: a string, bool, integer or float64.
+func (s *span) set(key string, val interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = val
+}
+
+// setKind marks s as a call to a remote system.
+func (s *span) setKind(kind int) {
+	if s != nil {
+		s.kind = kind
+	}
+}
+
+// fail marks s as failed with the description msg.
+func (s *span) fail(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed, s.statusDescription = true, msg
+}
+
+// failOn marks s as failed if err is not nil.
+func (s *span) failOn(err error) {
+	if err != nil {
+		s.fail(err.Error())
+	}
+}
+
+// end finishes s and queues it for export.
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.finish = time.Now()
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	if s.t.closed {
+		return
+	}
+	select {
+	case s.t.queue <- s:
+	default:
+		s.t.dropped++
+	}
+}
+
+// otlp returns s in the OTLP JSON encoding, with the values hidden by the
+// tracer's redactor scrubbed.
+func (s *span) otlp() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.finish.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs, s.t.redact),
+	}
+	if s.parentID != [8]byte{} {
+		out["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.failed {
+		out["status"] = map[string]interface{}{"code": 2, "message": s.t.redact.scrub(s.statusDescription)}
+	}
+	return out
+}
+
+func otlpAttributes(attrs map[string]interface{}, redact *redactor) []interface{} {
+	out := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		var val map[string]interface{}
+		switch x := v.(type) {
+		case string:
+			val = map[string]interface{}{"stringValue": redact.scrub(x)}
+		case bool:
+			val = map[string]interface{}{"boolValue": x}
+		case int:
+			val = map[string]interface{}{"intValue": strconv.Itoa(x)}
+		case int64:
+			val = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			val = map[string]interface{}{"doubleValue": x}
+		default:
+			val = map[string]interface{}{"stringValue": redact.scrub(fmt.Sprint(x))}
+		}
+		out = append(out, map[string]interface{}{"key": k, "value": val})
+	}
+	return out
+}
+
+// traceSAP sets the attributes of the SAP system of cm on s.
+func traceSAP(s *span, cm *connManager) {
+	if s == nil {
+		return
+	}
+	s.set("sap.system", cm.system)
+	id := cm.identity()
+	if id.SystemID != "" {
+		s.set("sap.system_id", id.SystemID)
+	}
+	if id.Client != "" {
+		s.set("sap.client", id.Client)
+	}
+}
+
+// resultRows returns the number of rows in the table parameters of result.
+func resultRows(result map[string]interface{}) int {
+	n := 0
+	for _, v := range result {
+		if rows, ok := v.([]interface{}); ok {
+			n += len(rows)
+		}
+	}
+	return n
+}